@@ -59,7 +59,7 @@ func (c *Correlator) TrackEvent(event models.RuntimeEvent, ruleID, groupKey stri
 	}
 
 	// Add to sorted set with timestamp as score
-	score := float64(event.Timestamp.Unix())
+	score := float64(eventTime(event).Unix())
 	if err := c.rdb.ZAdd(c.ctx, key, redis.Z{Score: score, Member: string(data)}).Err(); err != nil {
 		return err
 	}
@@ -74,6 +74,16 @@ func (c *Correlator) TrackEvent(event models.RuntimeEvent, ruleID, groupKey stri
 	return nil
 }
 
+// eventTime is when the event happened on the correlator's clock. Events
+// from a node flagged for clock skew, and not corrected at ingest, use the
+// receive time so they aren't pruned early or kept too long.
+func eventTime(event models.RuntimeEvent) time.Time {
+	if event.ClockSkewMs != 0 && event.SensorTimestamp == nil && event.ReceivedAt != nil {
+		return *event.ReceivedAt
+	}
+	return event.Timestamp
+}
+
 // CheckThreshold returns true if threshold is met
 func (c *Correlator) CheckThreshold(ruleID, groupKey string, threshold int, windowSecs int64) (bool, []string, error) {
 	key := fmt.Sprintf("corr:%s:%s", ruleID, groupKey)
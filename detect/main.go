@@ -1,82 +1,68 @@
 package main
 
 import (
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/podwatch/podwatch/detect/matcher"
 	"github.com/podwatch/podwatch/pkg/logging"
 	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
 )
 
 var (
 	natsConn *nats.Conn
+	js       jetstream.JetStream
 	logger   *logging.Logger
 )
 
+// defaultRules is rules/default.yaml, used unless RULES_FILE is set
+//
+//go:embed rules/default.yaml
+var defaultRules []byte
+
 // Rule to attack type mapping
 var ruleAttackType = map[string]string{
-	"rule-1": logging.AttackShellSpawn,
-	"rule-2": logging.AttackTokenTheft,
-	"rule-3": logging.AttackReverseShell,
-	"rule-4": logging.AttackPrivilegeEscalation,
-	"rule-5": logging.AttackPackageInstall,
+	"rule-shell-spawn":          logging.AttackShellSpawn,
+	"rule-web-shell":            logging.AttackShellSpawn,
+	"rule-token-read":           logging.AttackTokenTheft,
+	"rule-reverse-shell":        logging.AttackReverseShell,
+	"rule-shell-unexpected-asn": logging.AttackReverseShell,
+	"rule-priv-esc":             logging.AttackPrivilegeEscalation,
+	"rule-pkg-manager":          logging.AttackPackageInstall,
+	"rule-crypto-miner":         logging.AttackCryptoMining,
+	"rule-kubectl-exec":         logging.AttackLateralMovement,
+	"rule-k8s-pod-exec":         logging.AttackLateralMovement,
+	"rule-k8s-secrets-list":     logging.AttackCredentialAccess,
+	"rule-k8s-rolebinding":      logging.AttackPersistence,
 }
 
 func main() {
 	logger = logging.NewLogger("podwatch-detect", "engine")
 
 	// 1. Rules
-	rules := []models.Rule{
-		{
-			ID:          "rule-1",
-			Name:        "Shell Spawn in Prod",
-			Description: "Bash or sh spawned in production namespace",
-			Severity:    "high",
-			Condition:   `event.process.exe in ['/bin/bash', '/bin/sh', '/usr/bin/bash'] && event.container.namespace == 'prod'`,
-			Response:    "kill_pod",
-			Enabled:     true,
-		},
-		{
-			ID:          "rule-2",
-			Name:        "Service Account Token Read",
-			Description: "Process reading service account token",
-			Severity:    "high",
-			Condition:   `event.event_type == 'file_open' && event.process.cmdline.contains('/var/run/secrets/kubernetes.io/serviceaccount/token')`,
-			Response:    "quarantine_namespace",
-			Enabled:     true,
-		},
-		{
-			ID:          "rule-3",
-			Name:        "Reverse Shell",
-			Description: "Network connection to external IP with shell process",
-			Severity:    "critical",
-			Condition:   `event.event_type == 'network_connect' && (event.process.exe.endsWith('bash') || event.process.exe.endsWith('sh')) && event.network.dst_ip != '' && !event.network.dst_ip.startsWith('10.') && !event.network.dst_ip.startsWith('192.168.') && !event.network.dst_ip.startsWith('172.')`,
-			Response:    "kill_pod",
-			Enabled:     true,
-		},
-		{
-			ID:          "rule-4",
-			Name:        "Privilege Escalation",
-			Description: "Container added sensitive capabilities",
-			Severity:    "critical",
-			Condition:   `event.process.capabilities_added.exists(c, c == 'SYS_ADMIN' || c == 'NET_ADMIN')`,
-			Response:    "isolate_node",
-			Enabled:     true,
-		},
-		{
-			ID:          "rule-5",
-			Name:        "Package Manager in Prod",
-			Description: "apt or apk executed in prod",
-			Severity:    "medium",
-			Condition:   `event.process.exe in ['/usr/bin/apt', '/sbin/apk', '/usr/bin/yum'] && event.container.namespace == 'prod'`,
-			Response:    "",
-			Enabled:     true,
-		},
+	rulesFile := os.Getenv("RULES_FILE")
+	rulesData := defaultRules
+	var err error
+	if rulesFile != "" {
+		if rulesData, err = os.ReadFile(rulesFile); err != nil {
+			logger.Error("Failed to read rules", err, map[string]interface{}{"rules_file": rulesFile})
+			os.Exit(1)
+		}
+	}
+	rules, err := matcher.LoadRules(rulesData)
+	if err != nil {
+		logger.Error("Failed to load rules", err, map[string]interface{}{"rules_file": rulesFile})
+		os.Exit(1)
 	}
 
 	// 2. Engine
@@ -104,16 +90,24 @@ func main() {
 	}
 	defer natsConn.Close()
 
+	js, err = pipeline.New(natsConn)
+	if err != nil {
+		logger.Error("Failed to set up JetStream", err, nil)
+		os.Exit(1)
+	}
+
 	logger.Info("Detection engine started", map[string]interface{}{
 		"rules_loaded": len(rules),
 		"nats_url":     natsURL,
 	})
 
 	// 4. Subscribe
-	handleEvent := func(msg *nats.Msg) {
+	handleEvent := func(msg jetstream.Msg) {
 		var event models.RuntimeEvent
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+		if err := json.Unmarshal(msg.Data(), &event); err != nil {
 			logger.Error("Failed to decode event", err, nil)
+			pipeline.DeadLetter(js, "detect", msg, err, nil)
+			msg.Term()
 			return
 		}
 
@@ -123,24 +117,29 @@ func main() {
 			logger.Error("Rule evaluation failed", err, map[string]interface{}{
 				"event_id": event.EventID,
 			})
+			pipeline.DeadLetter(js, "detect", msg, err, &models.DeadLetterSource{
+				ClusterID: event.ClusterID,
+				NodeID:    event.NodeID,
+			})
+			msg.Term()
 			return
 		}
 
 		for _, alert := range alerts {
-			alert.ID = uuid.New().String()
+			alert.ID = alertID(&event, msg.Data(), alert.RuleID)
 			alert.Timestamp = time.Now().UTC()
 
 			// Build target info from event
 			target := buildTargetInfo(&event)
 
 			// Get attack type and build attack context
-			attackType := ruleAttackType[alert.RuleName]
+			attackType := ruleAttackType[alert.RuleID]
 			if attackType == "" {
 				attackType = "unknown"
 			}
 
 			indicators := buildIndicators(&event)
-			attackCtx := logging.GetAttackContext(attackType, alert.RuleName, alert.ID, indicators)
+			attackCtx := logging.GetAttackContext(attackType, alert.RuleName, alert.RuleID, indicators)
 			attackCtx.Severity = alert.Severity
 
 			// Log the attack detection
@@ -161,35 +160,64 @@ func main() {
 			})
 
 			data, _ := json.Marshal(alert)
-			if err := natsConn.Publish("alerts", data); err != nil {
+			if err := pipeline.Publish(js, pipeline.SubjectAlerts, data, jetstream.WithMsgID(alert.ID)); err != nil {
 				logger.Error("Failed to publish alert", err, map[string]interface{}{
 					"alert_id": alert.ID,
 				})
+				// Redeliver the event so the alert isn't lost
+				pipeline.Retry(js, "detect", msg, err, &models.DeadLetterSource{
+					ClusterID: event.ClusterID,
+					NodeID:    event.NodeID,
+				})
+				return
 			}
 		}
+		msg.Ack()
 	}
 
 	// Subscribe to enriched events
-	_, err = natsConn.QueueSubscribe("events.enriched", "detect-workers", handleEvent)
+	_, err = pipeline.Consume(js, pipeline.StreamEventsEnriched, pipeline.ConsumerDetect, pipeline.SubjectEventsEnriched, handleEvent)
 	if err != nil {
 		logger.Error("Failed to subscribe to enriched events", err, nil)
 		os.Exit(1)
 	}
 
-	// Also subscribe to raw events for demo without enrich service
-	_, err = natsConn.Subscribe("events.raw.>", handleEvent)
-	if err != nil {
-		logger.Error("Failed to subscribe to raw events", err, nil)
+	// Without enrich, nothing reaches events.enriched, so raw events are
+	// evaluated instead. With enrich running this would alert twice on every
+	// match, so it is off by default, and a consumer left over from when it
+	// was on is removed so the raw stream doesn't keep events for it.
+	streams := []string{pipeline.SubjectEventsEnriched}
+	if rawEvents, _ := strconv.ParseBool(os.Getenv("DETECT_RAW_EVENTS")); rawEvents {
+		_, err = pipeline.Consume(js, pipeline.StreamEventsRaw, pipeline.ConsumerDetectRaw, pipeline.SubjectEventsRaw, handleEvent)
+		if err != nil {
+			logger.Error("Failed to subscribe to raw events", err, nil)
+			os.Exit(1)
+		}
+		streams = append(streams, pipeline.SubjectEventsRaw)
+	} else if err := pipeline.DeleteConsumer(js, pipeline.StreamEventsRaw, pipeline.ConsumerDetectRaw); err != nil {
+		logger.Error("Failed to remove raw event consumer", err, nil)
 		os.Exit(1)
 	}
 
 	logger.Info("Subscribed to event streams", map[string]interface{}{
-		"streams": []string{"events.enriched", "events.raw.>"},
+		"streams": streams,
 	})
 
 	select {}
 }
 
+// alertID derives an alert's ID from the event and the rule that matched it,
+// so the alerts of a redelivered event get the same IDs and JetStream drops
+// the ones already published. Events without an event_id are identified by
+// their payload.
+func alertID(event *models.RuntimeEvent, data []byte, ruleID string) string {
+	name := data
+	if event.EventID != "" {
+		name = []byte(event.ClusterID + "/" + event.NodeID + "/" + event.EventID)
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, append([]byte(ruleID+"/"), name...)).String()
+}
+
 func buildTargetInfo(event *models.RuntimeEvent) *logging.TargetInfo {
 	target := &logging.TargetInfo{
 		ClusterID: event.ClusterID,
@@ -203,6 +231,9 @@ func buildTargetInfo(event *models.RuntimeEvent) *logging.TargetInfo {
 		target.Image = event.Container.Image
 		target.ImageDigest = event.Container.ImageDigest
 		target.ServiceAccount = event.Container.ServiceAccount
+		if event.Container.WorkloadKind != "" {
+			target.Workload = event.Container.WorkloadKind + "/" + event.Container.WorkloadName
+		}
 	}
 
 	if event.Process != nil {
@@ -219,6 +250,16 @@ func buildTargetInfo(event *models.RuntimeEvent) *logging.TargetInfo {
 		target.Protocol = event.Network.Proto
 	}
 
+	if event.K8sAudit != nil {
+		target.User = event.K8sAudit.User
+		if target.Namespace == "" {
+			target.Namespace = event.K8sAudit.Namespace
+		}
+		if len(event.K8sAudit.SourceIPs) > 0 {
+			target.SourceIP = event.K8sAudit.SourceIPs[0]
+		}
+	}
+
 	return target
 }
 
@@ -240,6 +281,14 @@ func buildIndicators(event *models.RuntimeEvent) []string {
 		for _, cap := range event.Process.CapabilitiesAdded {
 			indicators = append(indicators, "capability:"+cap)
 		}
+		if len(event.Process.Ancestors) > 0 {
+			// Oldest first, e.g. nginx > php-fpm > sh
+			chain := []string{path.Base(event.Process.Exe)}
+			for _, a := range event.Process.Ancestors {
+				chain = append([]string{path.Base(a.Exe)}, chain...)
+			}
+			indicators = append(indicators, "process_tree:"+strings.Join(chain, " > "))
+		}
 	}
 
 	if event.Network != nil && event.Network.DstIP != "" {
@@ -249,9 +298,21 @@ func buildIndicators(event *models.RuntimeEvent) []string {
 		}
 	}
 
+	if a := event.K8sAudit; a != nil {
+		indicators = append(indicators, "k8s_user:"+a.User)
+		resource := a.Resource
+		if a.Subresource != "" {
+			resource += "/" + a.Subresource
+		}
+		indicators = append(indicators, "k8s_request:"+a.Verb+" "+resource)
+	}
+
 	if event.Container != nil {
 		indicators = append(indicators, "namespace:"+event.Container.Namespace)
 		indicators = append(indicators, "image:"+event.Container.Image)
+		if event.Container.WorkloadKind != "" {
+			indicators = append(indicators, "workload:"+event.Container.WorkloadKind+"/"+event.Container.WorkloadName)
+		}
 	}
 
 	return indicators
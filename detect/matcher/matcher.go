@@ -9,6 +9,7 @@ import (
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
 	"github.com/podwatch/podwatch/pkg/models"
+	"gopkg.in/yaml.v3"
 )
 
 type RuleEngine struct {
@@ -18,6 +19,17 @@ type RuleEngine struct {
 	env      *cel.Env
 }
 
+// LoadRules parses a rules file such as rules/default.yaml
+func LoadRules(data []byte) ([]models.Rule, error) {
+	var file struct {
+		Rules []models.Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+	return file.Rules, nil
+}
+
 func NewRuleEngine(rules []models.Rule) (*RuleEngine, error) {
 	// Define the environment
 	// We expose "event" as a map for flexibility
@@ -108,6 +120,7 @@ func (re *RuleEngine) Evaluate(event models.RuntimeEvent) ([]models.Alert, error
 		if ok && match {
 			alerts = append(alerts, models.Alert{
 				// ID generated later
+				RuleID:      rule.ID,
 				RuleName:    rule.Name,
 				Severity:    rule.Severity,
 				Description: rule.Description,
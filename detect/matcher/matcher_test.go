@@ -59,6 +59,9 @@ func TestRuleEngine_ShellSpawn(t *testing.T) {
 	if alerts[0].RuleName != "Shell Spawn in Prod" {
 		t.Errorf("Expected rule name 'Shell Spawn in Prod', got '%s'", alerts[0].RuleName)
 	}
+	if alerts[0].RuleID != "rule-shell-spawn" {
+		t.Errorf("Expected rule ID 'rule-shell-spawn', got '%s'", alerts[0].RuleID)
+	}
 
 	if alerts[0].Severity != "high" {
 		t.Errorf("Expected severity 'high', got '%s'", alerts[0].Severity)
@@ -146,6 +149,58 @@ func TestRuleEngine_PrivilegeEscalation(t *testing.T) {
 	}
 }
 
+func TestRuleEngine_K8sAudit(t *testing.T) {
+	rules := []models.Rule{
+		{
+			ID:        "rule-k8s-pod-exec",
+			Name:      "Exec into Production Pod",
+			Severity:  "high",
+			Condition: `event.event_type == 'k8s_audit' && event.k8s_audit.resource == 'pods' && event.k8s_audit.subresource == 'exec' && event.k8s_audit.namespace == 'prod'`,
+			Enabled:   true,
+		},
+		{
+			ID:        "rule-shell-spawn",
+			Name:      "Shell Spawn in Prod",
+			Severity:  "high",
+			Condition: `event.process.exe == '/bin/bash' && event.container.namespace == 'prod'`,
+			Enabled:   true,
+		},
+	}
+
+	engine, err := NewRuleEngine(rules)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	// Audit events have no process, so only the audit rule may match
+	event := models.RuntimeEvent{
+		Timestamp: time.Now(),
+		EventType: "k8s_audit",
+		K8sAudit: &models.K8sAuditInfo{
+			User:         "alice@example.com",
+			Verb:         "create",
+			Resource:     "pods",
+			Subresource:  "exec",
+			Namespace:    "prod",
+			Name:         "vuln-nginx-7c9b",
+			ResponseCode: 101,
+		},
+		Container: &models.ContainerInfo{
+			Pod:       "vuln-nginx-7c9b",
+			Namespace: "prod",
+		},
+	}
+
+	alerts, err := engine.Evaluate(event)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+
+	if len(alerts) != 1 || alerts[0].RuleName != "Exec into Production Pod" {
+		t.Fatalf("Expected only the audit rule to match, got %+v", alerts)
+	}
+}
+
 func TestRuleEngine_FromFixture(t *testing.T) {
 	// Load fixture
 	fixturePath := filepath.Join("..", "..", "test", "fixtures", "shell_spawn_event.json")
@@ -184,6 +239,211 @@ func TestRuleEngine_FromFixture(t *testing.T) {
 	}
 }
 
+// defaultRule loads one rule from the shipped rules file, enabled even if
+// it's disabled by default
+func defaultRule(t *testing.T, id string) *RuleEngine {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "rules", "default.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read default rules: %v", err)
+	}
+	file, err := LoadRules(data)
+	if err != nil {
+		t.Fatalf("Failed to parse default rules: %v", err)
+	}
+	var rules []models.Rule
+	for _, r := range file {
+		if r.ID == id {
+			r.Enabled = true
+			rules = append(rules, r)
+		}
+	}
+	engine, err := NewRuleEngine(rules)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	return engine
+}
+
+func TestLoadRules_Default(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "rules", "default.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read default rules: %v", err)
+	}
+	rules, err := LoadRules(data)
+	if err != nil {
+		t.Fatalf("Failed to parse default rules: %v", err)
+	}
+	if len(rules) == 0 {
+		t.Fatal("Expected default rules")
+	}
+	// detect runs these when RULES_FILE isn't set, so all must compile
+	if _, err := NewRuleEngine(rules); err != nil {
+		t.Errorf("Expected the default rules to compile, got %v", err)
+	}
+}
+
+func TestRuleEngine_ReverseShellDestination(t *testing.T) {
+	engine := defaultRule(t, "rule-reverse-shell")
+
+	tests := []struct {
+		name    string
+		network models.NetworkInfo
+		match   bool
+	}{
+		{"unenriched public IP", models.NetworkInfo{DstIP: "203.0.113.50", DstPort: 4444}, true},
+		{"unenriched private IP", models.NetworkInfo{DstIP: "10.0.0.12", DstPort: 4444}, false},
+		// A private range outside the cluster, e.g. another VPC
+		{"external private IP", models.NetworkInfo{DstIP: "10.0.0.12", DstPort: 4444, DstKind: "external"}, true},
+		{"in-cluster service", models.NetworkInfo{DstIP: "203.0.113.50", DstPort: 443, DstKind: "service", DstName: "api", DstNamespace: "prod"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := tt.network
+			event := models.RuntimeEvent{
+				EventType: "network_connect",
+				Process:   &models.ProcessInfo{Exe: "/bin/bash"},
+				Network:   &network,
+			}
+			alerts, err := engine.Evaluate(event)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if (len(alerts) == 1) != tt.match {
+				t.Errorf("Expected match=%v, got %d alerts", tt.match, len(alerts))
+			}
+		})
+	}
+}
+
+func TestRuleEngine_TokenReadFilePath(t *testing.T) {
+	engine := defaultRule(t, "rule-token-read")
+
+	tests := []struct {
+		name     string
+		cmdline  string
+		metadata map[string]string
+		match    bool
+	}{
+		{"opened file", "python3 app.py", map[string]string{"file.path": "/var/run/secrets/kubernetes.io/serviceaccount/token"}, true},
+		{"path in argv", "cat /var/run/secrets/kubernetes.io/serviceaccount/token", nil, true},
+		{"other file", "python3 app.py", map[string]string{"file.path": "/etc/hosts"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := models.RuntimeEvent{
+				EventType: "file_open",
+				Process:   &models.ProcessInfo{Exe: "/usr/bin/python3", Cmdline: tt.cmdline},
+				Metadata:  tt.metadata,
+			}
+			alerts, err := engine.Evaluate(event)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if (len(alerts) == 1) != tt.match {
+				t.Errorf("Expected match=%v, got %d alerts", tt.match, len(alerts))
+			}
+		})
+	}
+}
+
+func TestRuleEngine_CryptoMinerIntel(t *testing.T) {
+	engine := defaultRule(t, "rule-crypto-miner")
+
+	pool := models.IntelMatch{Feed: "mining-pools", Type: "domain", Indicator: "minexmr.com", Field: "process.cmdline", Confidence: 90, Labels: []string{"mining"}}
+	c2 := models.IntelMatch{Feed: "c2", Type: "ip", Indicator: "203.0.113.50", Field: "network.dst_ip", Confidence: 80}
+	tests := []struct {
+		name    string
+		cmdline string
+		intel   *models.IntelInfo
+		match   bool
+	}{
+		{"known pool without intel", "miner -o pool.minexmr.com:4444", nil, true},
+		{"no intel", "miner -o pool.example.net:4444", nil, false},
+		{"mining pool", "miner -o pool.example.net:4444", &models.IntelInfo{Matches: []models.IntelMatch{c2, pool}}, true},
+		{"unlabelled match", "miner -o pool.example.net:4444", &models.IntelInfo{Matches: []models.IntelMatch{c2}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := models.RuntimeEvent{
+				EventType: "process_exec",
+				Process:   &models.ProcessInfo{Exe: "/usr/bin/miner", Cmdline: tt.cmdline},
+				Intel:     tt.intel,
+			}
+			alerts, err := engine.Evaluate(event)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if (len(alerts) == 1) != tt.match {
+				t.Errorf("Expected match=%v, got %d alerts", tt.match, len(alerts))
+			}
+		})
+	}
+}
+
+func TestRuleEngine_UnexpectedASN(t *testing.T) {
+	engine := defaultRule(t, "rule-shell-unexpected-asn")
+
+	tests := []struct {
+		name    string
+		network models.NetworkInfo
+		match   bool
+	}{
+		{"no ASN", models.NetworkInfo{DstIP: "10.0.0.12", DstPort: 443}, false},
+		{"cloud provider", models.NetworkInfo{DstIP: "52.94.236.248", DstPort: 443, DstASN: 16509, DstASOrg: "AMAZON-02"}, false},
+		{"elsewhere", models.NetworkInfo{DstIP: "203.0.113.50", DstPort: 4444, DstCountry: "NL", DstASN: 64500, DstASOrg: "Example Hosting"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := tt.network
+			event := models.RuntimeEvent{
+				EventType: "network_connect",
+				Process:   &models.ProcessInfo{Exe: "/bin/sh"},
+				Network:   &network,
+			}
+			alerts, err := engine.Evaluate(event)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if (len(alerts) == 1) != tt.match {
+				t.Errorf("Expected match=%v, got %d alerts", tt.match, len(alerts))
+			}
+		})
+	}
+}
+
+func TestRuleEngine_WebShellAncestry(t *testing.T) {
+	engine := defaultRule(t, "rule-web-shell")
+
+	tests := []struct {
+		name      string
+		ancestors []models.ProcessAncestor
+		match     bool
+	}{
+		{"no ancestry", nil, false},
+		{"web shell", []models.ProcessAncestor{
+			{PID: 40, Exe: "/usr/sbin/php-fpm8.2", Cmdline: "php-fpm: pool www"},
+			{PID: 1, Exe: "/usr/sbin/nginx", Cmdline: "nginx: master process"},
+		}, true},
+		{"entrypoint script", []models.ProcessAncestor{{PID: 1, Exe: "/usr/bin/tini", Cmdline: "tini -- run.sh"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := models.RuntimeEvent{
+				EventType: "process_exec",
+				Process:   &models.ProcessInfo{PID: 41, PPID: 40, Exe: "/bin/sh", Cmdline: "sh -c id", Ancestors: tt.ancestors},
+			}
+			alerts, err := engine.Evaluate(event)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if (len(alerts) == 1) != tt.match {
+				t.Errorf("Expected match=%v, got %d alerts", tt.match, len(alerts))
+			}
+		})
+	}
+}
+
 func BenchmarkRuleEvaluation(b *testing.B) {
 	rules := []models.Rule{
 		{ID: "r1", Name: "Rule 1", Condition: `event.process.exe == '/bin/bash'`, Enabled: true},
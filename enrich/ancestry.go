@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+)
+
+const (
+	// DefaultAncestryDepth is how many ancestors are attached to an event
+	DefaultAncestryDepth = 8
+	// DefaultProcessTableMax bounds the processes kept per container
+	DefaultProcessTableMax = 1024
+	// DefaultProcessTablesMax bounds the containers processes are kept for
+	DefaultProcessTablesMax = 4096
+	// DefaultProcessTTL is how long a process that hasn't been seen, in its
+	// own events or as an ancestor, is kept
+	DefaultProcessTTL = time.Hour
+)
+
+// ProcessBucket is the key-value bucket enrich replicas share their process
+// tables through
+const ProcessBucket = "PROCESSES"
+
+const processBucketTimeout = 5 * time.Second
+
+// processShareBuffer is how many records can wait to be written to the
+// bucket before more are dropped
+const processShareBuffer = 4096
+
+var processStats = expvar.NewMap("enrich_processes")
+
+// procEntry is what's known about a process from its events
+type procEntry struct {
+	ppid     int
+	exe      string
+	cmdline  string
+	updated  time.Time // when exe and cmdline were recorded
+	lastSeen time.Time
+	shared   time.Time // when the bucket last had the entry
+}
+
+// processRecord is a process table entry as stored in the bucket
+type processRecord struct {
+	Table    string    `json:"table"`
+	PID      int       `json:"pid"`
+	PPID     int       `json:"ppid"`
+	Exe      string    `json:"exe,omitempty"`
+	Cmdline  string    `json:"cmdline,omitempty"`
+	Updated  time.Time `json:"updated"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// procTable is one container's processes
+type procTable struct {
+	procs    map[int]*procEntry
+	lastSeen time.Time
+}
+
+// ProcessTree keeps a process table per container, built from the events
+// enrich sees, and attaches each event's ancestor chain. process_exec events
+// replace a PID's entry, since exec changes the image and PIDs get reused;
+// other events only add processes that haven't been seen yet, e.g. ones
+// started before enrich. At most maxTables tables are kept, dropping the
+// least recently seen.
+//
+// Replicas share the consumer, so a container's events are spread over all
+// of them. Once Share is called, each replica also writes the processes it
+// records to the PROCESSES bucket in the background and applies every other
+// replica's, so all replicas build the same tables. Entries still in use are
+// written again every half TTL so they don't expire from the bucket.
+type ProcessTree struct {
+	depth     int
+	maxProcs  int
+	maxTables int
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	tables  map[string]*procTable
+	shareCh chan processRecord // records waiting to be written, once shared
+}
+
+func NewProcessTree(depth, maxProcs, maxTables int, ttl time.Duration) *ProcessTree {
+	t := &ProcessTree{
+		depth:     depth,
+		maxProcs:  maxProcs,
+		maxTables: maxTables,
+		ttl:       ttl,
+		now:       time.Now,
+		tables:    make(map[string]*procTable),
+	}
+	processStats.Set("processes", expvar.Func(func() interface{} { return t.Len() }))
+	processStats.Set("tables", expvar.Func(func() interface{} {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return len(t.tables)
+	}))
+	return t
+}
+
+// tableKey scopes PIDs to a container, or to the host for events from
+// outside containers
+func tableKey(event *models.RuntimeEvent) string {
+	key := event.ClusterID + "/" + event.NodeID
+	if event.Container != nil {
+		key += "/" + event.Container.ContainerID
+	}
+	return key
+}
+
+// Record adds or updates the event's process. It is called as soon as the
+// event is received, so that children published before an event held for a
+// pod cache retry still find their parent.
+func (t *ProcessTree) Record(event *models.RuntimeEvent) {
+	p := event.Process
+	if p == nil || p.PID <= 0 {
+		return
+	}
+	key := tableKey(event)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	table := t.table(key, now)
+	if e := table[p.PID]; e != nil && event.EventType != "process_exec" && now.Sub(e.lastSeen) <= t.ttl {
+		e.lastSeen = now
+		t.refresh(key, p.PID, e)
+	} else if p.Exe != "" || p.Cmdline != "" {
+		if e == nil && len(table) >= t.maxProcs {
+			evictOldest(table)
+		}
+		e = &procEntry{ppid: p.PPID, exe: p.Exe, cmdline: p.Cmdline, updated: now, lastSeen: now, shared: now}
+		table[p.PID] = e
+		t.share(e.record(key, p.PID))
+	}
+}
+
+// Attach sets event.Process.Ancestors, nearest first, from the processes
+// recorded so far. It is called when the event is published.
+func (t *ProcessTree) Attach(event *models.RuntimeEvent) bool {
+	p := event.Process
+	if p == nil || p.PID <= 0 {
+		return false
+	}
+	key := tableKey(event)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	p.Ancestors = nil
+	pt := t.tables[key]
+	if pt == nil {
+		return false
+	}
+	pt.lastSeen = now
+	seen := map[int]bool{p.PID: true}
+	for pid := p.PPID; pid > 0 && len(p.Ancestors) < t.depth && !seen[pid]; {
+		e := pt.procs[pid]
+		if e == nil || now.Sub(e.lastSeen) > t.ttl {
+			break
+		}
+		seen[pid] = true
+		e.lastSeen = now
+		t.refresh(key, pid, e)
+		p.Ancestors = append(p.Ancestors, models.ProcessAncestor{PID: pid, Exe: e.exe, Cmdline: e.cmdline})
+		pid = e.ppid
+	}
+	return len(p.Ancestors) > 0
+}
+
+// table returns the process table for key, creating it if needed, and marks
+// it seen at seen. t.mu must be held.
+func (t *ProcessTree) table(key string, seen time.Time) map[int]*procEntry {
+	pt := t.tables[key]
+	if pt == nil {
+		if len(t.tables) >= t.maxTables {
+			t.evictOldestTable()
+		}
+		pt = &procTable{procs: make(map[int]*procEntry)}
+		t.tables[key] = pt
+	}
+	if seen.After(pt.lastSeen) {
+		pt.lastSeen = seen
+	}
+	return pt.procs
+}
+
+// evictOldestTable drops the least recently seen table. t.mu must be held.
+func (t *ProcessTree) evictOldestTable() {
+	var oldest string
+	var oldestSeen time.Time
+	for key, pt := range t.tables {
+		if oldestSeen.IsZero() || pt.lastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = key, pt.lastSeen
+		}
+	}
+	delete(t.tables, oldest)
+	processStats.Add("evicted_tables", 1)
+}
+
+// refresh shares e again when the bucket's copy is due to be rewritten.
+// t.mu must be held.
+func (t *ProcessTree) refresh(key string, pid int, e *procEntry) {
+	if t.shareCh == nil || e.lastSeen.Sub(e.shared) < t.ttl/2 {
+		return
+	}
+	e.shared = e.lastSeen
+	t.share(e.record(key, pid))
+}
+
+func (e *procEntry) record(key string, pid int) processRecord {
+	return processRecord{
+		Table:    key,
+		PID:      pid,
+		PPID:     e.ppid,
+		Exe:      e.exe,
+		Cmdline:  e.cmdline,
+		Updated:  e.updated,
+		LastSeen: e.lastSeen,
+	}
+}
+
+// processKey is the bucket key for a process; the record itself carries the
+// table and PID
+func processKey(table string, pid int) string {
+	return pipeline.KeyToken(table) + "." + strconv.Itoa(pid)
+}
+
+// OpenProcessBucket creates or binds to the bucket process tables are shared
+// through. Entries expire after ttl, like processes in the tables.
+func OpenProcessBucket(js jetstream.JetStream, ttl time.Duration) (jetstream.KeyValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), processBucketTimeout)
+	defer cancel()
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      ProcessBucket,
+		Description: "PodWatch process tables",
+		History:     1,
+		TTL:         ttl,
+		Storage:     jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bucket %s: %w", ProcessBucket, err)
+	}
+	return kv, nil
+}
+
+// Share loads the processes other replicas recorded from kv, then keeps
+// applying their updates and writes this replica's to it in the background
+func (t *ProcessTree) Share(kv jetstream.KeyValue) error {
+	watcher, err := kv.WatchAll(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to watch bucket %s: %w", ProcessBucket, err)
+	}
+	ch := make(chan processRecord, processShareBuffer)
+	t.mu.Lock()
+	t.shareCh = ch
+	t.mu.Unlock()
+
+	go func() {
+		for rec := range ch {
+			writeProcess(kv, rec)
+		}
+	}()
+
+	go func() {
+		for entry := range watcher.Updates() {
+			// A nil entry marks the end of the initial values
+			if entry == nil || entry.Operation() != jetstream.KeyValuePut {
+				continue
+			}
+			var rec processRecord
+			if err := json.Unmarshal(entry.Value(), &rec); err != nil {
+				continue
+			}
+			t.apply(rec)
+		}
+	}()
+	return nil
+}
+
+// share queues rec to be written to the bucket, if the tree is shared.
+// Events never wait for the bucket: when the queue is full, rec is dropped
+// and other replicas miss it until it is next refreshed. t.mu must be held.
+func (t *ProcessTree) share(rec processRecord) {
+	if t.shareCh == nil {
+		return
+	}
+	select {
+	case t.shareCh <- rec:
+	default:
+		processStats.Add("share_dropped", 1)
+	}
+}
+
+// writeProcess writes rec to kv. Failures only cost other replicas this
+// one's view of the process, so they are counted and logged.
+func writeProcess(kv jetstream.KeyValue, rec processRecord) {
+	data, _ := json.Marshal(rec)
+	ctx, cancel := context.WithTimeout(context.Background(), processBucketTimeout)
+	defer cancel()
+	if _, err := kv.Put(ctx, processKey(rec.Table, rec.PID), data); err != nil {
+		processStats.Add("share_errors", 1)
+		log.Printf("Error sharing process %s/%d: %v", rec.Table, rec.PID, err)
+	}
+}
+
+// apply merges a record from the bucket: the most recently recorded exe and
+// cmdline win, and the entry is kept while any replica still sees it
+func (t *ProcessTree) apply(rec processRecord) {
+	if rec.PID <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.now().Sub(rec.LastSeen) > t.ttl {
+		return
+	}
+	table := t.table(rec.Table, rec.LastSeen)
+	e := table[rec.PID]
+	if e == nil {
+		if len(table) >= t.maxProcs {
+			evictOldest(table)
+		}
+		e = &procEntry{}
+		table[rec.PID] = e
+	}
+	if rec.Updated.After(e.updated) {
+		e.ppid = rec.PPID
+		e.exe = rec.Exe
+		e.cmdline = rec.Cmdline
+		e.updated = rec.Updated
+	}
+	if rec.LastSeen.After(e.lastSeen) {
+		e.lastSeen = rec.LastSeen
+	}
+	if rec.LastSeen.After(e.shared) {
+		e.shared = rec.LastSeen
+	}
+}
+
+func evictOldest(table map[int]*procEntry) {
+	oldest := -1
+	for pid, e := range table {
+		if oldest < 0 || e.lastSeen.Before(table[oldest].lastSeen) {
+			oldest = pid
+		}
+	}
+	delete(table, oldest)
+}
+
+// Sweep drops processes not seen within the TTL, and empty tables
+func (t *ProcessTree) Sweep() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for key, pt := range t.tables {
+		for pid, e := range pt.procs {
+			if now.Sub(e.lastSeen) > t.ttl {
+				delete(pt.procs, pid)
+			}
+		}
+		if len(pt.procs) == 0 {
+			delete(t.tables, key)
+		}
+	}
+}
+
+// Len returns the number of processes in all tables
+func (t *ProcessTree) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, pt := range t.tables {
+		n += len(pt.procs)
+	}
+	return n
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// testProcessTree returns a tree whose clock is advanced by the returned func
+func testProcessTree(depth, max int) (*ProcessTree, func(time.Duration)) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tree := NewProcessTree(depth, max, DefaultProcessTablesMax, time.Hour)
+	tree.now = func() time.Time { return now }
+	return tree, func(d time.Duration) { now = now.Add(d) }
+}
+
+func execEvent(containerID string, pid, ppid int, exe, cmdline string) *models.RuntimeEvent {
+	return &models.RuntimeEvent{
+		ClusterID: "c1",
+		NodeID:    "n1",
+		EventType: "process_exec",
+		Process:   &models.ProcessInfo{PID: pid, PPID: ppid, Exe: exe, Cmdline: cmdline},
+		Container: &models.ContainerInfo{ContainerID: containerID},
+	}
+}
+
+// enrichProcess records the event's process and attaches its ancestors, as
+// enrich does for events that aren't held
+func enrichProcess(tree *ProcessTree, event *models.RuntimeEvent) bool {
+	tree.Record(event)
+	return tree.Attach(event)
+}
+
+func ancestorExes(p *models.ProcessInfo) []string {
+	var exes []string
+	for _, a := range p.Ancestors {
+		exes = append(exes, a.Exe)
+	}
+	return exes
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestProcessTree_WebShellChain(t *testing.T) {
+	tree, _ := testProcessTree(8, 100)
+	enrichProcess(tree, execEvent("abc", 1, 0, "/usr/sbin/nginx", "nginx: master process"))
+	enrichProcess(tree, execEvent("abc", 40, 1, "/usr/sbin/php-fpm", "php-fpm: pool www"))
+
+	shell := execEvent("abc", 41, 40, "/bin/sh", "sh -c id")
+	if !enrichProcess(tree, shell) {
+		t.Fatalf("Expected ancestors for the shell")
+	}
+	want := []string{"/usr/sbin/php-fpm", "/usr/sbin/nginx"}
+	if got := ancestorExes(shell.Process); !equalStrings(got, want) {
+		t.Errorf("Expected ancestors %v, got %v", want, got)
+	}
+	if shell.Process.Ancestors[1].Cmdline != "nginx: master process" {
+		t.Errorf("Expected ancestor cmdlines, got %+v", shell.Process.Ancestors)
+	}
+
+	// Later events from the shell, e.g. its connections, get the chain too
+	connect := &models.RuntimeEvent{
+		ClusterID: "c1", NodeID: "n1", EventType: "network_connect",
+		Process:   &models.ProcessInfo{PID: 42, PPID: 41, Exe: "/usr/bin/curl"},
+		Container: &models.ContainerInfo{ContainerID: "abc"},
+	}
+	enrichProcess(tree, connect)
+	if got := ancestorExes(connect.Process); len(got) != 3 || got[0] != "/bin/sh" {
+		t.Errorf("Expected sh, php-fpm and nginx, got %v", got)
+	}
+}
+
+func TestProcessTree_Depth(t *testing.T) {
+	tree, _ := testProcessTree(2, 100)
+	for pid := 1; pid <= 5; pid++ {
+		enrichProcess(tree, execEvent("abc", pid, pid-1, "/bin/p", ""))
+	}
+	event := execEvent("abc", 6, 5, "/bin/sh", "")
+	enrichProcess(tree, event)
+	if len(event.Process.Ancestors) != 2 || event.Process.Ancestors[0].PID != 5 || event.Process.Ancestors[1].PID != 4 {
+		t.Errorf("Expected the 2 nearest ancestors, got %+v", event.Process.Ancestors)
+	}
+}
+
+func TestProcessTree_ScopedToContainer(t *testing.T) {
+	tree, _ := testProcessTree(8, 100)
+	enrichProcess(tree, execEvent("abc", 1, 0, "/usr/sbin/nginx", ""))
+
+	// PID 1 in another container is a different process
+	event := execEvent("def", 7, 1, "/bin/sh", "")
+	if enrichProcess(tree, event) {
+		t.Errorf("Expected no ancestors from another container, got %+v", event.Process.Ancestors)
+	}
+}
+
+func TestProcessTree_ExecReplacesImage(t *testing.T) {
+	tree, _ := testProcessTree(8, 100)
+	enrichProcess(tree, execEvent("abc", 10, 1, "/bin/sh", "sh /entrypoint.sh"))
+	// The entrypoint execs the server under the same PID
+	enrichProcess(tree, execEvent("abc", 10, 1, "/usr/bin/node", "node server.js"))
+	// A non-exec event doesn't overwrite what exec recorded
+	enrichProcess(tree, &models.RuntimeEvent{
+		ClusterID: "c1", NodeID: "n1", EventType: "file_open",
+		Process:   &models.ProcessInfo{PID: 10, PPID: 1, Exe: "/bin/sh"},
+		Container: &models.ContainerInfo{ContainerID: "abc"},
+	})
+
+	event := execEvent("abc", 11, 10, "/bin/sh", "sh -c whoami")
+	enrichProcess(tree, event)
+	if got := ancestorExes(event.Process); len(got) != 1 || got[0] != "/usr/bin/node" {
+		t.Errorf("Expected node as the parent, got %v", got)
+	}
+}
+
+func TestProcessTree_Cycle(t *testing.T) {
+	tree, _ := testProcessTree(8, 100)
+	enrichProcess(tree, execEvent("abc", 2, 3, "/bin/a", ""))
+	enrichProcess(tree, execEvent("abc", 3, 2, "/bin/b", ""))
+
+	event := execEvent("abc", 4, 2, "/bin/sh", "")
+	enrichProcess(tree, event)
+	if len(event.Process.Ancestors) != 2 {
+		t.Errorf("Expected the walk to stop at a repeated PID, got %+v", event.Process.Ancestors)
+	}
+}
+
+func TestProcessTree_Bounds(t *testing.T) {
+	tree, advance := testProcessTree(8, 3)
+	enrichProcess(tree, execEvent("abc", 1, 0, "/usr/sbin/nginx", ""))
+	for pid := 2; pid <= 4; pid++ {
+		advance(time.Second)
+		enrichProcess(tree, execEvent("abc", pid, 0, "/bin/worker", ""))
+	}
+	if got := tree.Len(); got != 3 {
+		t.Errorf("Expected at most 3 processes per container, got %d", got)
+	}
+
+	// nginx was evicted as the least recently seen
+	event := execEvent("abc", 5, 1, "/bin/sh", "")
+	if enrichProcess(tree, event) {
+		t.Errorf("Expected the oldest process to be evicted, got %+v", event.Process.Ancestors)
+	}
+
+	advance(2 * time.Hour)
+	tree.Sweep()
+	if got := tree.Len(); got != 0 {
+		t.Errorf("Expected processes not seen within the TTL to be swept, got %d", got)
+	}
+}
+
+func TestProcessTree_AncestorLookupKeepsAlive(t *testing.T) {
+	tree, advance := testProcessTree(8, 100)
+	enrichProcess(tree, execEvent("abc", 1, 0, "/usr/sbin/nginx", ""))
+	for i := 0; i < 3; i++ {
+		advance(45 * time.Minute)
+		event := execEvent("abc", 10+i, 1, "/bin/worker", "")
+		if !enrichProcess(tree, event) {
+			t.Fatalf("Expected a long-running parent to stay in the table")
+		}
+	}
+}
+
+func TestProcessTree_TableBounds(t *testing.T) {
+	tree, advance := testProcessTree(8, 100)
+	tree.maxTables = 2
+	for _, id := range []string{"abc", "def", "ghi"} {
+		enrichProcess(tree, execEvent(id, 1, 0, "/usr/sbin/nginx", ""))
+		advance(time.Second)
+	}
+	if got := len(tree.tables); got != 2 {
+		t.Errorf("Expected at most 2 tables, got %d", got)
+	}
+	// abc's table was dropped as the least recently seen
+	if event := execEvent("abc", 2, 1, "/bin/sh", ""); enrichProcess(tree, event) {
+		t.Errorf("Expected the oldest table to be evicted, got %+v", event.Process.Ancestors)
+	}
+	if event := execEvent("ghi", 2, 1, "/bin/sh", ""); !enrichProcess(tree, event) {
+		t.Errorf("Expected the newest table to be kept")
+	}
+}
+
+func TestProcessTree_ShareNeverBlocks(t *testing.T) {
+	tree, _ := testProcessTree(8, 100)
+	tree.shareCh = make(chan processRecord, 1)
+	enrichProcess(tree, execEvent("abc", 1, 0, "/usr/sbin/nginx", ""))
+	enrichProcess(tree, execEvent("abc", 2, 1, "/bin/sh", ""))
+	if got := len(tree.shareCh); got != 1 {
+		t.Errorf("Expected one queued record with the rest dropped, got %d", got)
+	}
+}
+
+// shareAll applies every process in from to to, as the bucket watch would
+func shareAll(from, to *ProcessTree) {
+	for key, pt := range from.tables {
+		for pid, e := range pt.procs {
+			to.apply(e.record(key, pid))
+		}
+	}
+}
+
+func TestProcessTree_SharedBetweenReplicas(t *testing.T) {
+	a, advance := testProcessTree(8, 100)
+	b, _ := testProcessTree(8, 100)
+	b.now = a.now
+
+	// The parent's events went to one replica and the shell's to the other
+	enrichProcess(a, execEvent("abc", 1, 0, "/usr/sbin/nginx", "nginx: master process"))
+	shareAll(a, b)
+	shell := execEvent("abc", 41, 1, "/bin/sh", "sh -c id")
+	if !enrichProcess(b, shell) || shell.Process.Ancestors[0].Exe != "/usr/sbin/nginx" {
+		t.Fatalf("Expected nginx from the other replica as the parent, got %+v", shell.Process.Ancestors)
+	}
+
+	// A later exec on either replica replaces an older entry for a reused PID
+	advance(time.Minute)
+	enrichProcess(a, execEvent("abc", 1, 0, "/usr/bin/python3", ""))
+	b.apply(processRecord{Table: "c1/n1/abc", PID: 1, Exe: "/bin/stale", LastSeen: a.now()})
+	shareAll(a, b)
+	event := execEvent("abc", 42, 1, "/bin/sh", "")
+	enrichProcess(b, event)
+	if got := ancestorExes(event.Process); len(got) != 1 || got[0] != "/usr/bin/python3" {
+		t.Errorf("Expected the latest exec to win, got %v", got)
+	}
+
+	// Records older than the TTL are ignored
+	advance(2 * time.Hour)
+	b.Sweep()
+	shareAll(a, b)
+	if got := b.Len(); got != 0 {
+		t.Errorf("Expected expired records to be ignored, got %d processes", got)
+	}
+}
+
+func TestProcessTree_RecordBeforeAttach(t *testing.T) {
+	tree, _ := testProcessTree(8, 100)
+
+	// The parent's exec is held for a pod cache retry while its child's
+	// event is published
+	parent := execEvent("abc", 10, 1, "/usr/bin/python3", "python3 app.py")
+	tree.Record(parent)
+	child := execEvent("abc", 11, 10, "/bin/sh", "sh -c id")
+	if !enrichProcess(tree, child) || child.Process.Ancestors[0].Exe != "/usr/bin/python3" {
+		t.Errorf("Expected the held parent as an ancestor, got %+v", child.Process.Ancestors)
+	}
+
+	if tree.Attach(parent) {
+		t.Errorf("Expected no ancestors for the parent, got %+v", parent.Process.Ancestors)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// clusterStats reports each cluster's informer sync status at /debug/vars
+var clusterStats = expvar.NewMap("enrich_clusters")
+
+// ClusterConfig says how to reach one cluster. Without a kubeconfig, the
+// in-cluster config is used.
+type ClusterConfig struct {
+	ID         string `yaml:"id"`
+	Kubeconfig string `yaml:"kubeconfig"` // relative to the clusters file
+	Context    string `yaml:"context"`    // defaults to the kubeconfig's current context
+}
+
+type clustersFile struct {
+	Clusters []ClusterConfig `yaml:"clusters"`
+}
+
+// LoadClusterConfigs reads a CLUSTERS_FILE
+func LoadClusterConfigs(file string) ([]ClusterConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clusters: %w", err)
+	}
+	var cfg clustersFile
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse clusters: %w", err)
+	}
+	if len(cfg.Clusters) == 0 {
+		return nil, errors.New("no clusters listed")
+	}
+	seen := make(map[string]bool)
+	for i, c := range cfg.Clusters {
+		if c.ID == "" {
+			return nil, errors.New("clusters need an id")
+		}
+		if seen[c.ID] {
+			return nil, fmt.Errorf("duplicate cluster %q", c.ID)
+		}
+		seen[c.ID] = true
+		if c.Kubeconfig != "" && !filepath.IsAbs(c.Kubeconfig) {
+			cfg.Clusters[i].Kubeconfig = filepath.Join(filepath.Dir(file), c.Kubeconfig)
+		}
+	}
+	return cfg.Clusters, nil
+}
+
+// RESTConfig builds the client config for the cluster
+func (c ClusterConfig) RESTConfig() (*rest.Config, error) {
+	if c.Kubeconfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: c.Kubeconfig},
+		&clientcmd.ConfigOverrides{CurrentContext: c.Context},
+	).ClientConfig()
+}
+
+// ClusterStatus is a cluster's informer sync status
+type ClusterStatus struct {
+	Synced    bool      `json:"synced"`
+	SyncedAt  time.Time `json:"synced_at,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	ErrorAt   time.Time `json:"error_at,omitempty"`
+}
+
+// Cluster holds the informers and caches that enrich events from one
+// cluster
+type Cluster struct {
+	ID           string
+	factory      informers.SharedInformerFactory
+	informers    []cache.SharedIndexInformer
+	Pods         *PodCache
+	Nodes        *NodeEnricher
+	Destinations *DestinationIndex
+	NodeLister   listers.NodeLister
+
+	mu     sync.Mutex
+	status ClusterStatus
+}
+
+// NewCluster sets up the informers for a cluster without starting them
+func NewCluster(id string, clientset kubernetes.Interface, tombstoneTTL time.Duration, maxTombstones int) (*Cluster, error) {
+	factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
+	c := &Cluster{ID: id, factory: factory}
+
+	workloads := NewWorkloadResolver(
+		factory.Apps().V1().ReplicaSets().Lister(),
+		factory.Batch().V1().Jobs().Lister(),
+	)
+	c.Pods = NewPodCache(tombstoneTTL, maxTombstones, workloads)
+	podInformer := factory.Core().V1().Pods().Informer()
+	podInformer.AddEventHandler(c.Pods.EventHandler())
+	nodeInformer := factory.Core().V1().Nodes().Informer()
+	c.NodeLister = factory.Core().V1().Nodes().Lister()
+
+	var err error
+	if c.Nodes, err = NewNodeEnricher(nodeInformer, id); err != nil {
+		return nil, fmt.Errorf("failed to index nodes: %w", err)
+	}
+	serviceInformer := factory.Core().V1().Services().Informer()
+	sliceInformer := factory.Discovery().V1().EndpointSlices().Informer()
+	if c.Destinations, err = NewDestinationIndex(serviceInformer, podInformer, sliceInformer, nodeInformer, id); err != nil {
+		return nil, fmt.Errorf("failed to index destination IPs: %w", err)
+	}
+
+	c.informers = []cache.SharedIndexInformer{
+		podInformer, nodeInformer, serviceInformer, sliceInformer,
+		factory.Apps().V1().ReplicaSets().Informer(),
+		factory.Batch().V1().Jobs().Informer(),
+	}
+	for _, informer := range c.informers {
+		if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			c.setError(err)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	name := id
+	if name == "" {
+		name = "default"
+	}
+	clusterStats.Set(name, expvar.Func(func() interface{} { return c.Status() }))
+	return c, nil
+}
+
+// Start runs the informers and waits up to timeout for them to sync. A
+// cluster that isn't synced by then keeps syncing in the background, and
+// its events are enriched with whatever has been cached so far.
+func (c *Cluster) Start(stopCh <-chan struct{}, timeout time.Duration) bool {
+	c.factory.Start(stopCh)
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		for _, informer := range c.informers {
+			if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+				return
+			}
+		}
+		c.mu.Lock()
+		c.status.Synced = true
+		c.status.SyncedAt = time.Now()
+		c.mu.Unlock()
+		log.Printf("Cluster %s synced", c.name())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	select {
+	case <-synced:
+		return c.Status().Synced
+	case <-ctx.Done():
+		log.Printf("Cluster %s not synced after %s, continuing in the background: %s", c.name(), timeout, c.Status().LastError)
+		return false
+	}
+}
+
+func (c *Cluster) setError(err error) {
+	c.mu.Lock()
+	c.status.LastError = err.Error()
+	c.status.ErrorAt = time.Now()
+	c.mu.Unlock()
+	log.Printf("Error watching cluster %s: %v", c.name(), err)
+}
+
+// Status returns the cluster's sync status
+func (c *Cluster) Status() ClusterStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+func (c *Cluster) name() string {
+	if c.ID == "" {
+		return "(default)"
+	}
+	return c.ID
+}
+
+// Enrich fills in pod, node and destination metadata from this cluster
+func (c *Cluster) Enrich(event *models.RuntimeEvent) {
+	// Falco's container ID is usually the 12 character short ID. If image
+	// digest is missing, we might find it in status, but Falco usually
+	// provides it.
+	c.Pods.Enrich(event)
+	c.Nodes.Enrich(event)
+	c.Destinations.Enrich(event)
+}
+
+// ClusterSet picks the cluster an event came from by its cluster_id. With a
+// single cluster configured from the environment, that cluster is also the
+// fallback for every event, as container IDs are unique across clusters;
+// with CLUSTERS_FILE, events from unlisted clusters aren't enriched from
+// Kubernetes.
+type ClusterSet struct {
+	clusters map[string]*Cluster
+	fallback *Cluster
+}
+
+func NewClusterSet(clusters []*Cluster, fallback *Cluster) *ClusterSet {
+	s := &ClusterSet{clusters: make(map[string]*Cluster, len(clusters)), fallback: fallback}
+	for _, c := range clusters {
+		s.clusters[c.ID] = c
+	}
+	return s
+}
+
+// ForEvent returns the event's cluster, or nil
+func (s *ClusterSet) ForEvent(event *models.RuntimeEvent) *Cluster {
+	if c, ok := s.clusters[event.ClusterID]; ok {
+		return c
+	}
+	return s.fallback
+}
+
+// List returns the clusters sorted by ID
+func (s *ClusterSet) List() []*Cluster {
+	list := make([]*Cluster, 0, len(s.clusters))
+	for _, c := range s.clusters {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Sweep evicts expired pod tombstones in every cluster
+func (s *ClusterSet) Sweep() {
+	for _, c := range s.clusters {
+		c.Pods.Sweep()
+	}
+}
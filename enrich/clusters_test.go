@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testCluster starts a cluster backed by a fake clientset holding pods
+func testCluster(t *testing.T, id string, pods ...*v1.Pod) *Cluster {
+	t.Helper()
+	clientset := fake.NewSimpleClientset()
+	for _, pod := range pods {
+		if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Failed to create pod: %v", err)
+		}
+	}
+	c, err := NewCluster(id, clientset, time.Minute, 100)
+	if err != nil {
+		t.Fatalf("Failed to create cluster: %v", err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	if !c.Start(stopCh, 10*time.Second) {
+		t.Fatalf("Expected cluster %s to sync", id)
+	}
+	return c
+}
+
+func clusterPod(name, containerID string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: types.UID(name), Name: name, Namespace: "prod"},
+		Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{
+			{Name: "app", ContainerID: containerID},
+		}},
+	}
+}
+
+func TestClusterSet_RoutesByClusterID(t *testing.T) {
+	// The same short container ID in two clusters
+	eu := testCluster(t, "prod-eu", clusterPod("api-eu", attackerID))
+	us := testCluster(t, "prod-us", clusterPod("api-us", attackerID))
+	clusters := NewClusterSet([]*Cluster{eu, us}, nil)
+
+	for clusterID, want := range map[string]string{"prod-eu": "api-eu", "prod-us": "api-us"} {
+		event := &models.RuntimeEvent{ClusterID: clusterID, Container: &models.ContainerInfo{ContainerID: "0123456789ab"}}
+		clusters.ForEvent(event).Enrich(event)
+		if event.Container.Pod != want {
+			t.Errorf("Expected %s events to get pod %s, got %q", clusterID, want, event.Container.Pod)
+		}
+	}
+
+	if c := clusters.ForEvent(&models.RuntimeEvent{ClusterID: "staging"}); c != nil {
+		t.Errorf("Expected no cluster for an unlisted cluster_id, got %s", c.ID)
+	}
+	if !eu.Status().Synced || eu.Status().SyncedAt.IsZero() {
+		t.Errorf("Expected synced status, got %+v", eu.Status())
+	}
+	if list := clusters.List(); len(list) != 2 || list[0].ID != "prod-eu" {
+		t.Errorf("Expected clusters sorted by ID, got %d", len(list))
+	}
+}
+
+func TestClusterSet_Fallback(t *testing.T) {
+	local := testCluster(t, "", clusterPod("api", attackerID))
+	clusters := NewClusterSet([]*Cluster{local}, local)
+
+	// Without CLUSTERS_FILE, every event is enriched from the one cluster
+	event := &models.RuntimeEvent{ClusterID: "kind-local", Container: &models.ContainerInfo{ContainerID: "0123456789ab"}}
+	clusters.ForEvent(event).Enrich(event)
+	if event.Container.Pod != "api" {
+		t.Errorf("Expected the fallback cluster's pod, got %q", event.Container.Pod)
+	}
+}
+
+func TestLoadClusterConfigs(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "clusters.yaml")
+	data := `
+clusters:
+  - id: central
+  - id: prod-eu
+    kubeconfig: prod-eu.kubeconfig
+    context: admin@prod-eu
+  - id: prod-us
+    kubeconfig: /etc/kubeconfigs/prod-us
+`
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := LoadClusterConfigs(file)
+	if err != nil {
+		t.Fatalf("Failed to load clusters: %v", err)
+	}
+	if len(configs) != 3 {
+		t.Fatalf("Expected 3 clusters, got %d", len(configs))
+	}
+	if configs[0].Kubeconfig != "" {
+		t.Errorf("Expected in-cluster config for central, got %q", configs[0].Kubeconfig)
+	}
+	if want := filepath.Join(dir, "prod-eu.kubeconfig"); configs[1].Kubeconfig != want || configs[1].Context != "admin@prod-eu" {
+		t.Errorf("Expected %s relative to the clusters file, got %+v", want, configs[1])
+	}
+	if configs[2].Kubeconfig != "/etc/kubeconfigs/prod-us" {
+		t.Errorf("Expected an absolute kubeconfig to be kept, got %q", configs[2].Kubeconfig)
+	}
+}
+
+func TestLoadClusterConfigs_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":        "clusters: []\n",
+		"missing id":   "clusters:\n  - kubeconfig: a\n",
+		"duplicate id": "clusters:\n  - id: a\n  - id: a\n",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "clusters.yaml")
+			if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadClusterConfigs(file); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestClusterConfig_RESTConfig(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	data := `
+apiVersion: v1
+kind: Config
+clusters:
+  - name: eu
+    cluster: {server: "https://eu.example:6443"}
+  - name: us
+    cluster: {server: "https://us.example:6443"}
+users:
+  - name: admin
+    user: {token: secret}
+contexts:
+  - name: eu
+    context: {cluster: eu, user: admin}
+  - name: us
+    context: {cluster: us, user: admin}
+current-context: eu
+`
+	if err := os.WriteFile(kubeconfig, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for kubeContext, want := range map[string]string{"": "https://eu.example:6443", "us": "https://us.example:6443"} {
+		cfg, err := ClusterConfig{ID: "x", Kubeconfig: kubeconfig, Context: kubeContext}.RESTConfig()
+		if err != nil {
+			t.Fatalf("Failed to build config: %v", err)
+		}
+		if cfg.Host != want {
+			t.Errorf("Expected host %s for context %q, got %s", want, kubeContext, cfg.Host)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ipIndex finds objects by the IPs they own
+const ipIndex = "ip"
+
+// Destination kinds for NetworkInfo.DstKind
+const (
+	DestinationPod      = "pod"
+	DestinationService  = "service"
+	DestinationNode     = "node"
+	DestinationExternal = "external"
+)
+
+// DestinationIndex resolves connection destinations to the Services, Pods
+// and Nodes that own them, from informer caches indexed by IP. Like node
+// metadata, it only applies to events from this cluster when clusterID is
+// set.
+type DestinationIndex struct {
+	services  cache.Indexer
+	pods      cache.Indexer
+	slices    cache.Indexer
+	nodes     cache.Indexer
+	clusterID string
+}
+
+// NewDestinationIndex adds IP indexes to the informers, so it must be
+// called before they're started
+func NewDestinationIndex(services, pods, slices, nodes cache.SharedIndexInformer, clusterID string) (*DestinationIndex, error) {
+	indexes := []struct {
+		informer cache.SharedIndexInformer
+		ips      func(obj interface{}) []string
+	}{
+		{services, serviceIPs},
+		{pods, podIPs},
+		{slices, endpointSliceIPs},
+		{nodes, nodeIPs},
+	}
+	for _, idx := range indexes {
+		ips := idx.ips
+		err := idx.informer.AddIndexers(cache.Indexers{ipIndex: func(obj interface{}) ([]string, error) {
+			return normalizeIPs(ips(obj)), nil
+		}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DestinationIndex{
+		services:  services.GetIndexer(),
+		pods:      pods.GetIndexer(),
+		slices:    slices.GetIndexer(),
+		nodes:     nodes.GetIndexer(),
+		clusterID: clusterID,
+	}, nil
+}
+
+// Resolve returns the kind, name and namespace of what owns ip. Service
+// IPs come first, then pods, then other endpoints of a Service (such as the
+// API server behind default/kubernetes), then nodes. Loopback and
+// unspecified addresses, and values that aren't IPs, resolve to nothing.
+func (d *DestinationIndex) Resolve(ip string) (kind, name, namespace string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsLoopback() || parsed.IsUnspecified() {
+		return "", "", ""
+	}
+	key := parsed.String()
+
+	if objs, _ := d.services.ByIndex(ipIndex, key); len(objs) > 0 {
+		svc := objs[0].(*v1.Service)
+		return DestinationService, svc.Name, svc.Namespace
+	}
+	if pod := runningPod(d.pods, key); pod != nil {
+		return DestinationPod, pod.Name, pod.Namespace
+	}
+	if objs, _ := d.slices.ByIndex(ipIndex, key); len(objs) > 0 {
+		slice := objs[0].(*discoveryv1.EndpointSlice)
+		if svc := slice.Labels[discoveryv1.LabelServiceName]; svc != "" {
+			return DestinationService, svc, slice.Namespace
+		}
+	}
+	if objs, _ := d.nodes.ByIndex(ipIndex, key); len(objs) > 0 {
+		return DestinationNode, objs[0].(*v1.Node).Name, ""
+	}
+	return DestinationExternal, "", ""
+}
+
+// Enrich annotates the event's destination, if it has one
+func (d *DestinationIndex) Enrich(event *models.RuntimeEvent) bool {
+	n := event.Network
+	if n == nil || n.DstIP == "" || (d.clusterID != "" && event.ClusterID != d.clusterID) {
+		return false
+	}
+	kind, name, namespace := d.Resolve(n.DstIP)
+	if kind == "" {
+		return false
+	}
+	n.DstKind, n.DstName, n.DstNamespace = kind, name, namespace
+	return true
+}
+
+// runningPod picks the pod using ip, preferring a running one since
+// finished pods keep IPs that may have been reused
+func runningPod(pods cache.Indexer, ip string) *v1.Pod {
+	objs, _ := pods.ByIndex(ipIndex, ip)
+	var found *v1.Pod
+	for _, obj := range objs {
+		pod := obj.(*v1.Pod)
+		if pod.Status.Phase == v1.PodRunning {
+			return pod
+		}
+		if found == nil {
+			found = pod
+		}
+	}
+	return found
+}
+
+func serviceIPs(obj interface{}) []string {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		return nil
+	}
+	ips := append([]string{}, svc.Spec.ClusterIPs...)
+	ips = append(ips, svc.Spec.ExternalIPs...)
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		ips = append(ips, ingress.IP)
+	}
+	return ips
+}
+
+// podIPs skips host network pods, whose IP is their node's
+func podIPs(obj interface{}) []string {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil
+	}
+	var ips []string
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	return ips
+}
+
+func endpointSliceIPs(obj interface{}) []string {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil
+	}
+	var ips []string
+	for _, ep := range slice.Endpoints {
+		ips = append(ips, ep.Addresses...)
+	}
+	return ips
+}
+
+func nodeIPs(obj interface{}) []string {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return nil
+	}
+	var ips []string
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP || addr.Type == v1.NodeExternalIP {
+			ips = append(ips, addr.Address)
+		}
+	}
+	return ips
+}
+
+// normalizeIPs drops values that aren't IPs (e.g. a headless Service's
+// "None") and puts IPv6 addresses in canonical form
+func normalizeIPs(ips []string) []string {
+	var out []string
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			out = append(out, parsed.String())
+		}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDestinationIndex_Resolve(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	services := factory.Core().V1().Services().Informer()
+	pods := factory.Core().V1().Pods().Informer()
+	slices := factory.Discovery().V1().EndpointSlices().Informer()
+	nodes := factory.Core().V1().Nodes().Informer()
+	index, err := NewDestinationIndex(services, pods, slices, nodes, "prod")
+	if err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+
+	objects := []struct {
+		indexer interface{ Add(interface{}) error }
+		obj     interface{}
+	}{
+		{services.GetIndexer(), &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+			Spec:       v1.ServiceSpec{ClusterIP: "10.96.0.1", ClusterIPs: []string{"10.96.0.1"}},
+		}},
+		{services.GetIndexer(), &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "data"},
+			Spec:       v1.ServiceSpec{ClusterIP: "None", ClusterIPs: []string{"None"}},
+		}},
+		{pods.GetIndexer(), &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "data"},
+			Status:     v1.PodStatus{Phase: v1.PodRunning, PodIPs: []v1.PodIP{{IP: "10.244.1.7"}, {IP: "fd00::7"}}},
+		}},
+		{pods.GetIndexer(), &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "old-job", Namespace: "data"},
+			Status:     v1.PodStatus{Phase: v1.PodSucceeded, PodIPs: []v1.PodIP{{IP: "10.244.1.7"}}},
+		}},
+		{pods.GetIndexer(), &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy-abc", Namespace: "kube-system"},
+			Spec:       v1.PodSpec{HostNetwork: true},
+			Status:     v1.PodStatus{Phase: v1.PodRunning, PodIPs: []v1.PodIP{{IP: "172.18.0.3"}}},
+		}},
+		{slices.GetIndexer(), &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default", Labels: map[string]string{
+				discoveryv1.LabelServiceName: "kubernetes",
+			}},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"172.18.0.2"}}},
+		}},
+		{nodes.GetIndexer(), &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "kind-control-plane"},
+			Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "172.18.0.2"}}},
+		}},
+		{nodes.GetIndexer(), &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "kind-worker"},
+			Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "172.18.0.3"}}},
+		}},
+	}
+	for _, o := range objects {
+		if err := o.indexer.Add(o.obj); err != nil {
+			t.Fatalf("Failed to add %T: %v", o.obj, err)
+		}
+	}
+
+	tests := []struct {
+		ip        string
+		kind      string
+		name      string
+		namespace string
+	}{
+		{"10.96.0.1", DestinationService, "kubernetes", "default"},
+		// The API server's endpoint resolves to its Service, not its node
+		{"172.18.0.2", DestinationService, "kubernetes", "default"},
+		{"10.244.1.7", DestinationPod, "db-0", "data"},
+		{"fd00:0::7", DestinationPod, "db-0", "data"},
+		// Host network pods share their node's IP
+		{"172.18.0.3", DestinationNode, "kind-worker", ""},
+		{"10.0.0.12", DestinationExternal, "", ""},
+		{"127.0.0.1", "", "", ""},
+		{"not-an-ip", "", "", ""},
+	}
+	for _, tt := range tests {
+		kind, name, namespace := index.Resolve(tt.ip)
+		if kind != tt.kind || name != tt.name || namespace != tt.namespace {
+			t.Errorf("%s: expected %s %s/%s, got %s %s/%s", tt.ip, tt.kind, tt.namespace, tt.name, kind, namespace, name)
+		}
+	}
+
+	event := &models.RuntimeEvent{ClusterID: "staging", Network: &models.NetworkInfo{DstIP: "10.96.0.1"}}
+	if index.Enrich(event) || event.Network.DstKind != "" {
+		t.Errorf("Expected events from another cluster to be left alone")
+	}
+	event.ClusterID = "prod"
+	if !index.Enrich(event) || event.Network.DstKind != DestinationService || event.Network.DstName != "kubernetes" {
+		t.Errorf("Unexpected destination: %+v", event.Network)
+	}
+}
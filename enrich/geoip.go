@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// geoRecord holds the fields read from GeoIP2/GeoLite2 Country or City
+// databases and ASN databases. Databases that combine both, like some
+// third-party MMDBs, fill in all of them.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// RegisteredCountry is used when an IP has no country, e.g. for anycast
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoDB is the part of *maxminddb.Reader used here
+type geoDB interface {
+	Lookup(ip net.IP, result interface{}) error
+	Close() error
+}
+
+func openMMDB(path string) (geoDB, error) {
+	return maxminddb.Open(path)
+}
+
+// GeoIP looks up the country and ASN of external destinations in local
+// MaxMind-format databases. The databases are memory-mapped, so a reload
+// waits for lookups in progress before closing the old ones.
+type GeoIP struct {
+	files []string
+	open  func(path string) (geoDB, error)
+
+	mu  sync.RWMutex
+	dbs []geoDB
+}
+
+// NewGeoIP opens one or more MMDB files, e.g. a GeoLite2-Country and a
+// GeoLite2-ASN database. Where they overlap, earlier files win.
+func NewGeoIP(files ...string) (*GeoIP, error) {
+	g := &GeoIP{files: files, open: openMMDB}
+	if err := g.reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *GeoIP) reload() error {
+	if len(g.files) == 0 {
+		return errors.New("no GeoIP databases")
+	}
+	dbs := make([]geoDB, 0, len(g.files))
+	for _, file := range g.files {
+		db, err := g.open(file)
+		if err != nil {
+			for _, opened := range dbs {
+				opened.Close()
+			}
+			return fmt.Errorf("failed to open %s: %w", file, err)
+		}
+		dbs = append(dbs, db)
+	}
+
+	g.mu.Lock()
+	old := g.dbs
+	g.dbs = dbs
+	g.mu.Unlock()
+	for _, db := range old {
+		db.Close()
+	}
+	return nil
+}
+
+func (g *GeoIP) onChange() {
+	if err := g.reload(); err != nil {
+		log.Printf("Error reloading GeoIP databases, keeping previous: %v", err)
+		return
+	}
+	log.Printf("Reloaded GeoIP databases from %v", g.files)
+}
+
+// Lookup returns the ISO country code, AS number and AS organization for
+// ip, with zero values for whatever the databases don't know
+func (g *GeoIP) Lookup(ip net.IP) (country string, asn uint, asOrg string) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, db := range g.dbs {
+		var rec geoRecord
+		if err := db.Lookup(ip, &rec); err != nil {
+			continue
+		}
+		if country == "" {
+			country = rec.Country.ISOCode
+			if country == "" {
+				country = rec.RegisteredCountry.ISOCode
+			}
+		}
+		if asn == 0 && rec.ASN != 0 {
+			asn, asOrg = rec.ASN, rec.ASOrg
+		}
+	}
+	return country, asn, asOrg
+}
+
+// Enrich sets the destination's country and ASN. Destinations resolved to
+// a pod, service or node are skipped, as are private and loopback
+// addresses, which no public database covers.
+func (g *GeoIP) Enrich(event *models.RuntimeEvent) bool {
+	n := event.Network
+	if n == nil || (n.DstKind != "" && n.DstKind != DestinationExternal) {
+		return false
+	}
+	ip := net.ParseIP(n.DstIP)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return false
+	}
+	country, asn, asOrg := g.Lookup(ip)
+	if country == "" && asn == 0 {
+		return false
+	}
+	n.DstCountry, n.DstASN, n.DstASOrg = country, asn, asOrg
+	return true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// fakeGeoDB maps IPs to records
+type fakeGeoDB struct {
+	records map[string]geoRecord
+	closed  bool
+}
+
+func (db *fakeGeoDB) Lookup(ip net.IP, result interface{}) error {
+	if db.closed {
+		return errors.New("closed")
+	}
+	if rec, ok := db.records[ip.String()]; ok {
+		*result.(*geoRecord) = rec
+	}
+	return nil
+}
+
+func (db *fakeGeoDB) Close() error {
+	db.closed = true
+	return nil
+}
+
+func countryRecord(code string) geoRecord {
+	var rec geoRecord
+	rec.Country.ISOCode = code
+	return rec
+}
+
+// testGeoIP opens dbs by file name
+func testGeoIP(t *testing.T, dbs map[string]*fakeGeoDB, files ...string) *GeoIP {
+	t.Helper()
+	g := &GeoIP{files: files, open: func(path string) (geoDB, error) {
+		if db, ok := dbs[path]; ok {
+			return db, nil
+		}
+		return nil, errors.New("no such file")
+	}}
+	if err := g.reload(); err != nil {
+		t.Fatalf("Failed to open databases: %v", err)
+	}
+	return g
+}
+
+func TestGeoIP_Enrich(t *testing.T) {
+	anycast := geoRecord{}
+	anycast.RegisteredCountry.ISOCode = "US"
+	g := testGeoIP(t, map[string]*fakeGeoDB{
+		"country.mmdb": {records: map[string]geoRecord{
+			"203.0.113.50": countryRecord("NL"),
+			"198.51.100.1": anycast,
+		}},
+		"asn.mmdb": {records: map[string]geoRecord{
+			"203.0.113.50": {ASN: 64500, ASOrg: "Example Hosting"},
+		}},
+	}, "country.mmdb", "asn.mmdb")
+
+	event := &models.RuntimeEvent{Network: &models.NetworkInfo{DstIP: "203.0.113.50", DstKind: DestinationExternal}}
+	if !g.Enrich(event) {
+		t.Fatalf("Expected the destination to be enriched")
+	}
+	if n := event.Network; n.DstCountry != "NL" || n.DstASN != 64500 || n.DstASOrg != "Example Hosting" {
+		t.Errorf("Expected NL/64500/Example Hosting, got %s/%d/%s", n.DstCountry, n.DstASN, n.DstASOrg)
+	}
+
+	event = &models.RuntimeEvent{Network: &models.NetworkInfo{DstIP: "198.51.100.1"}}
+	if !g.Enrich(event) || event.Network.DstCountry != "US" {
+		t.Errorf("Expected the registered country for an anycast IP, got %+v", event.Network)
+	}
+}
+
+func TestGeoIP_SkipsInternalDestinations(t *testing.T) {
+	g := testGeoIP(t, map[string]*fakeGeoDB{
+		"country.mmdb": {records: map[string]geoRecord{
+			"203.0.113.50": countryRecord("NL"),
+			"10.0.0.12":    countryRecord("NL"),
+		}},
+	}, "country.mmdb")
+
+	tests := []models.NetworkInfo{
+		{DstIP: "203.0.113.50", DstKind: DestinationService, DstName: "api"},
+		{DstIP: "10.0.0.12"},
+		{DstIP: "127.0.0.1"},
+		{DstIP: "192.0.2.1"}, // not in the database
+		{DstIP: "not-an-ip"},
+	}
+	for _, n := range tests {
+		network := n
+		if g.Enrich(&models.RuntimeEvent{Network: &network}) {
+			t.Errorf("Expected %s (%s) not to be enriched, got %+v", n.DstIP, n.DstKind, network)
+		}
+	}
+}
+
+func TestGeoIP_ReloadKeepsPrevious(t *testing.T) {
+	old := &fakeGeoDB{records: map[string]geoRecord{"203.0.113.50": countryRecord("NL")}}
+	dbs := map[string]*fakeGeoDB{"country.mmdb": old}
+	g := testGeoIP(t, dbs, "country.mmdb")
+
+	// A database being replaced may briefly be missing
+	delete(dbs, "country.mmdb")
+	g.onChange()
+	if country, _, _ := g.Lookup(net.ParseIP("203.0.113.50")); country != "NL" {
+		t.Errorf("Expected a failed reload to keep the previous database, got %q", country)
+	}
+
+	dbs["country.mmdb"] = &fakeGeoDB{records: map[string]geoRecord{"203.0.113.50": countryRecord("DE")}}
+	g.onChange()
+	if country, _, _ := g.Lookup(net.ParseIP("203.0.113.50")); country != "DE" {
+		t.Errorf("Expected the reloaded database, got %q", country)
+	}
+	if !old.closed {
+		t.Errorf("Expected the previous database to be closed")
+	}
+}
+
+func TestNewGeoIP_InvalidFile(t *testing.T) {
+	if _, err := NewGeoIP(t.TempDir() + "/missing.mmdb"); err == nil {
+		t.Errorf("Expected an error for a missing database")
+	}
+}
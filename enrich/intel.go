@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Indicator types for IntelMatch.Type
+const (
+	IndicatorIP     = "ip"
+	IndicatorDomain = "domain"
+	IndicatorHash   = "hash"
+)
+
+// Feed formats
+const (
+	FeedList = "list"
+	FeedCSV  = "csv"
+	FeedSTIX = "stix"
+)
+
+// DefaultIntelConfidence is used for feeds and indicators that don't set one
+const DefaultIntelConfidence = 50
+
+// IntelFeed is a local file of indicators of compromise. Format defaults
+// from the extension (.csv, .json for STIX, anything else is a list). Type
+// applies to every indicator in a list or CSV feed; if empty it's detected
+// per indicator. Labels are added to every match, e.g. "mining" or "c2".
+type IntelFeed struct {
+	Name       string   `yaml:"name"`
+	Path       string   `yaml:"path"` // relative to the feeds file
+	Format     string   `yaml:"format"`
+	Type       string   `yaml:"type"`
+	Confidence *int     `yaml:"confidence"`
+	Labels     []string `yaml:"labels"`
+}
+
+type intelFile struct {
+	Feeds []IntelFeed `yaml:"feeds"`
+}
+
+// indicator is one feed's entry for an IP, CIDR, domain or hash
+type indicator struct {
+	feed       string
+	value      string
+	confidence int
+	labels     []string
+}
+
+type cidrIndicator struct {
+	net *net.IPNet
+	indicator
+}
+
+// intelSet is an immutable index of every feed's indicators
+type intelSet struct {
+	ips     map[string][]indicator
+	cidrs   []cidrIndicator
+	domains map[string][]indicator
+	hashes  map[string][]indicator
+}
+
+func newIntelSet() *intelSet {
+	return &intelSet{
+		ips:     make(map[string][]indicator),
+		domains: make(map[string][]indicator),
+		hashes:  make(map[string][]indicator),
+	}
+}
+
+func (s *intelSet) len() int {
+	n := len(s.cidrs)
+	for _, m := range []map[string][]indicator{s.ips, s.domains, s.hashes} {
+		for _, list := range m {
+			n += len(list)
+		}
+	}
+	return n
+}
+
+// add indexes value as typ, detecting the type if typ is empty. It reports
+// whether value was a valid indicator.
+func (s *intelSet) add(typ, value string, ind indicator) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if typ == "" {
+		typ = indicatorType(value)
+	}
+	switch typ {
+	case IndicatorIP:
+		if ip := net.ParseIP(value); ip != nil {
+			ind.value = ip.String()
+			s.ips[ind.value] = append(s.ips[ind.value], ind)
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(value); err == nil {
+			ind.value = ipNet.String()
+			s.cidrs = append(s.cidrs, cidrIndicator{ipNet, ind})
+			return true
+		}
+	case IndicatorDomain:
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "*."), ".")
+		if isDomain(value) {
+			ind.value = value
+			s.domains[value] = append(s.domains[value], ind)
+			return true
+		}
+	case IndicatorHash:
+		if isSHA256(value) {
+			ind.value = value
+			s.hashes[value] = append(s.hashes[value], ind)
+			return true
+		}
+	}
+	return false
+}
+
+// IntelIndex matches events against IOC feeds listed in a YAML file. Feeds
+// are reloaded when the file or any feed changes; if any of them fails to
+// load, the previous indicators are kept.
+type IntelIndex struct {
+	file string
+
+	mu    sync.RWMutex
+	paths []string // the feeds file and every feed, for the watcher
+	set   *intelSet
+}
+
+func NewIntelIndex(file string) (*IntelIndex, error) {
+	x := &IntelIndex{file: file, paths: []string{file}, set: newIntelSet()}
+	if err := x.reload(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *IntelIndex) reload() error {
+	data, err := os.ReadFile(x.file)
+	if err != nil {
+		return fmt.Errorf("failed to read intel feeds: %w", err)
+	}
+	var cfg intelFile
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("failed to parse intel feeds: %w", err)
+	}
+
+	set := newIntelSet()
+	paths := []string{x.file}
+	names := make(map[string]bool)
+	for _, feed := range cfg.Feeds {
+		if feed.Name == "" || feed.Path == "" {
+			return errors.New("feeds need a name and a path")
+		}
+		if names[feed.Name] {
+			return fmt.Errorf("duplicate feed %q", feed.Name)
+		}
+		names[feed.Name] = true
+		if !filepath.IsAbs(feed.Path) {
+			feed.Path = filepath.Join(filepath.Dir(x.file), feed.Path)
+		}
+		paths = append(paths, feed.Path)
+		if err := loadFeed(set, feed); err != nil {
+			return fmt.Errorf("feed %s: %w", feed.Name, err)
+		}
+	}
+
+	x.mu.Lock()
+	x.set = set
+	x.paths = paths
+	x.mu.Unlock()
+	log.Printf("Loaded %d intel indicators from %d feeds", set.len(), len(cfg.Feeds))
+	return nil
+}
+
+func (x *IntelIndex) onChange() {
+	if err := x.reload(); err != nil {
+		log.Printf("Error reloading intel feeds, keeping previous: %v", err)
+		return
+	}
+	log.Printf("Reloaded intel feeds from %s", x.file)
+}
+
+// files lists the feeds file and the feeds it names, for filewatch.WatchFunc
+func (x *IntelIndex) files() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.paths
+}
+
+// Enrich sets event.Intel if the event's destination, command line,
+// executable hash or image digest matches an indicator
+func (x *IntelIndex) Enrich(event *models.RuntimeEvent) bool {
+	x.mu.RLock()
+	set := x.set
+	x.mu.RUnlock()
+
+	m := &intelMatcher{set: set, seen: make(map[string]bool)}
+	if n := event.Network; n != nil {
+		m.ip("network.dst_ip", n.DstIP)
+		m.domain("network.dst_domain", n.DstDomain)
+	}
+	if p := event.Process; p != nil {
+		for _, token := range cmdlineTokens(p.Cmdline) {
+			if net.ParseIP(token) != nil {
+				m.ip("process.cmdline", token)
+			} else {
+				m.domain("process.cmdline", token)
+			}
+		}
+		m.hash("process.exe_sha256", p.ExeSHA256)
+	}
+	if c := event.Container; c != nil {
+		m.hash("container.image_digest", strings.TrimPrefix(c.ImageDigest, "sha256:"))
+	}
+	if len(m.matches) == 0 {
+		return false
+	}
+	event.Intel = &models.IntelInfo{Matches: m.matches}
+	return true
+}
+
+// intelMatcher collects an event's matches, once per feed, indicator and field
+type intelMatcher struct {
+	set     *intelSet
+	seen    map[string]bool
+	matches []models.IntelMatch
+}
+
+func (m *intelMatcher) add(typ, field string, inds []indicator) {
+	for _, ind := range inds {
+		key := ind.feed + "\x00" + ind.value + "\x00" + field
+		if m.seen[key] {
+			continue
+		}
+		m.seen[key] = true
+		m.matches = append(m.matches, models.IntelMatch{
+			Feed:       ind.feed,
+			Type:       typ,
+			Indicator:  ind.value,
+			Field:      field,
+			Confidence: ind.confidence,
+			Labels:     ind.labels,
+		})
+	}
+}
+
+func (m *intelMatcher) ip(field, value string) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return
+	}
+	m.add(IndicatorIP, field, m.set.ips[ip.String()])
+	for _, c := range m.set.cidrs {
+		if c.net.Contains(ip) {
+			m.add(IndicatorIP, field, []indicator{c.indicator})
+		}
+	}
+}
+
+// domain matches value and its parent domains, so a feed entry for
+// minexmr.com matches pool.minexmr.com
+func (m *intelMatcher) domain(field, value string) {
+	value = strings.TrimSuffix(strings.ToLower(value), ".")
+	if !isDomain(value) {
+		return
+	}
+	for {
+		m.add(IndicatorDomain, field, m.set.domains[value])
+		i := strings.IndexByte(value, '.')
+		if i < 0 {
+			return
+		}
+		value = value[i+1:]
+	}
+}
+
+func (m *intelMatcher) hash(field, value string) {
+	if value != "" {
+		m.add(IndicatorHash, field, m.set.hashes[strings.ToLower(value)])
+	}
+}
+
+// cmdlineTokens splits a command line into the IPv4 addresses and host
+// names it may contain, e.g. pool.minexmr.com in
+// --url=stratum+tcp://pool.minexmr.com:4444
+func cmdlineTokens(cmdline string) []string {
+	fields := strings.FieldsFunc(cmdline, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_')
+	})
+	var tokens []string
+	for _, f := range fields {
+		f = strings.Trim(f, ".-_")
+		if strings.Contains(f, ".") {
+			tokens = append(tokens, f)
+		}
+	}
+	return tokens
+}
+
+func indicatorType(value string) string {
+	switch {
+	case net.ParseIP(value) != nil:
+		return IndicatorIP
+	case strings.Contains(value, "/"):
+		if _, _, err := net.ParseCIDR(value); err == nil {
+			return IndicatorIP
+		}
+	case isSHA256(value):
+		return IndicatorHash
+	case isDomain(strings.TrimPrefix(value, "*.")):
+		return IndicatorDomain
+	}
+	return ""
+}
+
+func isSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isDomain accepts dotted names of letters, digits, hyphens and underscores
+// that aren't IP addresses
+func isDomain(s string) bool {
+	if len(s) > 253 || !strings.Contains(s, ".") || net.ParseIP(s) != nil {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func loadFeed(set *intelSet, feed IntelFeed) error {
+	format := feed.Format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(feed.Path)) {
+		case ".csv":
+			format = FeedCSV
+		case ".json":
+			format = FeedSTIX
+		default:
+			format = FeedList
+		}
+	}
+	switch feed.Type {
+	case "", IndicatorIP, IndicatorDomain, IndicatorHash:
+	default:
+		return fmt.Errorf("unknown indicator type %q", feed.Type)
+	}
+	confidence := DefaultIntelConfidence
+	if feed.Confidence != nil {
+		confidence = *feed.Confidence
+	}
+	if confidence < 0 || confidence > 100 {
+		return errors.New("confidence must be in [0, 100]")
+	}
+
+	f, err := os.Open(feed.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	base := indicator{feed: feed.Name, confidence: confidence, labels: feed.Labels}
+	switch format {
+	case FeedList:
+		return loadList(set, f, feed.Type, base)
+	case FeedCSV:
+		return loadCSV(set, f, feed.Type, base)
+	case FeedSTIX:
+		return loadSTIX(set, f, base, time.Now())
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// loadList reads one indicator per line. Blank lines and # comments are
+// skipped, as are lines that aren't a valid indicator.
+func loadList(set *intelSet, r io.Reader, typ string, base indicator) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			set.add(typ, line, base)
+		}
+	}
+	return scanner.Err()
+}
+
+// loadCSV reads a CSV file with a header row. The indicator column is
+// required; type, confidence and labels (separated by ";") are optional and
+// override or add to the feed's.
+func loadCSV(set *intelSet, r io.Reader, typ string, base indicator) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["indicator"]; !ok {
+		return errors.New("missing indicator column")
+	}
+	col := func(record []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ind := base
+		rowType := typ
+		if v := col(record, "type"); v != "" {
+			rowType = strings.ToLower(v)
+		}
+		if v := col(record, "confidence"); v != "" {
+			if c, err := strconv.Atoi(v); err == nil && c >= 0 && c <= 100 {
+				ind.confidence = c
+			}
+		}
+		if v := col(record, "labels"); v != "" {
+			ind.labels = append(append([]string{}, base.labels...), splitLabels(v)...)
+		}
+		set.add(rowType, col(record, "indicator"), ind)
+	}
+}
+
+func splitLabels(s string) []string {
+	var labels []string
+	for _, l := range strings.Split(s, ";") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// stixIndicator is the part of a STIX 2.1 indicator object used here
+type stixIndicator struct {
+	Type           string     `json:"type"`
+	Pattern        string     `json:"pattern"`
+	PatternType    string     `json:"pattern_type"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	Revoked        bool       `json:"revoked"`
+	Confidence     *int       `json:"confidence"`
+	Labels         []string   `json:"labels"`
+	IndicatorTypes []string   `json:"indicator_types"`
+}
+
+// stixComparison matches the comparisons in a STIX pattern that compare a
+// single field this index knows about, e.g. [domain-name:value = 'x.com']
+var stixComparison = regexp.MustCompile(`(ipv4-addr|ipv6-addr|domain-name):value\s*=\s*'([^']*)'|file:hashes\.(?:'SHA-256'|"SHA-256"|SHA256|'SHA256')\s*=\s*'([0-9a-fA-F]{64})'`)
+
+var stixTypes = map[string]string{
+	"ipv4-addr":   IndicatorIP,
+	"ipv6-addr":   IndicatorIP,
+	"domain-name": IndicatorDomain,
+}
+
+// loadSTIX reads the indicator objects of a STIX 2.1 bundle. Patterns are
+// only understood as comparisons joined by OR; indicators that combine
+// observations with AND or FOLLOWEDBY, are revoked or have expired are
+// skipped.
+func loadSTIX(set *intelSet, r io.Reader, base indicator, now time.Time) error {
+	var bundle struct {
+		Type    string            `json:"type"`
+		Objects []json.RawMessage `json:"objects"`
+	}
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return fmt.Errorf("failed to parse STIX bundle: %w", err)
+	}
+	if bundle.Type != "bundle" {
+		return errors.New("not a STIX bundle")
+	}
+	for _, raw := range bundle.Objects {
+		var obj stixIndicator
+		if err := json.Unmarshal(raw, &obj); err != nil || obj.Type != "indicator" {
+			continue
+		}
+		if obj.Revoked || (obj.PatternType != "" && obj.PatternType != "stix") ||
+			(obj.ValidUntil != nil && now.After(*obj.ValidUntil)) ||
+			strings.Contains(obj.Pattern, " AND ") || strings.Contains(obj.Pattern, "FOLLOWEDBY") {
+			continue
+		}
+		ind := base
+		if obj.Confidence != nil {
+			ind.confidence = *obj.Confidence
+		}
+		if len(obj.Labels) > 0 || len(obj.IndicatorTypes) > 0 {
+			ind.labels = append(append(append([]string{}, base.labels...), obj.Labels...), obj.IndicatorTypes...)
+		}
+		for _, m := range stixComparison.FindAllStringSubmatch(obj.Pattern, -1) {
+			if m[3] != "" {
+				set.add(IndicatorHash, m[3], ind)
+			} else {
+				set.add(stixTypes[m[1]], strings.ReplaceAll(m[2], `\'`, "'"), ind)
+			}
+		}
+	}
+	return nil
+}
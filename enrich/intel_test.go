@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+const minerHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+// writeIntelFeeds writes files to a temp dir and returns the path of the
+// first, the feeds file
+func writeIntelFeeds(t *testing.T, files ...[2]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f[0]), []byte(f[1]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, files[0][0])
+}
+
+func testIntelFeeds(t *testing.T) string {
+	return writeIntelFeeds(t,
+		[2]string{"feeds.yaml", `
+feeds:
+  - name: mining-pools
+    path: pools.txt
+    type: domain
+    confidence: 90
+    labels: [mining]
+  - name: c2
+    path: c2.csv
+  - name: vendor
+    path: bundle.json
+`},
+		[2]string{"pools.txt", "# XMR pools\nminexmr.com\nsupportxmr.com # comment\n"},
+		[2]string{"c2.csv", "indicator,type,confidence,labels\n203.0.113.50,ip,80,c2\n198.51.100.0/24,,60,c2;scanner\n" + minerHash + ",hash,,\n"},
+		[2]string{"bundle.json", `{
+  "type": "bundle",
+  "objects": [
+    {"type": "indicator", "pattern_type": "stix", "confidence": 75, "indicator_types": ["malicious-activity"],
+     "pattern": "[domain-name:value = 'evil.example'] OR [ipv4-addr:value = '192.0.2.1']"},
+    {"type": "indicator", "pattern_type": "stix", "revoked": true, "pattern": "[domain-name:value = 'revoked.example']"},
+    {"type": "indicator", "pattern_type": "stix", "valid_until": "2001-01-01T00:00:00Z", "pattern": "[domain-name:value = 'expired.example']"},
+    {"type": "indicator", "pattern_type": "stix", "pattern": "[domain-name:value = 'both.example' AND ipv4-addr:value = '192.0.2.2']"},
+    {"type": "malware", "name": "not an indicator"}
+  ]
+}`},
+	)
+}
+
+func TestIntelIndex_Enrich(t *testing.T) {
+	x, err := NewIntelIndex(testIntelFeeds(t))
+	if err != nil {
+		t.Fatalf("Failed to load feeds: %v", err)
+	}
+
+	event := &models.RuntimeEvent{
+		Process: &models.ProcessInfo{
+			Cmdline:   "xmrig --url=stratum+tcp://pool.minexmr.com:4444 --backup pool.minexmr.com",
+			ExeSHA256: minerHash,
+		},
+		Network: &models.NetworkInfo{DstIP: "198.51.100.7", DstDomain: "evil.example"},
+	}
+	if !x.Enrich(event) {
+		t.Fatalf("Expected intel matches")
+	}
+
+	want := map[string]models.IntelMatch{
+		"process.cmdline":    {Feed: "mining-pools", Type: "domain", Indicator: "minexmr.com", Confidence: 90},
+		"process.exe_sha256": {Feed: "c2", Type: "hash", Indicator: minerHash, Confidence: 50},
+		"network.dst_ip":     {Feed: "c2", Type: "ip", Indicator: "198.51.100.0/24", Confidence: 60},
+		"network.dst_domain": {Feed: "vendor", Type: "domain", Indicator: "evil.example", Confidence: 75},
+	}
+	if len(event.Intel.Matches) != len(want) {
+		t.Fatalf("Expected %d matches, got %+v", len(want), event.Intel.Matches)
+	}
+	for _, m := range event.Intel.Matches {
+		w, ok := want[m.Field]
+		if !ok || m.Feed != w.Feed || m.Type != w.Type || m.Indicator != w.Indicator || m.Confidence != w.Confidence {
+			t.Errorf("Unexpected match %+v", m)
+		}
+	}
+}
+
+func TestIntelIndex_FeedLabels(t *testing.T) {
+	x, err := NewIntelIndex(testIntelFeeds(t))
+	if err != nil {
+		t.Fatalf("Failed to load feeds: %v", err)
+	}
+
+	event := &models.RuntimeEvent{Network: &models.NetworkInfo{DstIP: "198.51.100.7"}}
+	x.Enrich(event)
+	if event.Intel == nil || len(event.Intel.Matches) != 1 {
+		t.Fatalf("Expected one match, got %+v", event.Intel)
+	}
+	if labels := event.Intel.Matches[0].Labels; len(labels) != 2 || labels[0] != "c2" || labels[1] != "scanner" {
+		t.Errorf("Expected CSV labels, got %v", labels)
+	}
+
+	event = &models.RuntimeEvent{Network: &models.NetworkInfo{DstIP: "192.0.2.1"}}
+	x.Enrich(event)
+	if event.Intel == nil || len(event.Intel.Matches[0].Labels) != 1 || event.Intel.Matches[0].Labels[0] != "malicious-activity" {
+		t.Errorf("Expected STIX indicator_types as labels, got %+v", event.Intel)
+	}
+}
+
+func TestIntelIndex_SkippedSTIXIndicators(t *testing.T) {
+	x, err := NewIntelIndex(testIntelFeeds(t))
+	if err != nil {
+		t.Fatalf("Failed to load feeds: %v", err)
+	}
+	for _, domain := range []string{"revoked.example", "expired.example", "both.example"} {
+		event := &models.RuntimeEvent{Network: &models.NetworkInfo{DstDomain: domain}}
+		if x.Enrich(event) {
+			t.Errorf("Expected no match for %s, got %+v", domain, event.Intel)
+		}
+	}
+}
+
+func TestIntelIndex_NoMatch(t *testing.T) {
+	x, err := NewIntelIndex(testIntelFeeds(t))
+	if err != nil {
+		t.Fatalf("Failed to load feeds: %v", err)
+	}
+	event := &models.RuntimeEvent{
+		Process: &models.ProcessInfo{Cmdline: "curl https://notminexmr.com/ 10.0.0.1"},
+		Network: &models.NetworkInfo{DstIP: "203.0.113.51", DstDomain: "example.com"},
+	}
+	if x.Enrich(event) || event.Intel != nil {
+		t.Errorf("Expected no matches, got %+v", event.Intel)
+	}
+}
+
+func TestIntelIndex_ReloadKeepsPrevious(t *testing.T) {
+	file := testIntelFeeds(t)
+	x, err := NewIntelIndex(file)
+	if err != nil {
+		t.Fatalf("Failed to load feeds: %v", err)
+	}
+	if got := len(x.files()); got != 4 {
+		t.Errorf("Expected the feeds file and 3 feeds to be watched, got %d", got)
+	}
+
+	pools := filepath.Join(filepath.Dir(file), "pools.txt")
+	if err := os.Remove(pools); err != nil {
+		t.Fatal(err)
+	}
+	x.onChange()
+	event := &models.RuntimeEvent{Network: &models.NetworkInfo{DstDomain: "pool.supportxmr.com"}}
+	if !x.Enrich(event) {
+		t.Errorf("Expected a failed reload to keep the previous indicators")
+	}
+
+	if err := os.WriteFile(pools, []byte("xmrpool.eu\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	x.onChange()
+	if x.Enrich(&models.RuntimeEvent{Network: &models.NetworkInfo{DstDomain: "pool.supportxmr.com"}}) {
+		t.Errorf("Expected the reloaded feed to replace the old one")
+	}
+}
+
+func TestIntelIndex_InvalidConfig(t *testing.T) {
+	tests := map[string]string{
+		"missing path":   "feeds:\n  - name: a\n",
+		"duplicate name": "feeds:\n  - {name: a, path: a.txt}\n  - {name: a, path: a.txt}\n",
+		"bad type":       "feeds:\n  - {name: a, path: a.txt, type: url}\n",
+		"bad confidence": "feeds:\n  - {name: a, path: a.txt, confidence: 101}\n",
+		"missing feed":   "feeds:\n  - {name: a, path: missing.txt}\n",
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			file := writeIntelFeeds(t, [2]string{"feeds.yaml", cfg}, [2]string{"a.txt", "example.com\n"})
+			if _, err := NewIntelIndex(file); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
@@ -3,30 +3,36 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"net/http"
 	"os"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/filewatch"
+	"github.com/podwatch/podwatch/pkg/fleet"
 	"github.com/podwatch/podwatch/pkg/models"
-	v1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/informers"
+	"github.com/podwatch/podwatch/pkg/pipeline"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
 	natsConn *nats.Conn
-	// Cache: containerID -> Pod
-	// We use a simple map with RWMutex for this MVP as informers keep it up to date.
-	// But completed pods might be deleted. We need to keep them for a bit?
-	// The informer cache is good enough for active pods.
-	// We might need a separate LRU for recently deleted pods if events come in late.
-	// For now, let's rely on the informer cache and a secondary map for quick lookup.
-	containerToPod sync.Map // map[string]*v1.Pod
+	js       jetstream.JetStream
+	// clusters holds the pod, node and destination caches for each cluster
+	clusters *ClusterSet
+	// intel matches events against IOC feeds, if configured
+	intel *IntelIndex
+	// geoip adds country and ASN to external destinations, if configured
+	geoip *GeoIP
+	// processes reconstructs each event's process ancestry
+	processes *ProcessTree
+	// retries holds events whose container isn't in the pod cache yet; nil
+	// when disabled
+	retries *RetryQueue
 )
 
 func main() {
@@ -48,111 +54,311 @@ func main() {
 	}
 	defer natsConn.Close()
 
-	// 2. K8s Client
-	config, err := rest.InClusterConfig()
+	js, err = pipeline.New(natsConn)
 	if err != nil {
-		kubeconfig := os.Getenv("KUBECONFIG")
-		if kubeconfig == "" {
-			kubeconfig = os.Getenv("HOME") + "/.kube/config"
+		log.Fatalf("Error setting up JetStream: %v", err)
+	}
+
+	// 2. K8s Clients, one per cluster
+	tombstoneTTL := DefaultTombstoneTTL
+	if v := os.Getenv("POD_TOMBSTONE_TTL"); v != "" {
+		if tombstoneTTL, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid POD_TOMBSTONE_TTL: %v", err)
+		}
+	}
+	maxTombstones := DefaultMaxTombstones
+	if v := os.Getenv("POD_TOMBSTONE_MAX"); v != "" {
+		if maxTombstones, err = strconv.Atoi(v); err != nil || maxTombstones < 0 {
+			log.Fatalf("Invalid POD_TOMBSTONE_MAX: %q", v)
+		}
+	}
+	syncTimeout := 2 * time.Minute
+	if v := os.Getenv("CLUSTER_SYNC_TIMEOUT"); v != "" {
+		if syncTimeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid CLUSTER_SYNC_TIMEOUT: %v", err)
+		}
+	}
+
+	var configs []ClusterConfig
+	if clustersFile := os.Getenv("CLUSTERS_FILE"); clustersFile != "" {
+		if configs, err = LoadClusterConfigs(clustersFile); err != nil {
+			log.Fatalf("Error loading clusters: %v", err)
+		}
+	} else {
+		// A single cluster, from in-cluster config or KUBECONFIG
+		kubeconfig := ""
+		if _, err := rest.InClusterConfig(); err != nil {
+			if kubeconfig = os.Getenv("KUBECONFIG"); kubeconfig == "" {
+				kubeconfig = os.Getenv("HOME") + "/.kube/config"
+			}
 		}
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		configs = []ClusterConfig{{ID: os.Getenv("CLUSTER_ID"), Kubeconfig: kubeconfig}}
+	}
+
+	var list []*Cluster
+	for _, cfg := range configs {
+		restConfig, err := cfg.RESTConfig()
+		if err != nil {
+			log.Fatalf("Error building kubeconfig for cluster %q: %v", cfg.ID, err)
+		}
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			log.Fatalf("Error building k8s client for cluster %q: %v", cfg.ID, err)
+		}
+		cluster, err := NewCluster(cfg.ID, clientset, tombstoneTTL, maxTombstones)
 		if err != nil {
-			log.Fatalf("Error building kubeconfig: %v", err)
+			log.Fatalf("Error setting up cluster %q: %v", cfg.ID, err)
 		}
+		list = append(list, cluster)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("Error building k8s client: %v", err)
+	var fallback *Cluster
+	if os.Getenv("CLUSTERS_FILE") == "" {
+		fallback = list[0]
 	}
+	clusters = NewClusterSet(list, fallback)
 
 	// 3. Informers
-	factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
-	podInformer := factory.Core().V1().Pods().Informer()
-
-	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			updateCache(obj.(*v1.Pod))
-		},
-		UpdateFunc: func(old, new interface{}) {
-			updateCache(new.(*v1.Pod))
-		},
-		DeleteFunc: func(obj interface{}) {
-			// In a real system we'd keep it for a bit.
-			// deleteCache(obj.(*v1.Pod))
-		},
-	})
-
 	stopCh := make(chan struct{})
 	defer close(stopCh)
-	factory.Start(stopCh)
-	factory.WaitForCacheSync(stopCh)
+	var wg sync.WaitGroup
+	for _, c := range clusters.List() {
+		wg.Add(1)
+		go func(c *Cluster) {
+			defer wg.Done()
+			c.Start(stopCh, syncTimeout)
+		}(c)
+	}
+	wg.Wait()
 
-	log.Println("Enrich service started, listening for events...")
+	ancestryDepth := DefaultAncestryDepth
+	if v := os.Getenv("ANCESTRY_DEPTH"); v != "" {
+		if ancestryDepth, err = strconv.Atoi(v); err != nil || ancestryDepth < 0 {
+			log.Fatalf("Invalid ANCESTRY_DEPTH: %q", v)
+		}
+	}
+	processTableMax := DefaultProcessTableMax
+	if v := os.Getenv("PROCESS_TABLE_MAX"); v != "" {
+		if processTableMax, err = strconv.Atoi(v); err != nil || processTableMax <= 0 {
+			log.Fatalf("Invalid PROCESS_TABLE_MAX: %q", v)
+		}
+	}
+	processTTL := DefaultProcessTTL
+	if v := os.Getenv("PROCESS_TTL"); v != "" {
+		if processTTL, err = time.ParseDuration(v); err != nil || processTTL <= 0 {
+			log.Fatalf("Invalid PROCESS_TTL: %q", v)
+		}
+	}
+	processTablesMax := DefaultProcessTablesMax
+	if v := os.Getenv("PROCESS_TABLES_MAX"); v != "" {
+		if processTablesMax, err = strconv.Atoi(v); err != nil || processTablesMax <= 0 {
+			log.Fatalf("Invalid PROCESS_TABLES_MAX: %q", v)
+		}
+	}
+	processes = NewProcessTree(ancestryDepth, processTableMax, processTablesMax, processTTL)
+	if sharing, _ := strconv.ParseBool(os.Getenv("PROCESS_SHARING")); sharing {
+		processBucket, err := OpenProcessBucket(js, processTTL)
+		if err != nil {
+			log.Fatalf("Error opening process bucket: %v", err)
+		}
+		if err := processes.Share(processBucket); err != nil {
+			log.Fatalf("Error sharing process tables: %v", err)
+		}
+		log.Printf("Sharing process tables through the %s bucket", ProcessBucket)
+	}
 
-	// 4. NATS Subscribe
-	// Queue group "enrich-workers" ensures load balancing if we run multiple replicas
-	_, err = natsConn.QueueSubscribe("events.raw.>", "enrich-workers", func(msg *nats.Msg) {
-		enrichEvent(msg)
-	})
+	retryDelaysEnv := os.Getenv("ENRICH_RETRY_DELAYS")
+	if retryDelaysEnv == "" {
+		retryDelaysEnv = DefaultRetryDelays
+	}
+	retryDelays, err := ParseRetryDelays(retryDelaysEnv)
 	if err != nil {
-		log.Fatalf("Error subscribing: %v", err)
+		log.Fatalf("Invalid ENRICH_RETRY_DELAYS: %v", err)
+	}
+	retryMax := DefaultRetryMax
+	if v := os.Getenv("ENRICH_RETRY_MAX"); v != "" {
+		if retryMax, err = strconv.Atoi(v); err != nil || retryMax < 0 {
+			log.Fatalf("Invalid ENRICH_RETRY_MAX: %q", v)
+		}
+	}
+	if len(retryDelays) > 0 && retryMax > 0 {
+		retries = NewRetryQueue(retryDelays, retryMax)
+		log.Printf("Retrying pod cache misses after %v, holding at most %d events", retryDelays, retryMax)
+	} else {
+		log.Printf("Pod cache miss retries disabled")
 	}
 
-	select {}
-}
+	go func() {
+		for range time.Tick(time.Minute) {
+			clusters.Sweep()
+			processes.Sweep()
+		}
+	}()
+
+	// Sync status is served under enrich_clusters at /debug/vars
+	httpPort := os.Getenv("HTTP_PORT")
+	if httpPort == "" {
+		httpPort = "8080"
+	}
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	go func() {
+		if err := http.ListenAndServe(":"+httpPort, nil); err != nil {
+			log.Fatalf("Error serving HTTP: %v", err)
+		}
+	}()
 
-func updateCache(pod *v1.Pod) {
-	for _, status := range pod.Status.ContainerStatuses {
-		// ID is usually "docker://..." or "containerd://..."
-		// We strip the prefix or store as is.
-		// Falco usually sends the short ID or full ID.
-		// Let's store both the full ID and the short ID (12 chars) just in case.
-		id := status.ContainerID
-		if id != "" {
-			// Extract ID part
-			parts := strings.Split(id, "://")
-			if len(parts) > 1 {
-				containerToPod.Store(parts[1], pod)
-				if len(parts[1]) > 12 {
-					containerToPod.Store(parts[1][:12], pod)
-				}
+	// 4. Threat intel feeds
+	if intelFile := os.Getenv("INTEL_FEEDS_FILE"); intelFile != "" {
+		if intel, err = NewIntelIndex(intelFile); err != nil {
+			log.Fatalf("Error loading intel feeds: %v", err)
+		}
+		reloadInterval := time.Minute
+		if v := os.Getenv("INTEL_RELOAD_INTERVAL"); v != "" {
+			if reloadInterval, err = time.ParseDuration(v); err != nil {
+				log.Fatalf("Invalid INTEL_RELOAD_INTERVAL: %v", err)
 			}
-			containerToPod.Store(id, pod)
 		}
+		defer filewatch.WatchFunc(reloadInterval, intel.files, intel.onChange)()
+	} else {
+		log.Printf("INTEL_FEEDS_FILE not set, threat intel matching disabled")
+	}
+
+	// 5. GeoIP
+	var geoFiles []string
+	for _, v := range []string{os.Getenv("GEOIP_DB"), os.Getenv("GEOIP_ASN_DB")} {
+		if v != "" {
+			geoFiles = append(geoFiles, v)
+		}
+	}
+	if len(geoFiles) > 0 {
+		if geoip, err = NewGeoIP(geoFiles...); err != nil {
+			log.Fatalf("Error loading GeoIP databases: %v", err)
+		}
+		defer filewatch.Watch(30*time.Second, geoFiles, geoip.onChange)()
+		log.Printf("Resolving destination country and ASN from %v", geoFiles)
+	} else {
+		log.Printf("GEOIP_DB not set, GeoIP enrichment disabled")
+	}
+
+	// 6. Silent sensor alerts. Nodes are only known for the clusters
+	// enrich watches, so each needs its ID.
+	var watched []*Cluster
+	for _, c := range clusters.List() {
+		if c.ID != "" {
+			watched = append(watched, c)
+		}
+	}
+	if len(watched) > 0 {
+		inventory, err := fleet.Open(js)
+		if err != nil {
+			log.Fatalf("Error opening sensor inventory: %v", err)
+		}
+		checkInterval := time.Minute
+		if v := os.Getenv("SENSOR_CHECK_INTERVAL"); v != "" {
+			if checkInterval, err = time.ParseDuration(v); err != nil {
+				log.Fatalf("Invalid SENSOR_CHECK_INTERVAL: %v", err)
+			}
+		}
+		for _, c := range watched {
+			watch := NewSilentSensorWatch(inventory, c.NodeLister, c.ID, fleet.SilentAfter())
+			go watch.Run(checkInterval)
+			log.Printf("Alerting on silent sensors in cluster %s after %s", c.ID, fleet.SilentAfter())
+		}
+	} else {
+		log.Printf("CLUSTER_ID not set, silent sensor alerts disabled")
+	}
+
+	log.Println("Enrich service started, listening for events...")
+
+	// 7. JetStream Consume
+	// Durable consumer "enrich-workers" is shared by all replicas for load balancing
+	// and keeps its position across restarts
+	_, err = pipeline.Consume(js, pipeline.StreamEventsRaw, pipeline.ConsumerEnrich, pipeline.SubjectEventsRaw, enrichEvent)
+	if err != nil {
+		log.Fatalf("Error subscribing: %v", err)
 	}
+
+	select {}
 }
 
-func enrichEvent(msg *nats.Msg) {
+func enrichEvent(msg jetstream.Msg) {
 	var event models.RuntimeEvent
-	if err := json.Unmarshal(msg.Data, &event); err != nil {
+	if err := json.Unmarshal(msg.Data(), &event); err != nil {
 		log.Printf("Error unmarshalling event: %v", err)
+		pipeline.DeadLetter(js, "enrich", msg, err, nil)
+		msg.Term()
 		return
 	}
 
-	// We only care if there is a container ID
-	if event.Container != nil && event.Container.ContainerID != "" {
-		// Look up pod
-		// Falco container ID is usually short 12 chars
-		if podRaw, ok := containerToPod.Load(event.Container.ContainerID); ok {
-			pod := podRaw.(*v1.Pod)
-			event.Container.Pod = pod.Name
-			event.Container.Namespace = pod.Namespace
-			event.Container.ServiceAccount = pod.Spec.ServiceAccountName
-			event.Container.Labels = pod.Labels
+	// Record the process before the event can be held for a pod cache
+	// retry, so events published in the meantime see it as a parent
+	processes.Record(&event)
 
-			// If image digest is missing, we might find it in status
-			// But Falco usually provides it.
+	cluster := clusters.ForEvent(&event)
+	if cluster == nil || event.Container == nil || event.Container.ContainerID == "" {
+		publishEnriched(msg, &event, cluster, "")
+		return
+	}
+	containerID := event.Container.ContainerID
+	if _, _, ok := cluster.Pods.Lookup(containerID); ok {
+		podCacheStats.Add("hits", 1)
+		publishEnriched(msg, &event, cluster, EnrichmentHit)
+		return
+	}
+
+	// The pod informer may not have seen a container that just started yet
+	check := func() bool {
+		_, _, ok := cluster.Pods.Lookup(containerID)
+		return ok
+	}
+	wait := func() { msg.InProgress() }
+	held := retries != nil && retries.Hold(check, wait, func(found bool) {
+		if found {
+			podCacheStats.Add("late_hits", 1)
+			publishEnriched(msg, &event, cluster, EnrichmentLateHit)
+		} else {
+			podCacheStats.Add("misses", 1)
+			publishEnriched(msg, &event, cluster, EnrichmentMiss)
 		}
+	})
+	if !held {
+		if retries != nil {
+			podCacheStats.Add("overflow", 1)
+		}
+		podCacheStats.Add("misses", 1)
+		publishEnriched(msg, &event, cluster, EnrichmentMiss)
+	}
+}
+
+// publishEnriched runs every enrichment stage on the event and publishes it
+// to the enriched stream
+func publishEnriched(msg jetstream.Msg, event *models.RuntimeEvent, cluster *Cluster, status string) {
+	if cluster != nil {
+		cluster.Enrich(event)
+	}
+	event.EnrichmentStatus = status
+	processes.Attach(event)
+	if geoip != nil {
+		geoip.Enrich(event)
+	}
+	if intel != nil {
+		intel.Enrich(event)
 	}
 
 	// Publish to enriched stream
 	enrichedData, err := json.Marshal(event)
 	if err != nil {
 		log.Printf("Error marshalling enriched event: %v", err)
+		pipeline.DeadLetter(js, "enrich", msg, err, nil)
+		msg.Term()
 		return
 	}
 
-	if err := natsConn.Publish("events.enriched", enrichedData); err != nil {
+	if err := pipeline.Publish(js, pipeline.SubjectEventsEnriched, enrichedData); err != nil {
 		log.Printf("Error publishing enriched event: %v", err)
+		pipeline.Retry(js, "enrich", msg, err, nil)
+		return
 	}
+	msg.Ack()
 }
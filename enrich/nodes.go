@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// hostnameIndex finds nodes by their kubernetes.io/hostname label, for
+// sensors that report a hostname different from the node name
+const hostnameIndex = "hostname"
+
+// Well-known node labels, current first, then the deprecated beta ones
+var (
+	zoneLabels         = []string{v1.LabelTopologyZone, v1.LabelFailureDomainBetaZone}
+	regionLabels       = []string{v1.LabelTopologyRegion, v1.LabelFailureDomainBetaRegion}
+	instanceTypeLabels = []string{v1.LabelInstanceTypeStable, v1.LabelInstanceType}
+	controlPlaneLabels = []string{"node-role.kubernetes.io/control-plane", "node-role.kubernetes.io/master"}
+)
+
+// NodeEnricher attaches node metadata from the node informer's cache. Node
+// names are only unique within a cluster, so with clusterID set, events
+// from other clusters are left alone.
+type NodeEnricher struct {
+	nodes     cache.Indexer
+	clusterID string
+}
+
+// NewNodeEnricher adds the hostname index to a node informer, so it must be
+// called before the informer is started
+func NewNodeEnricher(informer cache.SharedIndexInformer, clusterID string) (*NodeEnricher, error) {
+	err := informer.AddIndexers(cache.Indexers{hostnameIndex: func(obj interface{}) ([]string, error) {
+		node, ok := obj.(*v1.Node)
+		if !ok || node.Labels[v1.LabelHostname] == "" {
+			return nil, nil
+		}
+		return []string{node.Labels[v1.LabelHostname]}, nil
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return &NodeEnricher{nodes: informer.GetIndexer(), clusterID: clusterID}, nil
+}
+
+// Lookup finds a node by name, or by hostname label
+func (e *NodeEnricher) Lookup(nodeID string) (*v1.Node, bool) {
+	if obj, ok, _ := e.nodes.GetByKey(nodeID); ok {
+		return obj.(*v1.Node), true
+	}
+	objs, _ := e.nodes.ByIndex(hostnameIndex, nodeID)
+	if len(objs) == 1 {
+		return objs[0].(*v1.Node), true
+	}
+	return nil, false
+}
+
+// Enrich sets event.Node if the event's node is known
+func (e *NodeEnricher) Enrich(event *models.RuntimeEvent) bool {
+	if event.NodeID == "" || (e.clusterID != "" && event.ClusterID != e.clusterID) {
+		return false
+	}
+	node, ok := e.Lookup(event.NodeID)
+	if !ok {
+		return false
+	}
+	event.Node = nodeInfo(node)
+	return true
+}
+
+func nodeInfo(node *v1.Node) *models.NodeInfo {
+	info := &models.NodeInfo{
+		Labels:                  node.Labels,
+		Zone:                    firstLabel(node.Labels, zoneLabels),
+		Region:                  firstLabel(node.Labels, regionLabels),
+		InstanceType:            firstLabel(node.Labels, instanceTypeLabels),
+		KernelVersion:           node.Status.NodeInfo.KernelVersion,
+		ContainerRuntimeVersion: node.Status.NodeInfo.ContainerRuntimeVersion,
+	}
+	for _, label := range controlPlaneLabels {
+		if _, ok := node.Labels[label]; ok {
+			info.ControlPlane = true
+		}
+	}
+	return info
+}
+
+func firstLabel(labels map[string]string, keys []string) string {
+	for _, key := range keys {
+		if v := labels[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
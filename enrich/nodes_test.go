@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodeEnricher_Enrich(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Core().V1().Nodes().Informer()
+	enricher, err := NewNodeEnricher(informer, "prod")
+	if err != nil {
+		t.Fatalf("Failed to create enricher: %v", err)
+	}
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "ip-10-0-1-5.ec2.internal", Labels: map[string]string{
+				v1.LabelHostname:                        "ip-10-0-1-5",
+				v1.LabelTopologyZone:                    "us-east-1a",
+				v1.LabelTopologyRegion:                  "us-east-1",
+				v1.LabelInstanceType:                    "m5.large",
+				"node-role.kubernetes.io/control-plane": "",
+			}},
+			Status: v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{
+				KernelVersion: "5.10.0", ContainerRuntimeVersion: "containerd://1.7.2",
+			}},
+		},
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{
+			v1.LabelFailureDomainBetaZone: "zone-b",
+		}}},
+	}
+	for _, node := range nodes {
+		if err := informer.GetIndexer().Add(node); err != nil {
+			t.Fatalf("Failed to add node: %v", err)
+		}
+	}
+
+	// Sensors may report the hostname rather than the node name
+	event := &models.RuntimeEvent{ClusterID: "prod", NodeID: "ip-10-0-1-5"}
+	if !enricher.Enrich(event) {
+		t.Fatalf("Expected the node to be found by hostname")
+	}
+	want := models.NodeInfo{Zone: "us-east-1a", Region: "us-east-1", InstanceType: "m5.large",
+		KernelVersion: "5.10.0", ContainerRuntimeVersion: "containerd://1.7.2", ControlPlane: true}
+	got := *event.Node
+	got.Labels = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	event = &models.RuntimeEvent{ClusterID: "prod", NodeID: "worker-1"}
+	if !enricher.Enrich(event) || event.Node.ControlPlane || event.Node.Zone != "zone-b" {
+		t.Errorf("Unexpected worker node info: %+v", event.Node)
+	}
+
+	event = &models.RuntimeEvent{ClusterID: "staging", NodeID: "worker-1"}
+	if enricher.Enrich(event) || event.Node != nil {
+		t.Errorf("Expected events from another cluster to be left alone")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DefaultTombstoneTTL is how long a deleted pod's metadata is kept for
+	// events that arrive after the pod is gone
+	DefaultTombstoneTTL = 10 * time.Minute
+	// DefaultMaxTombstones bounds the container IDs kept for deleted pods
+	DefaultMaxTombstones = 10000
+	// shortIDLength is the container ID prefix Falco and docker ps use
+	shortIDLength = 12
+)
+
+// podEntry is the pod and container name a container ID resolves to.
+// Entries with expires set are tombstones for containers whose pod was
+// deleted or that were replaced.
+type podEntry struct {
+	pod       *v1.Pod
+	container string
+	expires   time.Time
+}
+
+// tombstone is queued when an entry is tombstoned, in expiry order since
+// the TTL is fixed
+type tombstone struct {
+	key     string
+	expires time.Time
+}
+
+// PodCache maps container IDs to pods. It's fed by the pod informer: live
+// pods are kept for as long as they exist, and deleted pods' containers are
+// kept as tombstones for a TTL, since the events that matter most (e.g.
+// from a pod that kill_pod just removed) often arrive after deletion.
+// Tombstones are bounded; the oldest are dropped first.
+type PodCache struct {
+	ttl           time.Duration
+	maxTombstones int
+	workloads     *WorkloadResolver // optional
+	now           func() time.Time
+
+	mu         sync.Mutex
+	entries    map[string]*podEntry
+	podKeys    map[types.UID][]string // container IDs each live pod owns
+	tombstones []tombstone
+}
+
+func NewPodCache(ttl time.Duration, maxTombstones int, workloads *WorkloadResolver) *PodCache {
+	return &PodCache{
+		ttl:           ttl,
+		maxTombstones: maxTombstones,
+		workloads:     workloads,
+		now:           time.Now,
+		entries:       make(map[string]*podEntry),
+		podKeys:       make(map[types.UID][]string),
+	}
+}
+
+// EventHandler keeps the cache in sync with a pod informer
+func (pc *PodCache) EventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				pc.Update(pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				pc.Update(pod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			// Deletes missed during a watch gap arrive wrapped
+			if tomb, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tomb.Obj
+			}
+			if pod, ok := obj.(*v1.Pod); ok {
+				pc.Delete(pod)
+			}
+		},
+	}
+}
+
+// Update indexes a pod's current containers. Containers it no longer has,
+// e.g. after a restart, are tombstoned like a deleted pod's.
+func (pc *PodCache) Update(pod *v1.Pod) {
+	refs := containerKeys(pod)
+	keys := make([]string, len(refs))
+	current := make(map[string]bool, len(refs))
+	for i, ref := range refs {
+		keys[i] = ref.key
+		current[ref.key] = true
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	now := pc.now()
+	for _, key := range pc.podKeys[pod.UID] {
+		if !current[key] {
+			pc.tombstoneLocked(key, pod.UID, now)
+		}
+	}
+	for _, ref := range refs {
+		// A live pod always wins, including over a tombstone for a
+		// recycled short ID
+		pc.entries[ref.key] = &podEntry{pod: pod, container: ref.container}
+	}
+	if len(keys) > 0 {
+		pc.podKeys[pod.UID] = keys
+	} else {
+		delete(pc.podKeys, pod.UID)
+	}
+	pc.evictLocked(now)
+}
+
+// Delete tombstones a deleted pod's containers
+func (pc *PodCache) Delete(pod *v1.Pod) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	now := pc.now()
+	var refs []containerRef
+	if keys := pc.podKeys[pod.UID]; keys != nil {
+		for _, key := range keys {
+			refs = append(refs, containerRef{key: key})
+		}
+	} else {
+		refs = containerKeys(pod)
+	}
+	for _, ref := range refs {
+		key := ref.key
+		e := pc.entries[key]
+		if e == nil {
+			// Deleted before its containers were indexed
+			e = &podEntry{pod: pod, container: ref.container}
+			pc.entries[key] = e
+		}
+		if e.pod.UID == pod.UID && e.expires.IsZero() {
+			e.pod = pod // keep the final state
+		}
+		pc.tombstoneLocked(key, pod.UID, now)
+	}
+	delete(pc.podKeys, pod.UID)
+	pc.evictLocked(now)
+}
+
+// tombstoneLocked starts the TTL for key, unless it now belongs to another pod
+func (pc *PodCache) tombstoneLocked(key string, uid types.UID, now time.Time) {
+	e := pc.entries[key]
+	if e == nil || e.pod.UID != uid || !e.expires.IsZero() {
+		return
+	}
+	e.expires = now.Add(pc.ttl)
+	pc.tombstones = append(pc.tombstones, tombstone{key: key, expires: e.expires})
+}
+
+// evictLocked drops expired tombstones, and the oldest ones while there are
+// more than maxTombstones
+func (pc *PodCache) evictLocked(now time.Time) {
+	n := 0
+	for ; n < len(pc.tombstones); n++ {
+		t := pc.tombstones[n]
+		if !now.After(t.expires) && len(pc.tombstones)-n <= pc.maxTombstones {
+			break
+		}
+		// The key may have been revived by a live pod or tombstoned again
+		if e := pc.entries[t.key]; e != nil && e.expires.Equal(t.expires) {
+			delete(pc.entries, t.key)
+		}
+	}
+	if n > 0 {
+		pc.tombstones = append(pc.tombstones[:0:0], pc.tombstones[n:]...)
+	}
+}
+
+// Sweep evicts expired tombstones. Lookups never return them, so this only
+// frees memory when no pods are changing.
+func (pc *PodCache) Sweep() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.evictLocked(pc.now())
+}
+
+// Lookup returns the pod a container ID belongs to, and whether that pod
+// has been deleted
+func (pc *PodCache) Lookup(containerID string) (pod *v1.Pod, deleted bool, ok bool) {
+	e, ok := pc.lookup(containerID)
+	if !ok {
+		return nil, false, false
+	}
+	return e.pod, !e.expires.IsZero(), true
+}
+
+func (pc *PodCache) lookup(containerID string) (podEntry, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e := pc.entries[containerID]
+	if e == nil || (!e.expires.IsZero() && pc.now().After(e.expires)) {
+		return podEntry{}, false
+	}
+	return *e, true
+}
+
+// Len returns the number of container IDs indexed, including tombstones
+func (pc *PodCache) Len() int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return len(pc.entries)
+}
+
+// Enrich fills in the event's pod metadata, owning workload and container
+// security context from its container ID. Events attributed to a deleted
+// pod get k8s.pod_deleted in their metadata.
+func (pc *PodCache) Enrich(event *models.RuntimeEvent) bool {
+	if event.Container == nil || event.Container.ContainerID == "" {
+		return false
+	}
+	e, ok := pc.lookup(event.Container.ContainerID)
+	if !ok {
+		return false
+	}
+	pod, deleted := e.pod, !e.expires.IsZero()
+	event.Container.Pod = pod.Name
+	event.Container.Namespace = pod.Namespace
+	event.Container.ServiceAccount = pod.Spec.ServiceAccountName
+	event.Container.Labels = podLabels(pod, event)
+	if pc.workloads != nil {
+		event.Container.WorkloadKind, event.Container.WorkloadName = pc.workloads.Resolve(pod)
+	}
+	if e.container != "" {
+		event.Container.Name = e.container
+		event.Container.SecurityContext = securityContext(pod, e.container)
+	}
+	if deleted {
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]string)
+		}
+		event.Metadata["k8s.pod_deleted"] = "true"
+	}
+	return true
+}
+
+// podLabels returns the pod's labels for the event, keeping the values ingest
+// masked secrets in so that enrich doesn't undo the redaction
+func podLabels(pod *v1.Pod, event *models.RuntimeEvent) map[string]string {
+	labels := pod.Labels
+	copied := false
+	for _, r := range event.Redactions {
+		key, ok := strings.CutPrefix(r.Field, "container.labels.")
+		if !ok {
+			continue
+		}
+		masked, sent := event.Container.Labels[key]
+		if _, ok := labels[key]; !ok || !sent {
+			continue
+		}
+		if !copied {
+			labels = maps.Clone(labels)
+			copied = true
+		}
+		labels[key] = masked
+	}
+	return labels
+}
+
+// containerRef is a container ID a pod's container may be reported under
+type containerRef struct {
+	key       string
+	container string
+}
+
+// containerKeys lists the IDs a pod's containers may be reported under: the
+// full ID with runtime prefix ("containerd://..."), the bare ID, and the
+// 12 character short ID Falco usually sends
+func containerKeys(pod *v1.Pod) []containerRef {
+	var keys []containerRef
+	statuses := [][]v1.ContainerStatus{
+		pod.Status.InitContainerStatuses,
+		pod.Status.ContainerStatuses,
+		pod.Status.EphemeralContainerStatuses,
+	}
+	for _, list := range statuses {
+		for _, status := range list {
+			id := status.ContainerID
+			if id == "" {
+				continue
+			}
+			keys = append(keys, containerRef{id, status.Name})
+			if _, bare, ok := strings.Cut(id, "://"); ok {
+				keys = append(keys, containerRef{bare, status.Name})
+				if len(bare) > shortIDLength {
+					keys = append(keys, containerRef{bare[:shortIDLength], status.Name})
+				}
+			}
+		}
+	}
+	return keys
+}
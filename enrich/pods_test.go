@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+const attackerID = "containerd://0123456789abcdef0123456789abcdef"
+
+func testPod(uid, name string, containerIDs ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), Name: name, Namespace: "prod"},
+		Spec:       v1.PodSpec{ServiceAccountName: "default"},
+	}
+	for _, id := range containerIDs {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, v1.ContainerStatus{ContainerID: id})
+	}
+	return pod
+}
+
+// testPodCache returns a cache whose clock is advanced by the returned func
+func testPodCache(ttl time.Duration, max int) (*PodCache, func(time.Duration)) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pc := NewPodCache(ttl, max, nil)
+	pc.now = func() time.Time { return now }
+	return pc, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestPodCache_LateEventAfterDelete(t *testing.T) {
+	pc, advance := testPodCache(10*time.Minute, 100)
+	handler := pc.EventHandler()
+	pod := testPod("uid-1", "vuln-nginx", attackerID)
+	handler.OnAdd(pod, false)
+
+	// kill_pod removed the pod before its last events were enriched
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "prod/vuln-nginx", Obj: pod})
+	advance(5 * time.Minute)
+
+	event := &models.RuntimeEvent{Container: &models.ContainerInfo{ContainerID: "0123456789ab"}}
+	if !pc.Enrich(event) {
+		t.Fatalf("Expected a late event to be attributed to the deleted pod")
+	}
+	if event.Container.Pod != "vuln-nginx" || event.Container.Namespace != "prod" {
+		t.Errorf("Unexpected pod metadata: %+v", event.Container)
+	}
+	if event.Metadata["k8s.pod_deleted"] != "true" {
+		t.Errorf("Expected k8s.pod_deleted to be set, got %v", event.Metadata)
+	}
+
+	advance(6 * time.Minute)
+	if _, _, ok := pc.Lookup("0123456789ab"); ok {
+		t.Errorf("Expected the tombstone to expire after its TTL")
+	}
+	pc.Sweep()
+	if pc.Len() != 0 {
+		t.Errorf("Expected expired tombstones to be evicted, got %d entries", pc.Len())
+	}
+}
+
+func TestPodCache_RecycledShortID(t *testing.T) {
+	pc, advance := testPodCache(10*time.Minute, 100)
+	old := testPod("uid-1", "old", attackerID)
+	pc.Update(old)
+	pc.Delete(old)
+
+	// A new container shares the short ID but not the full one
+	recycled := testPod("uid-2", "new", "containerd://0123456789ab99999999999999999999")
+	pc.Update(recycled)
+
+	if pod, deleted, ok := pc.Lookup("0123456789ab"); !ok || deleted || pod.Name != "new" {
+		t.Errorf("Expected the live pod to own the short ID, got %v deleted=%v", pod, deleted)
+	}
+	if pod, deleted, ok := pc.Lookup(attackerID); !ok || !deleted || pod.Name != "old" {
+		t.Errorf("Expected the full ID to still resolve to the deleted pod, got %v deleted=%v", pod, deleted)
+	}
+
+	// The old tombstone expiring must not evict the live pod's entry
+	advance(11 * time.Minute)
+	pc.Sweep()
+	if pod, _, ok := pc.Lookup("0123456789ab"); !ok || pod.Name != "new" {
+		t.Errorf("Expected the live pod to survive the tombstone's expiry, got %v", pod)
+	}
+}
+
+func TestPodCache_RestartedContainerAndBound(t *testing.T) {
+	pc, _ := testPodCache(time.Hour, 3)
+	pod := testPod("uid-1", "web", "containerd://aaaaaaaaaaaaaaaa")
+	pc.Update(pod)
+
+	// After a restart, the previous container's late events still resolve
+	restarted := testPod("uid-1", "web", "containerd://bbbbbbbbbbbbbbbb")
+	pc.Update(restarted)
+	if _, deleted, ok := pc.Lookup("aaaaaaaaaaaa"); !ok || !deleted {
+		t.Errorf("Expected the replaced container to be tombstoned")
+	}
+	if _, deleted, ok := pc.Lookup("bbbbbbbbbbbb"); !ok || deleted {
+		t.Errorf("Expected the new container to be live")
+	}
+
+	// Tombstones beyond the bound are dropped oldest first
+	pc.Delete(restarted)
+	if pc.Len() != 3 {
+		t.Errorf("Expected 3 entries within the bound, got %d", pc.Len())
+	}
+	if _, _, ok := pc.Lookup("aaaaaaaaaaaa"); ok {
+		t.Errorf("Expected the oldest tombstones to be evicted")
+	}
+	if _, _, ok := pc.Lookup("bbbbbbbbbbbb"); !ok {
+		t.Errorf("Expected the newest tombstones to be kept")
+	}
+}
+
+func TestPodCache_KeepsRedactedLabels(t *testing.T) {
+	pc, _ := testPodCache(10*time.Minute, 100)
+	pod := testPod("uid-1", "api", attackerID)
+	pod.Labels = map[string]string{"app": "api", "config": "token=abc123"}
+	pc.EventHandler().OnAdd(pod, false)
+
+	// Ingest masked the label the sensor sent
+	event := &models.RuntimeEvent{
+		Container:  &models.ContainerInfo{ContainerID: "0123456789ab", Labels: map[string]string{"config": "token=[REDACTED]"}},
+		Redactions: []models.Redaction{{Field: "container.labels.config", Detector: "secret_assignment"}},
+	}
+	pc.Enrich(event)
+	if event.Container.Labels["config"] != "token=[REDACTED]" || event.Container.Labels["app"] != "api" {
+		t.Errorf("Expected pod labels with the masked value kept, got %v", event.Container.Labels)
+	}
+	if pod.Labels["config"] != "token=abc123" {
+		t.Errorf("Expected the cached pod's labels to be left alone, got %v", pod.Labels)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Enrichment statuses for RuntimeEvent.EnrichmentStatus
+const (
+	EnrichmentHit     = "hit"
+	EnrichmentLateHit = "late_hit"
+	EnrichmentMiss    = "miss"
+)
+
+const (
+	// DefaultRetryDelays is how long a pod cache miss is held before each
+	// retry
+	DefaultRetryDelays = "500ms,1s,2s"
+	// DefaultRetryMax bounds the events held at once. Held events are
+	// unacked, so this must stay below the consumer's MaxAckPending (1000
+	// by default) or delivery stalls.
+	DefaultRetryMax = 500
+)
+
+// podCacheStats counts pod lookups for events with a container ID: hits,
+// late_hits found on a retry, misses that gave up, and overflow for misses
+// published right away because the retry queue was full
+var podCacheStats = expvar.NewMap("enrich_pod_cache")
+
+// ParseRetryDelays parses a comma-separated list of durations
+func ParseRetryDelays(s string) ([]time.Duration, error) {
+	var delays []time.Duration
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, errors.New("retry delays must be positive")
+		}
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
+
+// RetryQueue holds events whose container wasn't in the pod cache yet,
+// typically because the pod informer hasn't seen a container that just
+// started, and checks again after each delay. It holds at most max events;
+// each has its own timer.
+type RetryQueue struct {
+	delays []time.Duration
+	max    int
+
+	mu   sync.Mutex
+	held int
+}
+
+func NewRetryQueue(delays []time.Duration, max int) *RetryQueue {
+	q := &RetryQueue{delays: delays, max: max}
+	podCacheStats.Set("held", expvar.Func(func() interface{} { return q.Len() }))
+	return q
+}
+
+// Hold calls check after each delay until it returns true, then calls
+// done(true); if every check fails it calls done(false). wait is called
+// before each delay, e.g. to keep the message from being redelivered. Hold
+// returns false without calling anything if the queue is full or has no
+// delays.
+func (q *RetryQueue) Hold(check func() bool, wait func(), done func(found bool)) bool {
+	if len(q.delays) == 0 {
+		return false
+	}
+	q.mu.Lock()
+	if q.held >= q.max {
+		q.mu.Unlock()
+		return false
+	}
+	q.held++
+	q.mu.Unlock()
+
+	var attempt func(i int)
+	attempt = func(i int) {
+		wait()
+		time.AfterFunc(q.delays[i], func() {
+			found := check()
+			if !found && i+1 < len(q.delays) {
+				attempt(i + 1)
+				return
+			}
+			q.mu.Lock()
+			q.held--
+			q.mu.Unlock()
+			done(found)
+		})
+	}
+	attempt(0)
+	return true
+}
+
+// Len returns the number of events held
+func (q *RetryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.held
+}
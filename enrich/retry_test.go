@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryQueue_LateHit(t *testing.T) {
+	q := NewRetryQueue([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}, 10)
+
+	// The pod shows up in the cache before the second retry
+	var checks, waits atomic.Int32
+	result := make(chan bool, 1)
+	held := q.Hold(func() bool {
+		return checks.Add(1) == 2
+	}, func() {
+		waits.Add(1)
+	}, func(found bool) {
+		result <- found
+	})
+	if !held {
+		t.Fatalf("Expected the event to be held")
+	}
+
+	select {
+	case found := <-result:
+		if !found {
+			t.Errorf("Expected a late hit")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the retry")
+	}
+	if checks.Load() != 2 || waits.Load() != 2 {
+		t.Errorf("Expected 2 checks and 2 waits, got %d and %d", checks.Load(), waits.Load())
+	}
+	if q.Len() != 0 {
+		t.Errorf("Expected nothing held after done, got %d", q.Len())
+	}
+}
+
+func TestRetryQueue_Miss(t *testing.T) {
+	q := NewRetryQueue([]time.Duration{time.Millisecond, 2 * time.Millisecond}, 10)
+
+	var checks atomic.Int32
+	result := make(chan bool, 1)
+	q.Hold(func() bool {
+		checks.Add(1)
+		return false
+	}, func() {}, func(found bool) {
+		result <- found
+	})
+
+	select {
+	case found := <-result:
+		if found {
+			t.Errorf("Expected a miss")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the retries")
+	}
+	if checks.Load() != 2 {
+		t.Errorf("Expected one check per delay, got %d", checks.Load())
+	}
+}
+
+func TestRetryQueue_Bounded(t *testing.T) {
+	q := NewRetryQueue([]time.Duration{time.Hour}, 2)
+	for i := 0; i < 2; i++ {
+		if !q.Hold(func() bool { return true }, func() {}, func(bool) {}) {
+			t.Fatalf("Expected event %d to be held", i)
+		}
+	}
+	if q.Hold(func() bool { return true }, func() {}, func(bool) {}) {
+		t.Errorf("Expected a full queue to refuse the event")
+	}
+	if q.Len() != 2 {
+		t.Errorf("Expected 2 held events, got %d", q.Len())
+	}
+}
+
+func TestParseRetryDelays(t *testing.T) {
+	delays, err := ParseRetryDelays(DefaultRetryDelays)
+	if err != nil {
+		t.Fatalf("Failed to parse default delays: %v", err)
+	}
+	want := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}
+	if len(delays) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, delays)
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, delays)
+		}
+	}
+
+	for _, bad := range []string{"1s,soon", "0s", "-1s"} {
+		if _, err := ParseRetryDelays(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
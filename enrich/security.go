@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+)
+
+// securityContext computes a container's effective security settings,
+// applying pod-level defaults and Kubernetes' own defaults. It returns nil
+// if the pod has no container with that name.
+func securityContext(pod *v1.Pod, containerName string) *models.SecurityContext {
+	sc, mounts, ok := containerSpec(pod, containerName)
+	if !ok {
+		return nil
+	}
+	podSC := pod.Spec.SecurityContext
+	if podSC == nil {
+		podSC = &v1.PodSecurityContext{}
+	}
+	if sc == nil {
+		sc = &v1.SecurityContext{}
+	}
+
+	result := &models.SecurityContext{
+		Privileged:             sc.Privileged != nil && *sc.Privileged,
+		ReadOnlyRootFilesystem: sc.ReadOnlyRootFilesystem != nil && *sc.ReadOnlyRootFilesystem,
+		HostPID:                pod.Spec.HostPID,
+		HostNetwork:            pod.Spec.HostNetwork,
+		HostIPC:                pod.Spec.HostIPC,
+	}
+	if sc.Capabilities != nil {
+		for _, c := range sc.Capabilities.Add {
+			result.CapabilitiesAdded = append(result.CapabilitiesAdded, string(c))
+		}
+	}
+
+	// Escalation is allowed unless disabled, and always for privileged or
+	// CAP_SYS_ADMIN containers
+	result.AllowPrivilegeEscalation = sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation ||
+		result.Privileged || hasCapability(result.CapabilitiesAdded, "SYS_ADMIN")
+
+	runAsNonRoot := sc.RunAsNonRoot
+	if runAsNonRoot == nil {
+		runAsNonRoot = podSC.RunAsNonRoot
+	}
+	runAsUser := sc.RunAsUser
+	if runAsUser == nil {
+		runAsUser = podSC.RunAsUser
+	}
+	result.RunAsRoot = !(runAsNonRoot != nil && *runAsNonRoot) && (runAsUser == nil || *runAsUser == 0)
+
+	hostPaths := make(map[string]string)
+	for _, vol := range pod.Spec.Volumes {
+		if vol.HostPath != nil {
+			hostPaths[vol.Name] = vol.HostPath.Path
+		}
+	}
+	for _, m := range mounts {
+		if path, ok := hostPaths[m.Name]; ok {
+			result.HostPathMounts = append(result.HostPathMounts, path)
+		}
+	}
+	return result
+}
+
+// containerSpec finds a container, init container or ephemeral container
+// by name
+func containerSpec(pod *v1.Pod, name string) (*v1.SecurityContext, []v1.VolumeMount, bool) {
+	for _, list := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range list {
+			if list[i].Name == name {
+				return list[i].SecurityContext, list[i].VolumeMounts, true
+			}
+		}
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		if c := &pod.Spec.EphemeralContainers[i]; c.Name == name {
+			return c.SecurityContext, c.VolumeMounts, true
+		}
+	}
+	return nil, nil, false
+}
+
+// hasCapability matches with or without the CAP_ prefix
+func hasCapability(caps []string, want string) bool {
+	for _, c := range caps {
+		if c == want || c == "CAP_"+want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodCache_EnrichSecurityContext(t *testing.T) {
+	yes, no := true, false
+	uid := int64(1000)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: "uid-1", Name: "agent", Namespace: "kube-system"},
+		Spec: v1.PodSpec{
+			HostPID:         true,
+			SecurityContext: &v1.PodSecurityContext{RunAsUser: &uid},
+			Volumes: []v1.Volume{
+				{Name: "docker-sock", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/var/run/docker.sock"}}},
+				{Name: "config", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+			},
+			Containers: []v1.Container{
+				{
+					Name: "agent",
+					SecurityContext: &v1.SecurityContext{
+						Privileged:   &yes,
+						RunAsUser:    new(int64),
+						Capabilities: &v1.Capabilities{Add: []v1.Capability{"SYS_PTRACE"}},
+					},
+					VolumeMounts: []v1.VolumeMount{{Name: "docker-sock"}, {Name: "config"}},
+				},
+				{
+					Name: "sidecar",
+					SecurityContext: &v1.SecurityContext{
+						AllowPrivilegeEscalation: &no,
+						ReadOnlyRootFilesystem:   &yes,
+					},
+				},
+			},
+		},
+		Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{
+			{Name: "agent", ContainerID: "containerd://aaaaaaaaaaaaaaaa"},
+			{Name: "sidecar", ContainerID: "containerd://bbbbbbbbbbbbbbbb"},
+		}},
+	}
+	pc, _ := testPodCache(DefaultTombstoneTTL, DefaultMaxTombstones)
+	pc.Update(pod)
+
+	tests := []struct {
+		containerID string
+		name        string
+		want        models.SecurityContext
+	}{
+		{
+			containerID: "aaaaaaaaaaaa",
+			name:        "agent",
+			want: models.SecurityContext{
+				Privileged: true, AllowPrivilegeEscalation: true, RunAsRoot: true, HostPID: true,
+				HostPathMounts: []string{"/var/run/docker.sock"}, CapabilitiesAdded: []string{"SYS_PTRACE"},
+			},
+		},
+		{
+			// Inherits the pod's non-root user
+			containerID: "bbbbbbbbbbbb",
+			name:        "sidecar",
+			want:        models.SecurityContext{ReadOnlyRootFilesystem: true, HostPID: true},
+		},
+	}
+	for _, tt := range tests {
+		event := &models.RuntimeEvent{Container: &models.ContainerInfo{ContainerID: tt.containerID}}
+		if !pc.Enrich(event) {
+			t.Fatalf("Expected %s to be enriched", tt.containerID)
+		}
+		if event.Container.Name != tt.name {
+			t.Errorf("Expected container %s, got %s", tt.name, event.Container.Name)
+		}
+		if got := event.Container.SecurityContext; got == nil || !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Unexpected security context for %s: %+v", tt.name, got)
+		}
+	}
+}
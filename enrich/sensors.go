@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/fleet"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+	"k8s.io/apimachinery/pkg/api/errors"
+	listers "k8s.io/client-go/listers/core/v1"
+)
+
+// silentSensorRule is the rule name on sensor_silent alerts
+const silentSensorRule = "Sensor Silent"
+
+// SilentSensorWatch raises a sensor_silent alert for nodes in this cluster
+// whose sensor has stopped reporting while the node still exists. A killed
+// sensor looks like a quiet node otherwise.
+type SilentSensorWatch struct {
+	inventory   *fleet.Inventory
+	nodes       listers.NodeLister
+	clusterID   string
+	silentAfter time.Duration
+	// alerted holds the last_seen each node was last alerted for, so a
+	// node is alerted once per silence. Entries are dropped once the node
+	// reports again or leaves the inventory.
+	alerted map[string]time.Time
+}
+
+func NewSilentSensorWatch(inventory *fleet.Inventory, nodes listers.NodeLister, clusterID string, silentAfter time.Duration) *SilentSensorWatch {
+	return &SilentSensorWatch{
+		inventory:   inventory,
+		nodes:       nodes,
+		clusterID:   clusterID,
+		silentAfter: silentAfter,
+		alerted:     make(map[string]time.Time),
+	}
+}
+
+// Run checks the inventory every interval. It never returns.
+func (w *SilentSensorWatch) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		w.check()
+	}
+}
+
+func (w *SilentSensorWatch) check() {
+	sensors, err := w.inventory.List(w.silentAfter)
+	if err != nil {
+		log.Printf("Error listing sensors: %v", err)
+		return
+	}
+
+	silent := make(map[string]bool)
+	for i := range sensors {
+		sensor := &sensors[i]
+		if sensor.ClusterID != w.clusterID || sensor.Status != fleet.StatusSilent {
+			continue
+		}
+		key := fleet.Key(sensor.ClusterID, sensor.NodeID)
+		silent[key] = true
+		if w.alerted[key].Equal(sensor.LastSeen) {
+			continue
+		}
+
+		// Nodes that were scaled down or replaced stop reporting for a reason
+		if _, err := w.nodes.Get(sensor.NodeID); err != nil {
+			if !errors.IsNotFound(err) {
+				log.Printf("Error looking up node %s: %v", sensor.NodeID, err)
+			}
+			continue
+		}
+
+		if err := publishSilentSensor(sensor); err != nil {
+			log.Printf("Error publishing sensor_silent alert for %s: %v", sensor.NodeID, err)
+			continue
+		}
+		w.alerted[key] = sensor.LastSeen
+		log.Printf("Sensor on node %s silent since %s", sensor.NodeID, sensor.LastSeen.Format(time.RFC3339))
+	}
+	w.forget(silent)
+}
+
+// forget drops the alerted entries of nodes that are no longer silent
+func (w *SilentSensorWatch) forget(silent map[string]bool) {
+	for key := range w.alerted {
+		if !silent[key] {
+			delete(w.alerted, key)
+		}
+	}
+}
+
+// publishSilentSensor sends a sensor_silent alert to the alerts stream. The
+// alert ID is derived from the node and when it was last seen, so replicas
+// checking the same inventory publish the same alert.
+func publishSilentSensor(sensor *models.Sensor) error {
+	name := fmt.Sprintf("sensor_silent/%s/%s/%d", sensor.ClusterID, sensor.NodeID, sensor.LastSeen.UnixNano())
+	id := uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)).String()
+	now := time.Now().UTC()
+
+	alert := models.Alert{
+		ID:        id,
+		Timestamp: now,
+		RuleName:  silentSensorRule,
+		Severity:  "high",
+		Description: fmt.Sprintf("Sensor on node %s has not reported since %s while the node still exists",
+			sensor.NodeID, sensor.LastSeen.Format(time.RFC3339)),
+		Event: &models.RuntimeEvent{
+			Timestamp: now,
+			ClusterID: sensor.ClusterID,
+			NodeID:    sensor.NodeID,
+			EventType: "sensor_silent",
+			EventID:   id,
+			Metadata: map[string]string{
+				"source":           "podwatch",
+				"sensor.source":    sensor.Source,
+				"sensor.version":   sensor.SensorVersion,
+				"sensor.last_seen": sensor.LastSeen.Format(time.RFC3339),
+			},
+		},
+	}
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return pipeline.Publish(js, pipeline.SubjectAlerts, data, jetstream.WithMsgID(alert.ID))
+}
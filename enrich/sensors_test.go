@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSilentSensorWatch_Forget(t *testing.T) {
+	w := NewSilentSensorWatch(nil, nil, "prod", time.Minute)
+	lastSeen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	w.alerted["prod.worker-1"] = lastSeen
+	w.alerted["prod.worker-2"] = lastSeen
+
+	// worker-1 is still silent; worker-2 reported again or left the inventory
+	w.forget(map[string]bool{"prod.worker-1": true})
+	if _, ok := w.alerted["prod.worker-1"]; !ok || len(w.alerted) != 1 {
+		t.Errorf("Expected only the still silent node to be kept, got %v", w.alerted)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+)
+
+// WorkloadResolver walks a pod's controller ownerReferences up to the
+// workload responders act on: ReplicaSet to Deployment and Job to CronJob.
+// StatefulSets, DaemonSets and other controllers own their pods directly.
+type WorkloadResolver struct {
+	replicaSets appslisters.ReplicaSetLister
+	jobs        batchlisters.JobLister
+}
+
+func NewWorkloadResolver(replicaSets appslisters.ReplicaSetLister, jobs batchlisters.JobLister) *WorkloadResolver {
+	return &WorkloadResolver{replicaSets: replicaSets, jobs: jobs}
+}
+
+// Resolve returns the kind and name of the pod's top-level owner, or empty
+// strings for a pod without a controller
+func (r *WorkloadResolver) Resolve(pod *v1.Pod) (kind, name string) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", ""
+	}
+
+	switch owner.Kind {
+	case "ReplicaSet":
+		if rs, err := r.replicaSets.ReplicaSets(pod.Namespace).Get(owner.Name); err == nil {
+			if parent := metav1.GetControllerOf(rs); parent != nil {
+				return parent.Kind, parent.Name
+			}
+			return owner.Kind, owner.Name
+		}
+		// The ReplicaSet is gone, e.g. for a tombstoned pod. Deployments
+		// name theirs <deployment>-<pod-template-hash>.
+		if hash := pod.Labels["pod-template-hash"]; hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	case "Job":
+		if job, err := r.jobs.Jobs(pod.Namespace).Get(owner.Name); err == nil {
+			if parent := metav1.GetControllerOf(job); parent != nil {
+				return parent.Kind, parent.Name
+			}
+		}
+	}
+	return owner.Kind, owner.Name
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func controllerRef(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func testWorkloadResolver(t *testing.T, objects ...interface{}) *WorkloadResolver {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range objects {
+		if err := indexer.Add(obj); err != nil {
+			t.Fatalf("Failed to add %T: %v", obj, err)
+		}
+	}
+	return NewWorkloadResolver(appslisters.NewReplicaSetLister(indexer), batchlisters.NewJobLister(indexer))
+}
+
+func TestWorkloadResolver_Resolve(t *testing.T) {
+	resolver := testWorkloadResolver(t,
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name: "vuln-nginx-7c9b", Namespace: "prod", OwnerReferences: controllerRef("Deployment", "vuln-nginx"),
+		}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name: "backup-28571234", Namespace: "prod", OwnerReferences: controllerRef("CronJob", "backup"),
+		}},
+	)
+
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		wantKind string
+		wantName string
+	}{
+		{
+			name:     "deployment",
+			pod:      &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", OwnerReferences: controllerRef("ReplicaSet", "vuln-nginx-7c9b")}},
+			wantKind: "Deployment", wantName: "vuln-nginx",
+		},
+		{
+			name:     "cronjob",
+			pod:      &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", OwnerReferences: controllerRef("Job", "backup-28571234")}},
+			wantKind: "CronJob", wantName: "backup",
+		},
+		{
+			name:     "statefulset",
+			pod:      &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", OwnerReferences: controllerRef("StatefulSet", "db")}},
+			wantKind: "StatefulSet", wantName: "db",
+		},
+		{
+			name: "deleted replicaset",
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "prod", Labels: map[string]string{"pod-template-hash": "5d8f"},
+				OwnerReferences: controllerRef("ReplicaSet", "api-5d8f"),
+			}},
+			wantKind: "Deployment", wantName: "api",
+		},
+		{
+			name:     "standalone job",
+			pod:      &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", OwnerReferences: controllerRef("Job", "migrate")}},
+			wantKind: "Job", wantName: "migrate",
+		},
+		{
+			name: "bare pod",
+			pod:  &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "prod"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, name := resolver.Resolve(tt.pod)
+			if kind != tt.wantKind || name != tt.wantName {
+				t.Errorf("Expected %s/%s, got %s/%s", tt.wantKind, tt.wantName, kind, name)
+			}
+		})
+	}
+}
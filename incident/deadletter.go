@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+)
+
+const deadLetterSchema = `
+	CREATE TABLE IF NOT EXISTS dead_letters (
+		id TEXT PRIMARY KEY,
+		timestamp TIMESTAMPTZ NOT NULL,
+		stage TEXT NOT NULL,
+		subject TEXT,
+		error TEXT,
+		source JSONB,
+		payload BYTEA,
+		redrive_count INT NOT NULL DEFAULT 0,
+		redriven_at TIMESTAMPTZ
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_dead_letters_stage ON dead_letters(stage, timestamp DESC);
+
+	ALTER TABLE dead_letters ADD COLUMN IF NOT EXISTS consumer TEXT;
+	ALTER TABLE dead_letters ADD COLUMN IF NOT EXISTS redrive_subject TEXT;
+	`
+
+// subscribeDeadLetters stores every stage's dead letters so they can be
+// listed and re-driven through the API
+func subscribeDeadLetters() {
+	_, err := pipeline.Consume(js, pipeline.StreamDeadLetter, pipeline.ConsumerDeadLetters, pipeline.SubjectDeadLetter, func(msg jetstream.Msg) {
+		var dl models.DeadLetter
+		if err := json.Unmarshal(msg.Data(), &dl); err != nil {
+			log.Printf("Error decoding dead letter: %v", err)
+			msg.Term()
+			return
+		}
+
+		sourceJSON, _ := json.Marshal(dl.Source)
+		_, err := db.Exec(`
+			INSERT INTO dead_letters (id, timestamp, stage, subject, consumer, redrive_subject, error, source, payload)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (id) DO NOTHING
+		`, dl.ID, dl.Timestamp, dl.Stage, dl.Subject, dl.Consumer, dl.Redrive, dl.Error, sourceJSON, dl.Payload)
+		if err != nil {
+			log.Printf("Error storing dead letter: %v", err)
+			msg.NakWithDelay(5 * time.Second)
+			return
+		}
+		msg.Ack()
+	})
+	if err != nil {
+		log.Fatalf("Error subscribing to dead letters: %v", err)
+	}
+}
+
+// listDeadLetters returns the most recent dead letters, without payloads.
+// Filters: stage, consumer, redriven (true/false), limit (default 100, max 1000).
+func listDeadLetters(c *gin.Context) {
+	query := `SELECT id, timestamp, stage, subject, consumer, redrive_subject, error, source, redrive_count, redriven_at FROM dead_letters WHERE TRUE`
+	args := []interface{}{}
+	if stage := c.Query("stage"); stage != "" {
+		args = append(args, stage)
+		query += " AND stage = $" + strconv.Itoa(len(args))
+	}
+	if consumer := c.Query("consumer"); consumer != "" {
+		args = append(args, consumer)
+		query += " AND consumer = $" + strconv.Itoa(len(args))
+	}
+	switch c.Query("redriven") {
+	case "true":
+		query += " AND redriven_at IS NOT NULL"
+	case "false":
+		query += " AND redriven_at IS NULL"
+	}
+	limit := 100
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= 1000 {
+		limit = v
+	}
+	args = append(args, limit)
+	query += " ORDER BY timestamp DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	deadLetters := []map[string]interface{}{}
+	for rows.Next() {
+		var id, stage, subject, consumer, redrive, errMsg sql.NullString
+		var timestamp time.Time
+		var source json.RawMessage
+		var redriveCount int
+		var redrivenAt sql.NullTime
+		rows.Scan(&id, &timestamp, &stage, &subject, &consumer, &redrive, &errMsg, &source, &redriveCount, &redrivenAt)
+		entry := map[string]interface{}{
+			"id":            id.String,
+			"timestamp":     timestamp,
+			"stage":         stage.String,
+			"subject":       subject.String,
+			"consumer":      consumer.String,
+			"redrive":       redrive.String,
+			"error":         errMsg.String,
+			"source":        source,
+			"redrive_count": redriveCount,
+		}
+		if redrivenAt.Valid {
+			entry["redriven_at"] = redrivenAt.Time
+		}
+		deadLetters = append(deadLetters, entry)
+	}
+	c.JSON(200, deadLetters)
+}
+
+func getDeadLetter(c *gin.Context) {
+	dl, err := loadDeadLetter(c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, dl)
+}
+
+// redriveDeadLetter re-publishes a dead letter's payload to the redrive
+// subject of the consumer that failed it, so that only that consumer sees it
+// again. Dead letters without one, such as events ingest rejected because
+// the client certificate didn't allow them, can't be re-driven.
+func redriveDeadLetter(c *gin.Context) {
+	dl, err := loadDeadLetter(c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := redrive(dl); err != nil {
+		c.JSON(redriveStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"status": "redriven", "subject": dl.Redrive})
+}
+
+// redriveDeadLetters re-drives every dead letter from a stage, optionally
+// only those from one of its consumers, that hasn't been re-driven yet, e.g.
+// once the bug that caused them is fixed
+func redriveDeadLetters(c *gin.Context) {
+	var req struct {
+		Stage    string `json:"stage"`
+		Consumer string `json:"consumer"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.Stage == "" {
+		c.JSON(400, gin.H{"error": "stage is required"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id FROM dead_letters
+		WHERE stage = $1 AND ($2::text = '' OR consumer = $2)
+			AND redriven_at IS NULL AND redrive_subject <> ''
+		ORDER BY timestamp ASC
+		LIMIT 1000
+	`, req.Stage, req.Consumer)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	redriven := 0
+	for _, id := range ids {
+		dl, err := loadDeadLetter(id)
+		if err == nil {
+			err = redrive(dl)
+		}
+		if err != nil {
+			c.JSON(redriveStatus(err), gin.H{"error": err.Error(), "redriven": redriven})
+			return
+		}
+		redriven++
+	}
+	c.JSON(200, gin.H{"status": "redriven", "redriven": redriven})
+}
+
+func loadDeadLetter(id string) (*models.DeadLetter, error) {
+	dl := &models.DeadLetter{}
+	var subject, consumer, redrive, errMsg sql.NullString
+	var source []byte
+	err := db.QueryRow(`
+		SELECT id, timestamp, stage, subject, consumer, redrive_subject, error, source, payload
+		FROM dead_letters WHERE id = $1
+	`, id).Scan(&dl.ID, &dl.Timestamp, &dl.Stage, &subject, &consumer, &redrive, &errMsg, &source, &dl.Payload)
+	if err != nil {
+		return nil, err
+	}
+	dl.Subject = subject.String
+	dl.Consumer = consumer.String
+	dl.Redrive = redrive.String
+	dl.Error = errMsg.String
+	json.Unmarshal(source, &dl.Source)
+	return dl, nil
+}
+
+// errNotRedrivable is returned for dead letters without a redrive subject
+var errNotRedrivable = errors.New("dead letter has no redrive subject and can't be re-driven")
+
+func redrive(dl *models.DeadLetter) error {
+	if dl.Redrive == "" {
+		return errNotRedrivable
+	}
+	if err := pipeline.Publish(js, dl.Redrive, dl.Payload); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		UPDATE dead_letters SET redrive_count = redrive_count + 1, redriven_at = NOW()
+		WHERE id = $1
+	`, dl.ID)
+	return err
+}
+
+func redriveStatus(err error) int {
+	if errors.Is(err, errNotRedrivable) {
+		return 409
+	}
+	if pipeline.IsUnavailable(err) {
+		return 503
+	}
+	return 500
+}
@@ -12,12 +12,15 @@ import (
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
 )
 
 var (
 	db       *sql.DB
 	natsConn *nats.Conn
+	js       jetstream.JetStream
 )
 
 func main() {
@@ -63,8 +66,14 @@ func main() {
 	}
 	defer natsConn.Close()
 
-	// 3. Subscribe to alerts
+	js, err = pipeline.New(natsConn)
+	if err != nil {
+		log.Fatalf("Error setting up JetStream: %v", err)
+	}
+
+	// 3. Subscribe to alerts and dead letters
 	go subscribeAlerts()
+	go subscribeDeadLetters()
 
 	// 4. HTTP API
 	r := gin.Default()
@@ -82,6 +91,12 @@ func main() {
 	r.PATCH("/v1/incidents/:id", updateIncident)
 	r.GET("/v1/incidents/:id/timeline", getIncidentTimeline)
 
+	// Dead letter API
+	r.GET("/v1/deadletters", listDeadLetters)
+	r.GET("/v1/deadletters/:id", getDeadLetter)
+	r.POST("/v1/deadletters/:id/redrive", redriveDeadLetter)
+	r.POST("/v1/deadletters/redrive", redriveDeadLetters)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
@@ -129,45 +144,72 @@ func initSchema() {
 	CREATE INDEX IF NOT EXISTS idx_alerts_timestamp ON alerts(timestamp DESC);
 	CREATE INDEX IF NOT EXISTS idx_incidents_status ON incidents(status);
 	`
-	_, err := db.Exec(schema)
+	_, err := db.Exec(schema + deadLetterSchema)
 	if err != nil {
 		log.Fatalf("Error creating schema: %v", err)
 	}
 }
 
 func subscribeAlerts() {
-	_, err := natsConn.QueueSubscribe("alerts", "incident-workers", func(msg *nats.Msg) {
+	// Durable consumer: alerts published while this service is restarting are
+	// delivered once it comes back
+	_, err := pipeline.Consume(js, pipeline.StreamAlerts, pipeline.ConsumerIncident, pipeline.SubjectAlerts, func(msg jetstream.Msg) {
 		var alert models.Alert
-		if err := json.Unmarshal(msg.Data, &alert); err != nil {
+		if err := json.Unmarshal(msg.Data(), &alert); err != nil {
 			log.Printf("Error decoding alert: %v", err)
+			pipeline.DeadLetter(js, "incident", msg, err, nil)
+			msg.Term()
 			return
 		}
 
-		// Store alert
+		// Store alert. Redelivered alerts are already stored, so keep the
+		// incident they were assigned to instead of creating another one.
 		eventJSON, _ := json.Marshal(alert.Event)
 		_, err := db.Exec(`
 			INSERT INTO alerts (id, timestamp, rule_name, severity, description, event, response)
 			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (id) DO NOTHING
 		`, alert.ID, alert.Timestamp, alert.RuleName, alert.Severity, alert.Description, eventJSON, alert.Response)
 		if err != nil {
 			log.Printf("Error storing alert: %v", err)
+			pipeline.Retry(js, "incident", msg, err, nil)
 			return
 		}
 
-		// Find or create incident
-		incidentID, err := findOrCreateIncident(alert)
-		if err != nil {
-			log.Printf("Error handling incident: %v", err)
+		var existing sql.NullString
+		if err := db.QueryRow(`SELECT incident_id FROM alerts WHERE id = $1`, alert.ID).Scan(&existing); err != nil {
+			log.Printf("Error loading alert: %v", err)
+			pipeline.Retry(js, "incident", msg, err, nil)
 			return
 		}
 
-		// Update alert with incident ID
-		db.Exec(`UPDATE alerts SET incident_id = $1 WHERE id = $2`, incidentID, alert.ID)
+		incidentID := existing.String
+		if incidentID == "" {
+			// Find or create incident
+			incidentID, err = findOrCreateIncident(alert)
+			if err != nil {
+				log.Printf("Error handling incident: %v", err)
+				pipeline.Retry(js, "incident", msg, err, nil)
+				return
+			}
+
+			// Update alert with incident ID
+			if _, err := db.Exec(`UPDATE alerts SET incident_id = $1 WHERE id = $2`, incidentID, alert.ID); err != nil {
+				log.Printf("Error linking alert to incident: %v", err)
+				pipeline.Retry(js, "incident", msg, err, nil)
+				return
+			}
+		}
 
 		// Publish for response orchestrator
 		alert.IncidentID = incidentID
 		data, _ := json.Marshal(alert)
-		natsConn.Publish("alerts.processed", data)
+		if err := pipeline.Publish(js, pipeline.SubjectAlertsProcessed, data, jetstream.WithMsgID(alert.ID)); err != nil {
+			log.Printf("Error publishing processed alert: %v", err)
+			pipeline.Retry(js, "incident", msg, err, nil)
+			return
+		}
+		msg.Ack()
 	})
 	if err != nil {
 		log.Fatalf("Error subscribing to alerts: %v", err)
@@ -177,8 +219,12 @@ func subscribeAlerts() {
 func findOrCreateIncident(alert models.Alert) (string, error) {
 	// Look for recent open incident with same severity and namespace
 	namespace := ""
+	workload := ""
 	if alert.Event != nil && alert.Event.Container != nil {
 		namespace = alert.Event.Container.Namespace
+		if alert.Event.Container.WorkloadKind != "" {
+			workload = alert.Event.Container.WorkloadKind + "/" + alert.Event.Container.WorkloadName
+		}
 	}
 
 	var incidentID string
@@ -195,7 +241,10 @@ func findOrCreateIncident(alert models.Alert) (string, error) {
 		// Create new incident
 		incidentID = uuid.New().String()
 		title := alert.RuleName
-		if namespace != "" {
+		switch {
+		case workload != "" && namespace != "":
+			title = title + " in " + namespace + "/" + workload
+		case namespace != "":
 			title = title + " in " + namespace
 		}
 
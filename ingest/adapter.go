@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+)
+
+// EventAdapter normalizes events from a third-party runtime sensor into
+// RuntimeEvents. Each adapter has its own endpoint under /v1, and can also be
+// selected on POST /v1/events by sending its media type as the Content-Type.
+type EventAdapter struct {
+	Name      string
+	MediaType string
+	Normalize func(raw []byte) (*models.RuntimeEvent, error)
+}
+
+var eventAdapters = []EventAdapter{
+	{Name: "falco", MediaType: "application/vnd.falco+json", Normalize: normalizeFalco},
+	{Name: "tetragon", MediaType: "application/vnd.tetragon+json", Normalize: normalizeTetragon},
+	{Name: "tracee", MediaType: "application/vnd.tracee+json", Normalize: normalizeTracee},
+}
+
+// adapterForContentType returns the adapter registered for the request's
+// media type, or nil for plain RuntimeEvent JSON
+func adapterForContentType(contentType string) *EventAdapter {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	for i := range eventAdapters {
+		if eventAdapters[i].MediaType == mediaType {
+			return &eventAdapters[i]
+		}
+	}
+	return nil
+}
+
+// adapterHandler returns the handler for an adapter's own endpoint
+func adapterHandler(adapter *EventAdapter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handleAdaptedEvent(c, adapter)
+	}
+}
+
+// handleAdaptedEvent normalizes a single third-party event with adapter and
+// ingests it as a RuntimeEvent. Bodies are capped like a line of a batch.
+func handleAdaptedEvent(c *gin.Context, adapter *EventAdapter) {
+	bodyBytes, ok := readBody(c, maxLineBytes)
+	if !ok {
+		return
+	}
+
+	event, err := adapter.Normalize(bodyBytes)
+	if err != nil {
+		deadLetter(c, bodyBytes, nil, err)
+		c.JSON(400, gin.H{"error": "invalid " + adapter.Name + " event"})
+		return
+	}
+	event.ClusterID = resolveClusterID(requestIdentity(c))
+
+	if err := validateEvent(event); err != nil {
+		deadLetter(c, bodyBytes, event, err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := authorizeEvent(requestIdentity(c), event, c.ClientIP()); err != nil {
+		deadLetter(c, bodyBytes, event, err)
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+	if event.EventType == heartbeatEventType {
+		if sensors != nil {
+			sensors.ObserveHeartbeat(heartbeatFromEvent(event))
+		}
+		c.JSON(200, gin.H{"status": "ok"})
+		return
+	}
+	if err := admitEvent(event); err != nil {
+		respondRateLimited(c, asRateLimited(err))
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "internal error"})
+		return
+	}
+	if err := acceptEvent(event, data); err != nil {
+		if errors.Is(err, errDuplicate) {
+			c.JSON(200, gin.H{"status": "duplicate", "event_id": event.EventID})
+			return
+		}
+		log.Printf("Error publishing to NATS: %v", err)
+		if pipeline.IsUnavailable(err) {
+			c.Header("Retry-After", retryAfterSeconds)
+			c.JSON(503, gin.H{"error": "event stream unavailable"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "accepted", "event_id": event.EventID})
+}
+
+// capabilityNames are the Linux capabilities by number, without the CAP_
+// prefix
+var capabilityNames = []string{
+	"CHOWN", "DAC_OVERRIDE", "DAC_READ_SEARCH", "FOWNER", "FSETID", "KILL",
+	"SETGID", "SETUID", "SETPCAP", "LINUX_IMMUTABLE", "NET_BIND_SERVICE",
+	"NET_BROADCAST", "NET_ADMIN", "NET_RAW", "IPC_LOCK", "IPC_OWNER",
+	"SYS_MODULE", "SYS_RAWIO", "SYS_CHROOT", "SYS_PTRACE", "SYS_PACCT",
+	"SYS_ADMIN", "SYS_BOOT", "SYS_NICE", "SYS_RESOURCE", "SYS_TIME",
+	"SYS_TTY_CONFIG", "MKNOD", "LEASE", "AUDIT_WRITE", "AUDIT_CONTROL",
+	"SETFCAP", "MAC_OVERRIDE", "MAC_ADMIN", "SYSLOG", "WAKE_ALARM",
+	"BLOCK_SUSPEND", "AUDIT_READ", "PERFMON", "BPF", "CHECKPOINT_RESTORE",
+}
+
+// gainedCapabilities returns the capabilities in after that weren't in
+// before, such as those new credentials from setuid or capset grant, without
+// the CAP_ prefix
+func gainedCapabilities(before, after []string) []string {
+	had := make(map[string]bool, len(before))
+	for _, cap := range before {
+		had[strings.TrimPrefix(strings.ToUpper(cap), "CAP_")] = true
+	}
+	var gained []string
+	for _, cap := range after {
+		cap = strings.TrimPrefix(strings.ToUpper(cap), "CAP_")
+		if !had[cap] {
+			had[cap] = true
+			gained = append(gained, cap)
+		}
+	}
+	return gained
+}
+
+// resolveClusterID picks the cluster for sources that don't report one. A
+// client certificate bound to exactly one cluster wins; otherwise the
+// CLUSTER_ID setting is used.
+func resolveClusterID(id *SensorIdentity) string {
+	if id != nil {
+		if cluster := id.SingleCluster(); cluster != "" {
+			return cluster
+		}
+	}
+	return os.Getenv("CLUSTER_ID")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite adapter golden files")
+
+// TestAdapters_Golden normalizes each fixture payload with its adapter and
+// compares the result to test/fixtures/golden/<fixture>. Run with -update to
+// regenerate the golden files after an intended change.
+func TestAdapters_Golden(t *testing.T) {
+	cases := []struct {
+		adapter string
+		fixture string
+	}{
+		{"falco", "falco_shell_spawn_alert.json"},
+		{"tetragon", "tetragon_process_exec.json"},
+		{"tetragon", "tetragon_process_kprobe_connect.json"},
+		{"tracee", "tracee_sched_process_exec.json"},
+		{"tracee", "tracee_security_socket_connect.json"},
+	}
+
+	fixtures := filepath.Join("..", "test", "fixtures")
+	for _, tc := range cases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			adapter := adapterByName(tc.adapter)
+			if adapter == nil {
+				t.Fatalf("No adapter named %q", tc.adapter)
+			}
+			raw, err := os.ReadFile(filepath.Join(fixtures, tc.fixture))
+			if err != nil {
+				t.Fatalf("Fixture not found: %v", err)
+			}
+
+			event, err := adapter.Normalize(raw)
+			if err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+			got, err := json.MarshalIndent(event, "", "  ")
+			if err != nil {
+				t.Fatalf("Failed to marshal event: %v", err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join(fixtures, "golden", tc.fixture)
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("Failed to write golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Normalized event differs from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestAdapterForContentType(t *testing.T) {
+	tests := map[string]string{
+		"application/vnd.tetragon+json":              "tetragon",
+		"application/vnd.tracee+json; charset=utf-8": "tracee",
+		"application/vnd.falco+json":                 "falco",
+		"application/json":                           "",
+		"":                                           "",
+	}
+	for contentType, want := range tests {
+		got := ""
+		if adapter := adapterForContentType(contentType); adapter != nil {
+			got = adapter.Name
+		}
+		if got != want {
+			t.Errorf("%q: expected adapter %q, got %q", contentType, want, got)
+		}
+	}
+}
+
+func TestHandleAdaptedEvent_BodyTooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/events", handleEvent)
+
+	body := io.LimitReader(endlessReader{}, maxLineBytes+1)
+	req := httptest.NewRequest(http.MethodPost, "/v1/events", body)
+	req.Header.Set("Content-Type", "application/vnd.falco+json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 413 {
+		t.Errorf("Expected 413, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestNormalizeTetragon_Unsupported(t *testing.T) {
+	if _, err := normalizeTetragon([]byte(`{"process_exit":{},"node_name":"n1"}`)); err == nil {
+		t.Error("Expected an error for an unsupported Tetragon event")
+	}
+}
+
+func adapterByName(name string) *EventAdapter {
+	for i := range eventAdapters {
+		if eventAdapters[i].Name == name {
+			return &eventAdapters[i]
+		}
+	}
+	return nil
+}
+
+func TestAdapters_GainedCapabilities(t *testing.T) {
+	// setuid(0) from a process that only had CHOWN
+	tetragon := `{"process_kprobe": {
+		"process": {"pid": 42, "binary": "/tmp/exploit", "cap": {"permitted": ["CAP_CHOWN"], "effective": ["CAP_CHOWN"]}},
+		"function_name": "commit_creds",
+		"args": [{"process_credentials_arg": {"caps": {"permitted": ["CAP_CHOWN", "CAP_SYS_ADMIN"], "effective": ["CAP_CHOWN", "CAP_SYS_ADMIN", "CAP_NET_ADMIN"]}}}]
+	}, "node_name": "kind-worker"}`
+	event, err := normalizeTetragon([]byte(tetragon))
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if got := event.Process.CapabilitiesAdded; !slices.Equal(got, []string{"SYS_ADMIN", "NET_ADMIN"}) {
+		t.Errorf("Expected SYS_ADMIN and NET_ADMIN gained, got %v", got)
+	}
+
+	// A capability check grants nothing
+	tetragon = `{"process_kprobe": {
+		"process": {"pid": 42, "binary": "/bin/mount", "cap": {"effective": ["CAP_SYS_ADMIN"]}},
+		"function_name": "cap_capable",
+		"args": [{"capability_arg": {"value": 21, "name": "CAP_SYS_ADMIN"}}]
+	}, "node_name": "kind-worker"}`
+	if event, err = normalizeTetragon([]byte(tetragon)); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if event.EventType != "capability_check" || event.Metadata["capability"] != "SYS_ADMIN" || len(event.Process.CapabilitiesAdded) != 0 {
+		t.Errorf("Expected a SYS_ADMIN check without capabilities added, got %s %v %v", event.EventType, event.Metadata, event.Process.CapabilitiesAdded)
+	}
+
+	// CHOWN is bit 0, SYS_ADMIN bit 21 and NET_RAW bit 13
+	tracee := `{"eventName": "commit_creds", "processId": 42, "hostName": "kind-worker", "args": [
+		{"name": "old_cred", "type": "slim_cred_t", "value": {"CapPermitted": 1, "CapEffective": 1}},
+		{"name": "new_cred", "type": "slim_cred_t", "value": {"CapPermitted": 2097153, "CapEffective": 2105345}}
+	]}`
+	if event, err = normalizeTracee([]byte(tracee)); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if got := event.Process.CapabilitiesAdded; !slices.Equal(got, []string{"SYS_ADMIN", "NET_RAW"}) {
+		t.Errorf("Expected SYS_ADMIN and NET_RAW gained, got %v", got)
+	}
+
+	tracee = `{"eventName": "cap_capable", "processId": 42, "hostName": "kind-worker", "args": [{"name": "cap", "type": "int", "value": "CAP_SYS_ADMIN"}]}`
+	if event, err = normalizeTracee([]byte(tracee)); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if event.EventType != "capability_check" || len(event.Process.CapabilitiesAdded) != 0 {
+		t.Errorf("Expected a check without capabilities added, got %s %v", event.EventType, event.Process.CapabilitiesAdded)
+	}
+}
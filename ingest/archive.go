@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+const (
+	// maxArchiveRange bounds how much history one search may scan
+	maxArchiveRange = 7 * 24 * time.Hour
+	// defaultArchiveLimit and maxArchiveLimit bound how many events a search returns
+	defaultArchiveLimit = 1000
+	maxArchiveLimit     = 100000
+	// archiveFlushSlack covers the gap between the flush interval passing and
+	// the writer's ticker noticing
+	archiveFlushSlack = 10 * time.Second
+)
+
+// errArchiveLimit stops a search once enough events were returned
+var errArchiveLimit = errors.New("archive search limit reached")
+
+// ArchiveQuery selects archived events. ClusterID and the time range are
+// required; the other fields are optional filters.
+type ArchiveQuery struct {
+	ClusterID   string
+	NodeID      string
+	From        time.Time
+	To          time.Time
+	ContainerID string
+	Pod         string
+	Namespace   string
+	EventType   string
+	// Allows, when set, is checked for every event's cluster and node
+	Allows func(clusterID, nodeID string) bool
+}
+
+// Matches reports whether an archived event passes the query's filters
+func (q *ArchiveQuery) Matches(event *models.RuntimeEvent) bool {
+	if event.ClusterID != q.ClusterID || (q.NodeID != "" && event.NodeID != q.NodeID) {
+		return false
+	}
+	received := archivedAt(event)
+	if received.Before(q.From) || received.After(q.To) {
+		return false
+	}
+	if q.EventType != "" && event.EventType != q.EventType {
+		return false
+	}
+	if q.ContainerID != "" || q.Pod != "" || q.Namespace != "" {
+		c := event.Container
+		if c == nil {
+			return false
+		}
+		if q.ContainerID != "" && !matchContainerID(c.ContainerID, q.ContainerID) {
+			return false
+		}
+		if (q.Pod != "" && c.Pod != q.Pod) || (q.Namespace != "" && c.Namespace != q.Namespace) {
+			return false
+		}
+	}
+	return q.Allows == nil || q.Allows(event.ClusterID, event.NodeID)
+}
+
+// archivedAt is when ingest received an archived event. Events archived
+// before received_at was stored only have the sensor's timestamp.
+func archivedAt(event *models.RuntimeEvent) time.Time {
+	if event.ReceivedAt != nil {
+		return *event.ReceivedAt
+	}
+	return event.Timestamp
+}
+
+// matchContainerID compares a container ID with or without its runtime
+// prefix (containerd://), and accepts short IDs of at least 12 characters
+func matchContainerID(id, want string) bool {
+	if id == want {
+		return true
+	}
+	if i := strings.Index(id, "://"); i >= 0 {
+		id = id[i+3:]
+	}
+	return id == want || (len(want) >= 12 && strings.HasPrefix(id, want))
+}
+
+// Search streams every archived event received in q's time range and
+// matching its filters to fn, in upload order. Objects are keyed by upload
+// time, so objects uploaded up to one flush interval after q.To are read
+// too. Events are matched on received_at rather than the sensor's ts, so
+// events from nodes with a skewed clock are found like any other. Objects
+// that can't be read are logged and skipped.
+func (a *Archiver) Search(q *ArchiveQuery, fn func(raw []byte) error) error {
+	keys, err := a.objectKeys(q)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := a.searchObject(key, q, fn); err != nil {
+			if errors.Is(err, errArchiveLimit) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// objectKeys lists the objects that may hold events in q's time range,
+// sorted by upload time
+func (a *Archiver) objectKeys(q *ArchiveQuery) ([]string, error) {
+	last := q.To.Add(a.flushInterval + archiveFlushSlack)
+	type object struct {
+		key      string
+		uploaded int64
+	}
+	var objects []object
+	for day := q.From.UTC().Truncate(24 * time.Hour); !day.After(last); day = day.Add(24 * time.Hour) {
+		prefix := fmt.Sprintf("raw/%s/%s/", q.ClusterID, day.Format("2006-01-02"))
+		if q.NodeID != "" {
+			prefix += q.NodeID + "/"
+		}
+		keys, err := a.store.List(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		for _, key := range keys {
+			// raw/<cluster>/<date>/<node>/<hour>/<upload nanos>.jsonl.gz
+			parts := strings.Split(key, "/")
+			if len(parts) != 6 {
+				continue
+			}
+			uploaded, err := strconv.ParseInt(strings.TrimSuffix(parts[5], ".jsonl.gz"), 10, 64)
+			if err != nil {
+				continue
+			}
+			// A batch only holds events received before it was uploaded
+			if uploaded < q.From.UnixNano() || uploaded > last.UnixNano() {
+				continue
+			}
+			if q.Allows != nil && !q.Allows(q.ClusterID, parts[3]) {
+				continue
+			}
+			objects = append(objects, object{key, uploaded})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].uploaded < objects[j].uploaded })
+
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = obj.key
+	}
+	return keys, nil
+}
+
+// searchObject decodes one gzip JSONL object. Payloads are read as a stream
+// of JSON values, so events sent pretty-printed are still split correctly.
+func (a *Archiver) searchObject(key string, q *ArchiveQuery, fn func(raw []byte) error) error {
+	body, err := a.store.Get(key)
+	if err != nil {
+		log.Printf("Error reading archive object %s: %v", key, err)
+		return nil
+	}
+	defer body.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(body))
+	if err != nil {
+		log.Printf("Error reading archive object %s: %v", key, err)
+		return nil
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err != io.EOF {
+				log.Printf("Error decoding archive object %s: %v", key, err)
+			}
+			return nil
+		}
+		var event models.RuntimeEvent
+		if err := json.Unmarshal(raw, &event); err != nil || !q.Matches(&event) {
+			continue
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+}
+
+// searchArchive streams archived events as newline-delimited JSON. With
+// identity binding, only clusters and nodes the client certificate may
+// report for are returned.
+func searchArchive(c *gin.Context) {
+	if archiver == nil {
+		c.JSON(503, gin.H{"error": "raw event archive disabled"})
+		return
+	}
+
+	id := requestIdentity(c)
+	q := &ArchiveQuery{
+		ClusterID:   c.Query("cluster_id"),
+		NodeID:      c.Query("node_id"),
+		ContainerID: c.Query("container_id"),
+		Pod:         c.Query("pod"),
+		Namespace:   c.Query("namespace"),
+		EventType:   c.Query("event_type"),
+	}
+	if q.ClusterID == "" {
+		q.ClusterID = resolveClusterID(id)
+	}
+	if !validClusterID(q.ClusterID) || (q.NodeID != "" && !validKeySegment(q.NodeID)) {
+		c.JSON(400, gin.H{"error": "invalid cluster_id or node_id"})
+		return
+	}
+	if id != nil {
+		q.Allows = id.Allows
+	}
+
+	var err error
+	q.To = time.Now().UTC()
+	if v := c.Query("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(400, gin.H{"error": "invalid to: must be RFC 3339"})
+			return
+		}
+	}
+	q.From = q.To.Add(-time.Hour)
+	if v := c.Query("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(400, gin.H{"error": "invalid from: must be RFC 3339"})
+			return
+		}
+	}
+	if q.To.Before(q.From) || q.To.Sub(q.From) > maxArchiveRange {
+		c.JSON(400, gin.H{"error": "from must be before to and at most 7 days apart"})
+		return
+	}
+	limit := defaultArchiveLimit
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxArchiveLimit {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxArchiveLimit)})
+			return
+		}
+	}
+
+	// Headers are only sent with the first event, so listing failures can
+	// still be reported as errors
+	count := 0
+	var line bytes.Buffer
+	err = archiver.Search(q, func(raw []byte) error {
+		if count == 0 {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(200)
+		}
+		line.Reset()
+		if err := json.Compact(&line, raw); err != nil {
+			return err
+		}
+		line.WriteByte('\n')
+		if _, err := c.Writer.Write(line.Bytes()); err != nil {
+			return err
+		}
+		count++
+		if count%100 == 0 {
+			c.Writer.Flush()
+		}
+		if count >= limit {
+			return errArchiveLimit
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error searching archive: %v", err)
+		if count == 0 {
+			c.JSON(500, gin.H{"error": "internal error"})
+		}
+		return
+	}
+	if count == 0 {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(200)
+	}
+}
+
+// validKeySegment rejects values that would change the archive key layout,
+// escape the archive directory, or leave an empty token in an
+// events.raw.<cluster>.<node> subject. Dots are allowed between tokens,
+// since Kubernetes node names contain them; see validClusterID.
+func validKeySegment(s string) bool {
+	if s == "" || strings.ContainsAny(s, "/\\*> \t\r\n") {
+		return false
+	}
+	for _, token := range strings.Split(s, ".") {
+		if token == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// validClusterID is validKeySegment for cluster IDs, which also may not
+// contain dots: the cluster is a single subject token, so that subjects and
+// sensor inventory keys split into cluster and node unambiguously.
+func validClusterID(s string) bool {
+	return validKeySegment(s) && !strings.Contains(s, ".")
+}
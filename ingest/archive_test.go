@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// putArchiveObject stores events as one batch uploaded at uploaded, as a
+// BatchWriter would
+func putArchiveObject(t *testing.T, store ArchiveStore, clusterID, nodeID string, uploaded time.Time, events ...models.RuntimeEvent) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, event := range events {
+		data, _ := json.Marshal(event)
+		gz.Write(append(data, '\n'))
+	}
+	gz.Close()
+	if err := store.Put(archiveKey(clusterID, nodeID, uploaded.UTC()), buf.Bytes()); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+}
+
+func archivedEvent(id, nodeID string, ts time.Time) models.RuntimeEvent {
+	return models.RuntimeEvent{EventID: id, ClusterID: "kind-local", NodeID: nodeID, Timestamp: ts}
+}
+
+// searchIDs returns the IDs of the events Search hands back for q
+func searchIDs(t *testing.T, archiver *Archiver, q *ArchiveQuery) []string {
+	t.Helper()
+	var ids []string
+	err := archiver.Search(q, func(raw []byte) error {
+		var event models.RuntimeEvent
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return err
+		}
+		ids = append(ids, event.EventID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	return ids
+}
+
+func TestArchiver_SearchRoundTrip(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	archiver := NewArchiver(store, time.Hour)
+
+	now := time.Now().UTC()
+	for _, event := range []models.RuntimeEvent{
+		archivedEvent("evt-1", "worker-1", now.Add(-time.Minute)),
+		archivedEvent("evt-2", "worker-2", now.Add(-time.Minute)),
+		{EventID: "evt-3", ClusterID: "prod-eu", NodeID: "worker-1", Timestamp: now.Add(-time.Minute)},
+	} {
+		data, _ := json.Marshal(event)
+		if err := archiver.Write(&event, data); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := archiver.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	q := &ArchiveQuery{ClusterID: "kind-local", From: now.Add(-time.Hour), To: now}
+	got := searchIDs(t, archiver, q)
+	sort.Strings(got)
+	if strings.Join(got, ",") != "evt-1,evt-2" {
+		t.Errorf("Expected kind-local's events, got %v", got)
+	}
+	q.NodeID = "worker-2"
+	if got = searchIDs(t, archiver, q); len(got) != 1 || got[0] != "evt-2" {
+		t.Errorf("Expected worker-2's event, got %v", got)
+	}
+}
+
+func TestArchiver_SearchTimeRange(t *testing.T) {
+	store, _ := NewLocalStore(t.TempDir())
+	archiver := NewArchiver(store, time.Minute)
+	defer archiver.Close()
+
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	// Uploaded before the range, so it can't hold events received in it
+	putArchiveObject(t, store, "kind-local", "worker-1", from.Add(-time.Second),
+		archivedEvent("before", "worker-1", from.Add(-2*time.Second)))
+	// Uploaded in the range, with one event on each side of it
+	putArchiveObject(t, store, "kind-local", "worker-1", from.Add(30*time.Minute),
+		archivedEvent("in-range", "worker-1", from.Add(10*time.Minute)),
+		archivedEvent("too-late", "worker-1", to.Add(time.Second)))
+	// Uploaded within a flush interval after the range
+	putArchiveObject(t, store, "kind-local", "worker-1", to.Add(30*time.Second),
+		archivedEvent("flushed-late", "worker-1", to.Add(-time.Second)))
+	// Uploaded well after the range, so it is never read
+	putArchiveObject(t, store, "kind-local", "worker-1", to.Add(time.Hour),
+		archivedEvent("never-read", "worker-1", to.Add(-time.Second)))
+
+	got := searchIDs(t, archiver, &ArchiveQuery{ClusterID: "kind-local", From: from, To: to})
+	if strings.Join(got, ",") != "in-range,flushed-late" {
+		t.Errorf("Expected in-range and flushed-late, in upload order, got %v", got)
+	}
+}
+
+func TestArchiveQuery_ContainerID(t *testing.T) {
+	event := &models.RuntimeEvent{
+		ClusterID: "kind-local",
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Container: &models.ContainerInfo{ContainerID: "containerd://4f3c2b1a0e9d8c7b6a5f"},
+	}
+	q := &ArchiveQuery{ClusterID: "kind-local", From: event.Timestamp, To: event.Timestamp}
+
+	for _, id := range []string{"containerd://4f3c2b1a0e9d8c7b6a5f", "4f3c2b1a0e9d8c7b6a5f", "4f3c2b1a0e9d"} {
+		q.ContainerID = id
+		if !q.Matches(event) {
+			t.Errorf("Expected %q to match", id)
+		}
+	}
+	// Prefixes shorter than 12 characters are too ambiguous
+	for _, id := range []string{"4f3c2b", "5f3c2b1a0e9d"} {
+		q.ContainerID = id
+		if q.Matches(event) {
+			t.Errorf("Expected %q not to match", id)
+		}
+	}
+
+	q.ContainerID = "4f3c2b1a0e9d"
+	if q.Matches(&models.RuntimeEvent{ClusterID: "kind-local", Timestamp: event.Timestamp}) {
+		t.Errorf("Expected events without a container not to match")
+	}
+}
+
+func TestArchiveQuery_ReceivedAt(t *testing.T) {
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	q := &ArchiveQuery{ClusterID: "kind-local", From: from, To: from.Add(time.Hour)}
+
+	// A node whose clock runs an hour behind still reports within the range
+	received := from.Add(time.Minute)
+	behind := archivedEvent("behind", "worker-1", received.Add(-time.Hour))
+	behind.ReceivedAt = &received
+	if !q.Matches(&behind) {
+		t.Errorf("Expected an event received in the range to match")
+	}
+
+	// One received after the range doesn't, whatever its ts says
+	late := from.Add(2 * time.Hour)
+	ahead := archivedEvent("ahead", "worker-1", from.Add(time.Minute))
+	ahead.ReceivedAt = &late
+	if q.Matches(&ahead) {
+		t.Errorf("Expected an event received after the range not to match")
+	}
+
+	// Events archived without received_at fall back to ts
+	if legacy := archivedEvent("legacy", "worker-1", received); !q.Matches(&legacy) {
+		t.Errorf("Expected an event without received_at to match on ts")
+	}
+}
+
+func TestArchiver_SearchIdentity(t *testing.T) {
+	store, _ := NewLocalStore(t.TempDir())
+	archiver := NewArchiver(store, time.Minute)
+	defer archiver.Close()
+
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	putArchiveObject(t, store, "kind-local", "worker-1", from.Add(time.Minute),
+		archivedEvent("evt-1", "worker-1", from))
+	putArchiveObject(t, store, "kind-local", "worker-2", from.Add(time.Minute),
+		archivedEvent("evt-2", "worker-2", from))
+	// An object under worker-1 holding another node's event is still filtered
+	putArchiveObject(t, store, "kind-local", "worker-1", from.Add(2*time.Minute),
+		archivedEvent("evt-3", "worker-2", from))
+
+	got := searchIDs(t, archiver, &ArchiveQuery{
+		ClusterID: "kind-local",
+		From:      from,
+		To:        from.Add(time.Hour),
+		Allows: func(clusterID, nodeID string) bool {
+			return clusterID == "kind-local" && nodeID == "worker-1"
+		},
+	})
+	if len(got) != 1 || got[0] != "evt-1" {
+		t.Errorf("Expected only worker-1's event, got %v", got)
+	}
+}
+
+func TestSearchArchive_Limit(t *testing.T) {
+	store, _ := NewLocalStore(t.TempDir())
+	prev := archiver
+	archiver = NewArchiver(store, time.Minute)
+	defer func() {
+		archiver.Close()
+		archiver = prev
+	}()
+
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	putArchiveObject(t, store, "kind-local", "worker-1", from.Add(time.Minute),
+		archivedEvent("evt-1", "worker-1", from),
+		archivedEvent("evt-2", "worker-1", from),
+		archivedEvent("evt-3", "worker-1", from))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/v1/archive/events", searchArchive)
+
+	search := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/archive/events?cluster_id=kind-local&from=2024-05-01T12:00:00Z&to=2024-05-01T13:00:00Z"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := search("&limit=2")
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[1], `"evt-2"`) {
+		t.Errorf("Expected the first 2 events, got %q", w.Body.String())
+	}
+
+	for _, limit := range []string{"0", "-1", "100001", "lots"} {
+		if w := search("&limit=" + limit); w.Code != 400 {
+			t.Errorf("Expected limit %s to be rejected, got %d", limit, w.Code)
+		}
+	}
+}
+
+func TestValidateEvent_KeySegments(t *testing.T) {
+	tests := []struct {
+		clusterID, nodeID string
+		valid             bool
+	}{
+		{"kind-local", "ip-10-0-1-5.ec2.internal", true},
+		{"a.b", "c", false},
+		{"kind-local", "node.", false},
+		{"kind-local", "node..1", false},
+		{"kind-local", "..", false},
+		{"kind-local", "worker/1", false},
+	}
+	for _, tt := range tests {
+		err := validateEvent(&models.RuntimeEvent{ClusterID: tt.clusterID, NodeID: tt.nodeID})
+		if (err == nil) != tt.valid {
+			t.Errorf("%s/%s: expected valid=%v, got %v", tt.clusterID, tt.nodeID, tt.valid, err)
+		}
+	}
+}
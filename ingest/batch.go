@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+)
+
+const (
+	// maxBatchLines caps the number of events accepted in a single batch request
+	maxBatchLines = 10000
+	// maxLineBytes caps the size of a single NDJSON line
+	maxLineBytes = 1 << 20
+	// maxBatchBytes caps a batch body, both as sent and once decompressed
+	maxBatchBytes = 64 << 20
+)
+
+// errBatchTooLarge stops a batch with more events or bytes than ingest
+// accepts in one request
+var errBatchTooLarge = errors.New("batch too large")
+
+// LineResult reports the outcome for a single line of a batch request
+type LineResult struct {
+	Line    int    `json:"line"`
+	EventID string `json:"event_id,omitempty"`
+	Status  string `json:"status"` // accepted, duplicate, rejected
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchResult is the response body for POST /v1/events:batch. When the event
+// stream is full, processing stops at the first line that could not be
+// published and Unavailable is set; likewise Throttled is set at the first
+// line over its rate limit. When the body can't be read to the end or has
+// more than maxBatchLines events, processing stops there, Truncated is set
+// and Error says why. In each case lines after the last result were not
+// processed and should be resent.
+type BatchResult struct {
+	Accepted    int          `json:"accepted"`
+	Duplicates  int          `json:"duplicates"`
+	Rejected    int          `json:"rejected"`
+	Unavailable bool         `json:"unavailable,omitempty"`
+	Throttled   bool         `json:"throttled,omitempty"`
+	Truncated   bool         `json:"truncated,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Results     []LineResult `json:"results"`
+
+	retryAfter string
+}
+
+// handleEventBatch accepts newline-delimited JSON events, optionally gzip
+// encoded. Each line is validated and published on its own so that a few bad
+// lines don't cause the whole batch to be dropped.
+func handleEventBatch(c *gin.Context) {
+	var body io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchBytes)
+	if strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid gzip body"})
+			return
+		}
+		defer gz.Close()
+		body = &limitedReader{r: gz, n: maxBatchBytes}
+	}
+
+	id := requestIdentity(c)
+	check := func(event *models.RuntimeEvent) error {
+		if err := validateEvent(event); err != nil {
+			return err
+		}
+		if err := authorizeEvent(id, event, c.ClientIP()); err != nil {
+			return err
+		}
+		return admitEvent(event)
+	}
+
+	result, err := processBatch(body, check, acceptEvent, batchRejecter(c))
+	log.Printf("Batch received: %d accepted, %d duplicates, %d rejected", result.Accepted, result.Duplicates, result.Rejected)
+	if result.Unavailable {
+		c.Header("Retry-After", retryAfterSeconds)
+		c.JSON(503, result)
+		return
+	}
+	if result.Throttled {
+		rateLimitStats.Add("throttled_requests", 1)
+		c.Header("Retry-After", result.retryAfter)
+		c.JSON(429, result)
+		return
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.Is(err, errBatchTooLarge) || errors.As(err, &maxBytesErr) {
+			c.JSON(413, result)
+			return
+		}
+		c.JSON(400, result)
+		return
+	}
+	c.JSON(200, result)
+}
+
+// processBatch reads NDJSON events from r and hands each one that passes check
+// to publish. Lines that are malformed or fail check for a reason other than
+// rate limiting are passed to reject, if set. Blank lines are skipped but
+// still counted so line numbers match the input. If r fails or has more than
+// maxBatchLines events, the result so far is returned, marked Truncated,
+// along with the error.
+func processBatch(r io.Reader, check func(*models.RuntimeEvent) error, publish func(*models.RuntimeEvent, []byte) error, reject func([]byte, *models.RuntimeEvent, error)) (*BatchResult, error) {
+	br := bufio.NewReaderSize(r, maxLineBytes)
+
+	result := &BatchResult{Results: []LineResult{}}
+	lineNo := 0
+	for !result.Unavailable && !result.Throttled {
+		raw, tooLong, err := readLine(br)
+		if err != nil && err != io.EOF {
+			// A line cut short by the error is not processed
+			return result, result.truncate(fmt.Errorf("failed to read body after line %d: %w", lineNo, err))
+		}
+		if len(raw) == 0 && !tooLong {
+			break
+		}
+		lineNo++
+		line := bytes.TrimSpace(raw)
+		if len(line) == 0 && !tooLong {
+			if err == io.EOF {
+				break
+			}
+			continue
+		}
+		if len(result.Results) >= maxBatchLines {
+			return result, result.truncate(fmt.Errorf("%w: more than %d events, stopped before line %d", errBatchTooLarge, maxBatchLines, lineNo))
+		}
+		// The reader reuses its buffer, so keep our own copy for publishing
+		line = append([]byte(nil), line...)
+
+		res := LineResult{Line: lineNo}
+		var event models.RuntimeEvent
+		if tooLong {
+			res.Status = "rejected"
+			res.Error = fmt.Sprintf("line exceeds %d bytes", maxLineBytes)
+		} else if err := json.Unmarshal(line, &event); err != nil {
+			res.Status = "rejected"
+			res.Error = "invalid json"
+			if reject != nil {
+				reject(line, nil, err)
+			}
+		} else if err := check(&event); err != nil {
+			res.EventID = event.EventID
+			res.Status = "rejected"
+			res.Error = err.Error()
+			if rl := asRateLimited(err); rl != nil {
+				if !rl.shed {
+					result.Throttled = true
+					result.retryAfter = rl.RetryAfter()
+				}
+			} else if reject != nil {
+				reject(line, &event, err)
+			}
+		} else if err := publish(&event, line); errors.Is(err, errDuplicate) {
+			res.EventID = event.EventID
+			res.Status = "duplicate"
+		} else if err != nil {
+			log.Printf("Error publishing to NATS: %v", err)
+			res.EventID = event.EventID
+			res.Status = "rejected"
+			res.Error = "internal error"
+			if pipeline.IsUnavailable(err) {
+				res.Error = "event stream unavailable"
+				result.Unavailable = true
+			}
+		} else {
+			res.EventID = event.EventID
+			res.Status = "accepted"
+		}
+
+		switch res.Status {
+		case "accepted":
+			result.Accepted++
+		case "duplicate":
+			result.Duplicates++
+		default:
+			result.Rejected++
+		}
+		result.Results = append(result.Results, res)
+		if err == io.EOF {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (r *BatchResult) truncate(err error) error {
+	r.Truncated = true
+	r.Error = err.Error()
+	return err
+}
+
+// readLine reads the next line, including its newline. Lines longer than
+// the reader's buffer are skipped and reported as tooLong. At the end of
+// the input it returns the last line, if any, with io.EOF.
+func readLine(br *bufio.Reader) (line []byte, tooLong bool, err error) {
+	line, err = br.ReadSlice('\n')
+	for err == bufio.ErrBufferFull {
+		tooLong = true
+		_, err = br.ReadSlice('\n')
+	}
+	return line, tooLong, err
+}
+
+// limitedReader fails once more than n bytes have been read, where
+// io.LimitReader would quietly end the input, possibly mid-line
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, fmt.Errorf("%w: body exceeds %d bytes", errBatchTooLarge, maxBatchBytes)
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		// Hand over the bytes within the limit, so lines completed before
+		// it are still processed
+		return n + int(l.n), fmt.Errorf("%w: body exceeds %d bytes", errBatchTooLarge, maxBatchBytes)
+	}
+	return n, err
+}
+
+// readBody reads a request body of at most limit bytes. It responds with 413
+// for larger bodies, or 400 if the body can't be read, and returns false.
+func readBody(c *gin.Context, limit int64) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(413, gin.H{"error": fmt.Sprintf("body exceeds %d bytes", limit)})
+			return nil, false
+		}
+		c.JSON(400, gin.H{"error": "failed to read body"})
+		return nil, false
+	}
+	return data, true
+}
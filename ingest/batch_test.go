@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+func TestProcessBatch_PartialReject(t *testing.T) {
+	body := strings.Join([]string{
+		`{"cluster_id":"kind-local","node_id":"kind-worker","event_type":"process_exec","event_id":"evt-1"}`,
+		``,
+		`{not json`,
+		`{"cluster_id":"kind-local","event_type":"process_exec","event_id":"evt-2"}`,
+		`{"cluster_id":"kind-local","node_id":"kind-worker","event_type":"file_open","event_id":"evt-3"}`,
+	}, "\n")
+
+	var published []string
+	publish := func(event *models.RuntimeEvent, data []byte) error {
+		published = append(published, event.EventID)
+		return nil
+	}
+
+	var deadLettered []string
+	reject := func(line []byte, event *models.RuntimeEvent, err error) {
+		deadLettered = append(deadLettered, string(line))
+	}
+
+	result, err := processBatch(strings.NewReader(body), validateEvent, publish, reject)
+	if err != nil {
+		t.Fatalf("processBatch failed: %v", err)
+	}
+
+	if result.Accepted != 2 || result.Rejected != 2 {
+		t.Fatalf("Expected 2 accepted and 2 rejected, got %d and %d", result.Accepted, result.Rejected)
+	}
+	if len(published) != 2 || published[0] != "evt-1" || published[1] != "evt-3" {
+		t.Errorf("Unexpected published events: %v", published)
+	}
+
+	// Line numbers must match the input, including the blank line
+	wantLines := []int{1, 3, 4, 5}
+	for i, res := range result.Results {
+		if res.Line != wantLines[i] {
+			t.Errorf("Result %d: expected line %d, got %d", i, wantLines[i], res.Line)
+		}
+	}
+	if result.Results[2].Error != "missing cluster_id or node_id" {
+		t.Errorf("Unexpected error for line 4: %q", result.Results[2].Error)
+	}
+	if len(deadLettered) != 2 || deadLettered[0] != `{not json` {
+		t.Errorf("Expected the malformed and invalid lines to be dead-lettered, got %v", deadLettered)
+	}
+}
+
+func TestProcessBatch_PublishFailure(t *testing.T) {
+	body := `{"cluster_id":"c","node_id":"n","event_id":"evt-1"}`
+	publish := func(event *models.RuntimeEvent, data []byte) error {
+		return errors.New("nats down")
+	}
+
+	result, err := processBatch(strings.NewReader(body), validateEvent, publish, nil)
+	if err != nil {
+		t.Fatalf("processBatch failed: %v", err)
+	}
+	if result.Rejected != 1 || result.Results[0].Error != "internal error" {
+		t.Errorf("Expected publish failure to reject the line, got %+v", result.Results)
+	}
+}
+
+func TestProcessBatch_StopsWhenStreamFull(t *testing.T) {
+	body := strings.Join([]string{
+		`{"cluster_id":"c","node_id":"n","event_id":"evt-1"}`,
+		`{"cluster_id":"c","node_id":"n","event_id":"evt-2"}`,
+		`{"cluster_id":"c","node_id":"n","event_id":"evt-3"}`,
+	}, "\n")
+
+	calls := 0
+	publish := func(event *models.RuntimeEvent, data []byte) error {
+		calls++
+		if event.EventID == "evt-2" {
+			return jetstream.ErrNoStreamResponse
+		}
+		return nil
+	}
+
+	result, err := processBatch(strings.NewReader(body), validateEvent, publish, nil)
+	if err != nil {
+		t.Fatalf("processBatch failed: %v", err)
+	}
+	if !result.Unavailable {
+		t.Fatal("Expected batch to be marked unavailable")
+	}
+	if calls != 2 || len(result.Results) != 2 {
+		t.Errorf("Expected processing to stop at line 2, got %d calls and %d results", calls, len(result.Results))
+	}
+}
+
+func TestProcessBatch_TooManyLines(t *testing.T) {
+	var body strings.Builder
+	for i := 0; i <= maxBatchLines; i++ {
+		body.WriteString(`{"cluster_id":"c","node_id":"n"}` + "\n")
+	}
+	published := 0
+	publish := func(event *models.RuntimeEvent, data []byte) error {
+		published++
+		return nil
+	}
+
+	result, err := processBatch(strings.NewReader(body.String()), validateEvent, publish, nil)
+	if !errors.Is(err, errBatchTooLarge) || !result.Truncated {
+		t.Fatalf("Expected the batch to be truncated, got %v", err)
+	}
+	// The lines already published are reported, so only the rest is resent
+	if published != maxBatchLines || result.Accepted != maxBatchLines || len(result.Results) != maxBatchLines {
+		t.Errorf("Expected %d lines reported as accepted, got %d published and %d results", maxBatchLines, published, len(result.Results))
+	}
+}
+
+func TestProcessBatch_ReadLimits(t *testing.T) {
+	long := `{"cluster_id":"c","node_id":"n","event_id":"` + strings.Repeat("x", maxLineBytes) + `"}`
+	body := strings.Join([]string{
+		`{"cluster_id":"c","node_id":"n","event_id":"evt-1"}`,
+		long,
+		`{"cluster_id":"c","node_id":"n","event_id":"evt-3"}`,
+		`{"cluster_id":"c","node_id":"n","event_id":"evt-4"}`,
+	}, "\n")
+	publish := func(event *models.RuntimeEvent, data []byte) error { return nil }
+
+	// An overlong line is rejected on its own
+	result, err := processBatch(strings.NewReader(body), validateEvent, publish, nil)
+	if err != nil {
+		t.Fatalf("processBatch failed: %v", err)
+	}
+	if result.Accepted != 3 || result.Rejected != 1 || result.Results[1].Line != 2 {
+		t.Errorf("Expected line 2 alone to be rejected, got %+v", result.Results)
+	}
+
+	// A body over the limit stops at the last complete line before it
+	limit := int64(strings.Index(body, long) + 10)
+	result, err = processBatch(&limitedReader{r: strings.NewReader(body), n: limit}, validateEvent, publish, nil)
+	if !errors.Is(err, errBatchTooLarge) || !result.Truncated || result.Error == "" {
+		t.Fatalf("Expected the batch to be truncated, got %v", err)
+	}
+	if len(result.Results) != 1 || result.Results[0].EventID != "evt-1" {
+		t.Errorf("Expected only line 1 to be processed, got %+v", result.Results)
+	}
+}
+
+func TestHandleEventBatch_Gzip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/events", handleEvent)
+	r.POST("/v1/:method", handleCustomMethod)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("{not json\n"))
+	gz.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/events:batch", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"rejected":1`) {
+		t.Errorf("Expected one rejected line, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// clockSkewStats holds each node's estimated clock skew in seconds, keyed by
+// cluster/node, plus skewed_events and corrected_events counters
+var clockSkewStats = expvar.NewMap("ingest_clock_skew")
+
+// skewSamples is how many recent events a node's skew is estimated from
+const skewSamples = 32
+
+// skewIdleTTL is how long a node can go without timestamped events before
+// Sweep drops its estimate
+const skewIdleTTL = time.Hour
+
+// ClockSkewTracker estimates each node's clock offset from the difference
+// between event timestamps and receive times. The estimate is the median of
+// recent samples, so events a sensor buffered or retried don't move it much.
+// Estimates are per ingest replica.
+type ClockSkewTracker struct {
+	tolerance time.Duration
+	correct   bool
+	mu        sync.Mutex
+	nodes     map[string]*nodeSkew
+}
+
+type nodeSkew struct {
+	samples [skewSamples]time.Duration
+	n       int // samples recorded, up to skewSamples
+	next    int
+	skew    time.Duration
+	last    time.Time // receive time of the latest sample
+}
+
+// NewClockSkewTracker returns a tracker that flags events from nodes skewed
+// by more than tolerance, and with correct set, also shifts their timestamps
+// by the node's skew
+func NewClockSkewTracker(tolerance time.Duration, correct bool) *ClockSkewTracker {
+	return &ClockSkewTracker{
+		tolerance: tolerance,
+		correct:   correct,
+		nodes:     make(map[string]*nodeSkew),
+	}
+}
+
+// Normalize records the event's receive time and skew sample. Events without
+// a timestamp get the receive time. Events from a node whose skew is beyond
+// the tolerance get ClockSkewMs, and in correct mode a corrected Timestamp
+// with the original kept in SensorTimestamp.
+func (t *ClockSkewTracker) Normalize(event *models.RuntimeEvent, receivedAt time.Time) {
+	event.ReceivedAt = &receivedAt
+	if event.Timestamp.IsZero() {
+		event.Timestamp = receivedAt
+		return
+	}
+
+	skew := t.record(event.ClusterID+"/"+event.NodeID, event.Timestamp.Sub(receivedAt), receivedAt)
+	if skew <= t.tolerance && skew >= -t.tolerance {
+		return
+	}
+	clockSkewStats.Add("skewed_events", 1)
+	event.ClockSkewMs = skew.Milliseconds()
+	if t.correct {
+		original := event.Timestamp
+		event.SensorTimestamp = &original
+		event.Timestamp = event.Timestamp.Add(-skew)
+		clockSkewStats.Add("corrected_events", 1)
+	}
+}
+
+// record adds a sample for node received at receivedAt and returns its
+// updated skew estimate
+func (t *ClockSkewTracker) record(node string, sample time.Duration, receivedAt time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ns := t.nodes[node]
+	if ns == nil {
+		ns = &nodeSkew{}
+		t.nodes[node] = ns
+	}
+	ns.samples[ns.next] = sample
+	ns.last = receivedAt
+	ns.next = (ns.next + 1) % skewSamples
+	if ns.n < skewSamples {
+		ns.n++
+	}
+
+	sorted := make([]time.Duration, ns.n)
+	copy(sorted, ns.samples[:ns.n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	ns.skew = sorted[ns.n/2]
+
+	stat := new(expvar.Float)
+	stat.Set(ns.skew.Seconds())
+	clockSkewStats.Set(node, stat)
+	return ns.skew
+}
+
+// Skew returns the current estimate for a node, and false if no timestamped
+// event has been seen from it
+func (t *ClockSkewTracker) Skew(clusterID, nodeID string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ns := t.nodes[clusterID+"/"+nodeID]; ns != nil {
+		return ns.skew, true
+	}
+	return 0, false
+}
+
+// Sweep drops the estimates of nodes without timestamped events for
+// skewIdleTTL, along with their ingest_clock_skew entries
+func (t *ClockSkewTracker) Sweep() {
+	t.sweep(time.Now())
+}
+
+func (t *ClockSkewTracker) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for node, ns := range t.nodes {
+		if now.Sub(ns.last) > skewIdleTTL {
+			delete(t.nodes, node)
+			clockSkewStats.Delete(node)
+		}
+	}
+}
+
+// stampEvent sets the receive time and normalizes the timestamp, if clock
+// skew tracking is configured
+func stampEvent(event *models.RuntimeEvent) {
+	now := time.Now().UTC()
+	if clockSkew == nil {
+		event.ReceivedAt = &now
+		return
+	}
+	clockSkew.Normalize(event, now)
+}
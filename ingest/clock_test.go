@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+func TestClockSkewTracker_FlagsSkewedNode(t *testing.T) {
+	tracker := NewClockSkewTracker(30*time.Second, false)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	event := &models.RuntimeEvent{ClusterID: "c", NodeID: "n1", Timestamp: now.Add(2 * time.Second)}
+	tracker.Normalize(event, now)
+	if event.ClockSkewMs != 0 || event.ReceivedAt == nil || !event.ReceivedAt.Equal(now) {
+		t.Errorf("Expected an in-tolerance event to only get a receive time, got %+v", event)
+	}
+
+	// The estimate is the median, so one late event doesn't flag the node
+	var last *models.RuntimeEvent
+	for i := 0; i < 3; i++ {
+		last = &models.RuntimeEvent{ClusterID: "c", NodeID: "n2", Timestamp: now.Add(-5 * time.Minute)}
+		tracker.Normalize(last, now)
+	}
+	if last.ClockSkewMs != (-5 * time.Minute).Milliseconds() {
+		t.Errorf("Expected skew of -300000ms, got %d", last.ClockSkewMs)
+	}
+	if !last.Timestamp.Equal(now.Add(-5*time.Minute)) || last.SensorTimestamp != nil {
+		t.Errorf("Expected flag mode to keep the sensor timestamp, got %v", last.Timestamp)
+	}
+	if skew, ok := tracker.Skew("c", "n2"); !ok || skew != -5*time.Minute {
+		t.Errorf("Expected skew of -5m for n2, got %v", skew)
+	}
+	if _, ok := tracker.Skew("c", "n3"); ok {
+		t.Errorf("Expected no estimate for an unseen node")
+	}
+}
+
+func TestClockSkewTracker_CorrectsTimestamps(t *testing.T) {
+	tracker := NewClockSkewTracker(30*time.Second, true)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	sent := now.Add(time.Hour)
+
+	event := &models.RuntimeEvent{ClusterID: "c", NodeID: "n1", Timestamp: sent}
+	tracker.Normalize(event, now)
+	if !event.Timestamp.Equal(now) {
+		t.Errorf("Expected timestamp corrected to %v, got %v", now, event.Timestamp)
+	}
+	if event.SensorTimestamp == nil || !event.SensorTimestamp.Equal(sent) {
+		t.Errorf("Expected the original timestamp to be kept, got %v", event.SensorTimestamp)
+	}
+	if event.ClockSkewMs != time.Hour.Milliseconds() {
+		t.Errorf("Expected skew of 3600000ms, got %d", event.ClockSkewMs)
+	}
+
+	missing := &models.RuntimeEvent{ClusterID: "c", NodeID: "n1"}
+	tracker.Normalize(missing, now)
+	if !missing.Timestamp.Equal(now) || missing.ClockSkewMs != 0 {
+		t.Errorf("Expected an event without a timestamp to get the receive time, got %+v", missing)
+	}
+}
+
+func TestClockSkewTracker_SweepsIdleNodes(t *testing.T) {
+	tracker := NewClockSkewTracker(30*time.Second, false)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker.Normalize(&models.RuntimeEvent{ClusterID: "sweep", NodeID: "n1", Timestamp: now}, now)
+	later := now.Add(skewIdleTTL)
+	tracker.Normalize(&models.RuntimeEvent{ClusterID: "sweep", NodeID: "n2", Timestamp: later}, later)
+
+	tracker.sweep(later.Add(time.Minute))
+	if _, ok := tracker.Skew("sweep", "n1"); ok || clockSkewStats.Get("sweep/n1") != nil {
+		t.Errorf("Expected the idle node's estimate and stat to be dropped")
+	}
+	if _, ok := tracker.Skew("sweep", "n2"); !ok || clockSkewStats.Get("sweep/n2") == nil {
+		t.Errorf("Expected the active node's estimate and stat to be kept")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+)
+
+// deadLetter records a payload ingest rejected as malformed or invalid on
+// deadletter.ingest, along with who sent it. event is the decoded event, if
+// decoding got that far.
+func deadLetter(c *gin.Context, payload []byte, event *models.RuntimeEvent, cause error) {
+	source := &models.DeadLetterSource{RemoteAddr: c.ClientIP()}
+	if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+		source.CertSubject = c.Request.TLS.PeerCertificates[0].Subject.String()
+	}
+	deadLetterFrom(source, requestIdentity(c), payload, event, cause)
+}
+
+// deadLetterFrom is deadLetter for callers outside an HTTP request. id is the
+// caller's identity, or nil when identity binding is disabled.
+func deadLetterFrom(source *models.DeadLetterSource, id *SensorIdentity, payload []byte, event *models.RuntimeEvent, cause error) {
+	if event != nil {
+		source.ClusterID = event.ClusterID
+		source.NodeID = event.NodeID
+	}
+	redrive := redriveSubject(id, event)
+	pipeline.DeadLetterPayload(js, "ingest", redrive, redactDeadLetter(payload, event), cause, source)
+}
+
+// redriveSubject returns where a rejected event can be re-driven to, or ""
+// if it can't be. Only decoded events id may send are re-drivable, since a
+// re-drive isn't authorized again: the client certificate is gone by then.
+func redriveSubject(id *SensorIdentity, event *models.RuntimeEvent) string {
+	if event == nil || (id != nil && !id.Allows(event.ClusterID, event.NodeID)) {
+		return ""
+	}
+	return pipeline.RedriveSubject(pipeline.ConsumerIngest)
+}
+
+// redactDeadLetter masks secrets in a rejected payload just as in accepted
+// events, so dead letters don't keep what the pipeline never sees. A decoded
+// event is redacted and stored as RuntimeEvent JSON in place of the payload
+// it came from, so it can be re-driven whatever format it arrived in;
+// anything else is redacted as text. event is modified.
+func redactDeadLetter(payload []byte, event *models.RuntimeEvent) []byte {
+	if event != nil {
+		if redactor != nil {
+			redactor.Redact(event)
+		}
+		if data, err := json.Marshal(event); err == nil {
+			return data
+		}
+	}
+	if redactor == nil {
+		return payload
+	}
+	return redactor.RedactPayload(payload)
+}
+
+// batchRejecter returns the reject callback for batch processing, which
+// dead-letters lines that are malformed or fail validation
+func batchRejecter(c *gin.Context) func([]byte, *models.RuntimeEvent, error) {
+	return func(payload []byte, event *models.RuntimeEvent, cause error) {
+		deadLetter(c, payload, event, cause)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// errDuplicate is returned by acceptEvent for an event already accepted
+// within the dedup window
+var errDuplicate = errors.New("duplicate event")
+
+// dedupStats counts dedup lookups. duplicate_rate is duplicates/checked.
+var dedupStats = expvar.NewMap("ingest_dedup")
+
+func init() {
+	dedupStats.Set("duplicate_rate", expvar.Func(func() interface{} {
+		checked, _ := dedupStats.Get("checked").(*expvar.Int)
+		dups, _ := dedupStats.Get("duplicates").(*expvar.Int)
+		if checked == nil || dups == nil || checked.Value() == 0 {
+			return 0.0
+		}
+		return float64(dups.Value()) / float64(checked.Value())
+	}))
+}
+
+// DedupStore remembers event keys for a time window. Claim records key and
+// reports whether it was new; Release forgets a claimed key so the event can
+// be accepted again, e.g. after a failed publish.
+type DedupStore interface {
+	Claim(key string) (bool, error)
+	Release(key string) error
+}
+
+// MemoryDedupStore is an LRU of event keys for a single ingest replica. Once
+// full, the least recently claimed keys are forgotten even if still in the
+// window.
+type MemoryDedupStore struct {
+	window time.Duration
+	size   int
+	mu     sync.Mutex
+	order  *list.List
+	keys   map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+func NewMemoryDedupStore(window time.Duration, size int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		window: window,
+		size:   size,
+		order:  list.New(),
+		keys:   make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryDedupStore) Claim(key string) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.keys[key]; ok {
+		entry := elem.Value.(*dedupEntry)
+		if now.Before(entry.expires) {
+			return false, nil
+		}
+		entry.expires = now.Add(s.window)
+		s.order.MoveToFront(elem)
+		return true, nil
+	}
+
+	s.keys[key] = s.order.PushFront(&dedupEntry{key: key, expires: now.Add(s.window)})
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.keys, oldest.Value.(*dedupEntry).key)
+	}
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.keys[key]; ok {
+		s.order.Remove(elem)
+		delete(s.keys, key)
+	}
+	return nil
+}
+
+// RedisDedupStore keeps event keys in Redis so that every ingest replica
+// shares one dedup window
+type RedisDedupStore struct {
+	rdb    *redis.Client
+	window time.Duration
+}
+
+// redisTimeout bounds each dedup round trip so a slow Redis doesn't stall ingest
+const redisTimeout = time.Second
+
+func NewRedisDedupStore(addr string, window time.Duration) *RedisDedupStore {
+	return &RedisDedupStore{
+		rdb:    redis.NewClient(&redis.Options{Addr: addr}),
+		window: window,
+	}
+}
+
+func (s *RedisDedupStore) Claim(key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.rdb.SetNX(ctx, "dedup:"+key, 1, s.window).Result()
+}
+
+func (s *RedisDedupStore) Release(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.rdb.Del(ctx, "dedup:"+key).Err()
+}
+
+// newDedupStore builds the store selected by DEDUP_BACKEND. It returns a nil
+// store when dedup is disabled.
+func newDedupStore() (DedupStore, error) {
+	window := 10 * time.Minute
+	if v := os.Getenv("DEDUP_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid DEDUP_WINDOW %q", v)
+		}
+		window = d
+	}
+
+	switch backend := os.Getenv("DEDUP_BACKEND"); backend {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		size := 100000
+		if v := os.Getenv("DEDUP_CACHE_SIZE"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid DEDUP_CACHE_SIZE %q", v)
+			}
+			size = n
+		}
+		return NewMemoryDedupStore(window, size), nil
+	case "redis":
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("REDIS_ADDR is required for the redis dedup backend")
+		}
+		return NewRedisDedupStore(addr, window), nil
+	default:
+		return nil, fmt.Errorf("unknown dedup backend %q", backend)
+	}
+}
+
+// dedupKey identifies an event for dedup, or returns "" for events without an
+// event_id, which are never treated as duplicates
+func dedupKey(event *models.RuntimeEvent) string {
+	if event.EventID == "" {
+		return ""
+	}
+	return event.ClusterID + "/" + event.NodeID + "/" + event.EventID
+}
+
+// claimEvent records event in the dedup store. It returns errDuplicate for an
+// event seen within the window. Store errors are logged and the event is let
+// through, since dropping events is worse than a duplicate alert.
+func claimEvent(event *models.RuntimeEvent) (key string, err error) {
+	key = dedupKey(event)
+	if deduper == nil || key == "" {
+		return "", nil
+	}
+
+	dedupStats.Add("checked", 1)
+	claimed, err := deduper.Claim(key)
+	if err != nil {
+		dedupStats.Add("errors", 1)
+		log.Printf("Error checking dedup store for event %s: %v", event.EventID, err)
+		return "", nil
+	}
+	if !claimed {
+		dedupStats.Add("duplicates", 1)
+		return "", errDuplicate
+	}
+	return key, nil
+}
+
+// releaseEvent undoes claimEvent for an event that could not be published
+func releaseEvent(key string) {
+	if key == "" {
+		return
+	}
+	if err := deduper.Release(key); err != nil {
+		log.Printf("Error releasing dedup key %s: %v", key, err)
+	}
+}
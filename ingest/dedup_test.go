@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+func TestMemoryDedupStore_Window(t *testing.T) {
+	s := NewMemoryDedupStore(50*time.Millisecond, 10)
+
+	if ok, _ := s.Claim("c/n/evt-1"); !ok {
+		t.Fatal("Expected first claim to succeed")
+	}
+	if ok, _ := s.Claim("c/n/evt-1"); ok {
+		t.Fatal("Expected second claim within the window to be a duplicate")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if ok, _ := s.Claim("c/n/evt-1"); !ok {
+		t.Error("Expected claim after the window to succeed")
+	}
+}
+
+func TestMemoryDedupStore_EvictsOldest(t *testing.T) {
+	s := NewMemoryDedupStore(time.Hour, 2)
+	s.Claim("a")
+	s.Claim("b")
+	s.Claim("c")
+
+	if ok, _ := s.Claim("a"); !ok {
+		t.Error("Expected the oldest key to have been evicted")
+	}
+	if ok, _ := s.Claim("c"); ok {
+		t.Error("Expected the newest key to still be remembered")
+	}
+}
+
+func TestMemoryDedupStore_Release(t *testing.T) {
+	s := NewMemoryDedupStore(time.Hour, 10)
+	s.Claim("a")
+	s.Release("a")
+	if ok, _ := s.Claim("a"); !ok {
+		t.Error("Expected a released key to be claimable again")
+	}
+}
+
+func TestProcessBatch_Duplicates(t *testing.T) {
+	deduper = NewMemoryDedupStore(time.Hour, 100)
+	defer func() { deduper = nil }()
+
+	body := strings.Join([]string{
+		`{"cluster_id":"c","node_id":"n1","event_id":"evt-1"}`,
+		`{"cluster_id":"c","node_id":"n1","event_id":"evt-1"}`,
+		`{"cluster_id":"c","node_id":"n2","event_id":"evt-1"}`,
+		`{"cluster_id":"c","node_id":"n1","event_id":"evt-2"}`,
+		`{"cluster_id":"c","node_id":"n1","event_id":"evt-2"}`,
+	}, "\n")
+
+	// Mirror acceptEvent: the first evt-2 fails to publish, so its retry
+	// on the next line must not count as a duplicate
+	failed := false
+	publish := func(event *models.RuntimeEvent, data []byte) error {
+		key, err := claimEvent(event)
+		if err != nil {
+			return err
+		}
+		if event.EventID == "evt-2" && !failed {
+			failed = true
+			releaseEvent(key)
+			return errors.New("nats down")
+		}
+		return nil
+	}
+
+	result, err := processBatch(strings.NewReader(body), validateEvent, publish, nil)
+	if err != nil {
+		t.Fatalf("processBatch failed: %v", err)
+	}
+	if result.Accepted != 3 || result.Duplicates != 1 || result.Rejected != 1 {
+		t.Fatalf("Expected 3 accepted, 1 duplicate and 1 rejected, got %+v", result)
+	}
+	if result.Results[1].Status != "duplicate" {
+		t.Errorf("Expected line 2 to be a duplicate, got %q", result.Results[1].Status)
+	}
+}
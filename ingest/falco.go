@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// FalcoAlert is the JSON Falco sends with json_output and http_output, and
+// the format falcosidekick forwards
+type FalcoAlert struct {
+	UUID         string                 `json:"uuid"`
+	Time         time.Time              `json:"time"`
+	Rule         string                 `json:"rule"`
+	Priority     string                 `json:"priority"`
+	Source       string                 `json:"source"`
+	Hostname     string                 `json:"hostname"`
+	Output       string                 `json:"output"`
+	Tags         []string               `json:"tags"`
+	OutputFields map[string]interface{} `json:"output_fields"`
+}
+
+// defaultContainerCaps is the capability set container runtimes grant by
+// default. Anything beyond it in a process's effective set counts as added.
+var defaultContainerCaps = map[string]bool{
+	"CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true, "FSETID": true,
+	"KILL": true, "SETGID": true, "SETUID": true, "SETPCAP": true,
+	"NET_BIND_SERVICE": true, "NET_RAW": true, "SYS_CHROOT": true,
+	"MKNOD": true, "AUDIT_WRITE": true, "SETFCAP": true,
+}
+
+// falcoMetricsRule is the rule name of the metrics snapshots Falco emits
+// when metrics.output_rule is enabled; they double as sensor heartbeats
+const falcoMetricsRule = "Falco internal: metrics snapshot"
+
+// falcoEventTypes maps Falco syscall names to PodWatch event types
+var falcoEventTypes = map[string]string{
+	"execve":    "process_exec",
+	"execveat":  "process_exec",
+	"connect":   "network_connect",
+	"open":      "file_open",
+	"openat":    "file_open",
+	"openat2":   "file_open",
+	"capset":    "capability_change",
+	"setuid":    "capability_change",
+	"setresuid": "capability_change",
+}
+
+// normalizeFalco decodes a single Falco alert, as sent by Falco's
+// http_output or falcosidekick's webhook output
+func normalizeFalco(raw []byte) (*models.RuntimeEvent, error) {
+	var alert FalcoAlert
+	if err := json.Unmarshal(raw, &alert); err != nil {
+		return nil, err
+	}
+	return falcoToEvent(&alert, raw), nil
+}
+
+// falcoToEvent normalizes a Falco alert. raw is the original payload; when the
+// alert has no uuid, the event ID is derived from it so retried deliveries of
+// the same alert keep the same ID.
+func falcoToEvent(alert *FalcoAlert, raw []byte) *models.RuntimeEvent {
+	f := falcoFields(alert.OutputFields)
+
+	event := &models.RuntimeEvent{
+		Timestamp: alert.Time,
+		NodeID:    alert.Hostname,
+		EventID:   alert.UUID,
+		Metadata: map[string]string{
+			"source":         "falco",
+			"falco.rule":     alert.Rule,
+			"falco.priority": alert.Priority,
+		},
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	if event.NodeID == "" {
+		event.NodeID = f.str("evt.hostname")
+	}
+	if event.EventID == "" {
+		event.EventID = uuid.NewSHA1(uuid.NameSpaceOID, raw).String()
+	}
+	if len(alert.Tags) > 0 {
+		event.Metadata["falco.tags"] = strings.Join(alert.Tags, ",")
+	}
+	if alert.Rule == falcoMetricsRule {
+		event.EventType = heartbeatEventType
+		event.Metadata["sensor.version"] = f.str("falco.version")
+		return event
+	}
+
+	evtType := f.str("evt.type")
+	event.EventType = falcoEventTypes[evtType]
+	if event.EventType == "" {
+		event.EventType = evtType
+	}
+	if event.EventType == "" {
+		event.EventType = "falco_alert"
+	}
+
+	if f.has("proc.pid", "proc.exepath", "proc.exe", "proc.cmdline") {
+		exe := f.str("proc.exepath")
+		if exe == "" {
+			exe = f.str("proc.exe")
+		}
+		event.Process = &models.ProcessInfo{
+			PID:               f.int("proc.pid"),
+			PPID:              f.int("proc.ppid"),
+			UID:               f.int("user.uid"),
+			GID:               f.int("group.gid"),
+			Exe:               exe,
+			Cmdline:           f.str("proc.cmdline"),
+			Cwd:               f.str("proc.cwd"),
+			HasTTY:            f.int("proc.tty") != 0,
+			CapabilitiesAdded: addedCapabilities(f.str("thread.cap_effective")),
+		}
+	}
+
+	if id := f.str("container.id"); id != "" && id != "host" {
+		image := f.str("container.image.repository")
+		if tag := f.str("container.image.tag"); image != "" && tag != "" {
+			image += ":" + tag
+		}
+		if image == "" {
+			image = f.str("container.image")
+		}
+		event.Container = &models.ContainerInfo{
+			ContainerID: id,
+			Image:       image,
+			ImageDigest: f.str("container.image.digest"),
+			Pod:         f.str("k8s.pod.name"),
+			Namespace:   f.str("k8s.ns.name"),
+		}
+	}
+
+	// For opens, fd.name is the file path; the process's argv may not
+	// mention it at all
+	if path := f.str("fd.name"); path != "" && event.EventType == "file_open" {
+		event.Metadata["file.path"] = path
+	}
+
+	if dst := f.str("fd.sip"); dst != "" {
+		event.Network = &models.NetworkInfo{
+			DstIP:     dst,
+			DstPort:   f.int("fd.sport"),
+			Proto:     f.str("fd.l4proto"),
+			DstDomain: f.str("fd.sip.name"),
+		}
+	}
+
+	return event
+}
+
+// addedCapabilities parses a Falco capability list ("CAP_SYS_ADMIN CAP_KILL")
+// and returns the ones outside the default container set, without the CAP_
+// prefix
+func addedCapabilities(caps string) []string {
+	var added []string
+	for _, cap := range strings.Fields(caps) {
+		cap = strings.TrimPrefix(strings.ToUpper(cap), "CAP_")
+		if !defaultContainerCaps[cap] {
+			added = append(added, cap)
+		}
+	}
+	return added
+}
+
+// falcoFields wraps Falco output_fields. Falco reports unavailable values as
+// "<NA>", which are treated as missing.
+type falcoFields map[string]interface{}
+
+func (f falcoFields) str(key string) string {
+	switch v := f[key].(type) {
+	case string:
+		if v == "<NA>" {
+			return ""
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (f falcoFields) int(key string) int {
+	switch v := f[key].(type) {
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	default:
+		return 0
+	}
+}
+
+func (f falcoFields) has(keys ...string) bool {
+	for _, k := range keys {
+		if f.str(k) != "" {
+			return true
+		}
+	}
+	return false
+}
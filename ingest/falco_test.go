@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFalcoToEvent_FromFixture(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "test", "fixtures", "falco_shell_spawn_alert.json"))
+	if err != nil {
+		t.Fatalf("Fixture not found: %v", err)
+	}
+
+	var alert FalcoAlert
+	if err := json.Unmarshal(data, &alert); err != nil {
+		t.Fatalf("Failed to unmarshal fixture: %v", err)
+	}
+	event := falcoToEvent(&alert, data)
+
+	if event.EventType != "process_exec" {
+		t.Errorf("Expected event_type process_exec, got %q", event.EventType)
+	}
+	if event.NodeID != "kind-worker" || event.EventID != alert.UUID {
+		t.Errorf("Unexpected node/event id: %q/%q", event.NodeID, event.EventID)
+	}
+	if event.Process == nil || event.Process.Exe != "/bin/bash" || !event.Process.HasTTY {
+		t.Fatalf("Unexpected process: %+v", event.Process)
+	}
+	if len(event.Process.CapabilitiesAdded) != 1 || event.Process.CapabilitiesAdded[0] != "SYS_ADMIN" {
+		t.Errorf("Expected only SYS_ADMIN to count as added, got %v", event.Process.CapabilitiesAdded)
+	}
+	if event.Container == nil || event.Container.Image != "nginx:1.25" || event.Container.Namespace != "prod" {
+		t.Errorf("Unexpected container: %+v", event.Container)
+	}
+	if event.Network != nil {
+		t.Errorf("Expected <NA> fd.sip to leave network empty, got %+v", event.Network)
+	}
+	if event.Metadata["falco.rule"] != "Shell Spawned in Container" || event.Metadata["falco.priority"] != "Warning" {
+		t.Errorf("Unexpected metadata: %v", event.Metadata)
+	}
+}
+
+func TestFalcoToEvent_StableIDWithoutUUID(t *testing.T) {
+	raw := []byte(`{"rule":"Container Outbound Connection","priority":"Notice","hostname":"n1","output_fields":{"evt.type":"connect","fd.sip":"203.0.113.7","fd.sport":4444,"fd.l4proto":"tcp"}}`)
+
+	var alert FalcoAlert
+	if err := json.Unmarshal(raw, &alert); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	first := falcoToEvent(&alert, raw)
+	second := falcoToEvent(&alert, raw)
+
+	if first.EventID == "" || first.EventID != second.EventID {
+		t.Errorf("Expected retried alerts to share an event ID, got %q and %q", first.EventID, second.EventID)
+	}
+	if first.EventType != "network_connect" || first.Network == nil || first.Network.DstPort != 4444 {
+		t.Errorf("Unexpected network event: %+v", first)
+	}
+}
+
+func TestFalcoToEvent_FilePath(t *testing.T) {
+	raw := []byte(`{"rule":"Sensitive File Access","priority":"Warning","hostname":"n1","output_fields":{"evt.type":"openat","proc.pid":42,"proc.exepath":"/usr/bin/python3","proc.cmdline":"python3 app.py","fd.name":"/var/run/secrets/kubernetes.io/serviceaccount/token"}}`)
+
+	event, err := normalizeFalco(raw)
+	if err != nil {
+		t.Fatalf("Failed to normalize: %v", err)
+	}
+	if event.EventType != "file_open" || event.Metadata["file.path"] != "/var/run/secrets/kubernetes.io/serviceaccount/token" {
+		t.Errorf("Expected the opened file in file.path, got %s %v", event.EventType, event.Metadata)
+	}
+	if event.Process == nil || event.Process.Cmdline != "python3 app.py" {
+		t.Errorf("Expected the real cmdline, got %+v", event.Process)
+	}
+}
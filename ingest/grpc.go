@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/ingestpb"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// ackEvery sends an ack once this many events are finished, even if the
+	// ack interval hasn't passed
+	ackEvery = 1000
+	// unavailableRetryAfter is sent when the event stream is full, matching
+	// retryAfterSeconds for HTTP
+	unavailableRetryAfter = 5 * time.Second
+)
+
+// Rejection codes
+const (
+	rejectInvalid   = "invalid"
+	rejectForbidden = "forbidden"
+	rejectShed      = "shed"
+)
+
+// grpcIngest implements the streaming ingest service. Events go through the
+// same validation, identity binding, rate limiting and publish path as the
+// HTTP API. Flow control comes from HTTP/2: each stream is read only as fast
+// as its events are published, so a sensor's sends block once the stream
+// window is full.
+type grpcIngest struct {
+	ingestpb.UnimplementedIngestServer
+	identities  *IdentityMap
+	ackInterval time.Duration
+	// accept publishes an admitted event; acceptEvent outside tests
+	accept func(*models.RuntimeEvent, []byte) error
+}
+
+func newGRPCServer(certs *certReloader, identities *IdentityMap, ackInterval time.Duration) *grpc.Server {
+	var opts []grpc.ServerOption
+	if certs != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.TLSConfig("h2"))))
+	}
+	server := grpc.NewServer(opts...)
+	ingestpb.RegisterIngestServer(server, &grpcIngest{
+		identities:  identities,
+		ackInterval: ackInterval,
+		accept:      acceptEvent,
+	})
+	return server
+}
+
+// backpressureError ends a stream when the pipeline can't take more events
+type backpressureError struct {
+	code       codes.Code
+	msg        string
+	retryAfter time.Duration
+}
+
+func (e *backpressureError) Error() string { return e.msg }
+
+func (s *grpcIngest) StreamEvents(stream ingestpb.Ingest_StreamEventsServer) error {
+	ctx := stream.Context()
+	id, err := s.identity(ctx)
+	if err != nil {
+		return err
+	}
+	source := streamSource(ctx)
+
+	acks := &streamAcks{stream: stream}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		acks.loop(s.ackInterval, stop)
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	var last uint64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return acks.flush(0)
+		}
+		if err != nil {
+			return err
+		}
+		if req.Sequence <= last {
+			return status.Errorf(codes.InvalidArgument, "sequence %d is not after %d", req.Sequence, last)
+		}
+		last = req.Sequence
+
+		rejection, err := s.ingest(req, id, source)
+		if err != nil {
+			// Ack what was finished so only this event onwards is resent
+			bp := &backpressureError{code: codes.Internal, msg: "internal error"}
+			errors.As(err, &bp)
+			if err := acks.flush(bp.retryAfter); err != nil {
+				return err
+			}
+			return status.Error(bp.code, bp.msg)
+		}
+		if acks.finish(req.Sequence, rejection) >= ackEvery {
+			if err := acks.flush(0); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ingest handles one streamed event. Events that can never be accepted come
+// back as a Rejection; errors end the stream so the event is resent later.
+func (s *grpcIngest) ingest(req *ingestpb.StreamEventsRequest, id *SensorIdentity, source models.DeadLetterSource) (*ingestpb.Rejection, error) {
+	reject := func(code string, payload []byte, event *models.RuntimeEvent, err error) *ingestpb.Rejection {
+		src := source
+		deadLetterFrom(&src, id, payload, event, err)
+		return &ingestpb.Rejection{Sequence: req.Sequence, Code: code, Reason: err.Error()}
+	}
+
+	if req.Event == nil {
+		return reject(rejectInvalid, nil, nil, errors.New("missing event")), nil
+	}
+	event := req.Event.ToModel()
+	if event.ClusterID == "" {
+		event.ClusterID = resolveClusterID(id)
+	}
+	if err := validateEvent(event); err != nil {
+		payload, _ := protojson.Marshal(req.Event)
+		return reject(rejectInvalid, payload, event, err), nil
+	}
+	if err := authorizeEvent(id, event, source.RemoteAddr); err != nil {
+		payload, _ := protojson.Marshal(req.Event)
+		return reject(rejectForbidden, payload, event, err), nil
+	}
+
+	if event.EventType == heartbeatEventType {
+		if sensors != nil {
+			sensors.ObserveHeartbeat(heartbeatFromEvent(event))
+		}
+		return nil, nil
+	}
+
+	if err := admitEvent(event); err != nil {
+		rl := asRateLimited(err)
+		if rl.shed {
+			return &ingestpb.Rejection{Sequence: req.Sequence, Code: rejectShed, Reason: rl.Error()}, nil
+		}
+		rateLimitStats.Add("throttled_requests", 1)
+		return nil, &backpressureError{code: codes.ResourceExhausted, msg: rl.Error(), retryAfter: rl.retryAfter}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.accept(event, data); err != nil {
+		if errors.Is(err, errDuplicate) {
+			return nil, nil
+		}
+		log.Printf("Error publishing to NATS: %v", err)
+		if pipeline.IsUnavailable(err) {
+			return nil, &backpressureError{code: codes.Unavailable, msg: "event stream unavailable", retryAfter: unavailableRetryAfter}
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// identity resolves the client certificate to a sensor identity, like
+// identityMiddleware does for HTTP. A nil identity means binding is disabled.
+func (s *grpcIngest) identity(ctx context.Context) (*SensorIdentity, error) {
+	if s.identities == nil {
+		return nil, nil
+	}
+	p, _ := peer.FromContext(ctx)
+	remoteAddr := streamSource(ctx).RemoteAddr
+	var tlsInfo credentials.TLSInfo
+	if p != nil {
+		tlsInfo, _ = p.AuthInfo.(credentials.TLSInfo)
+	}
+	if len(tlsInfo.State.PeerCertificates) == 0 {
+		auditIdentity("Request without client certificate rejected", nil, remoteAddr, nil)
+		return nil, status.Error(codes.PermissionDenied, "client certificate required")
+	}
+
+	cert := tlsInfo.State.PeerCertificates[0]
+	id := s.identities.Resolve(cert)
+	if id == nil {
+		auditIdentity("Client certificate not mapped to any cluster", &SensorIdentity{
+			Subject: cert.Subject.String(),
+		}, remoteAddr, nil)
+		return nil, status.Error(codes.PermissionDenied, "client certificate not authorized")
+	}
+	return id, nil
+}
+
+// streamSource describes the peer of a stream for dead letters
+func streamSource(ctx context.Context) models.DeadLetterSource {
+	var source models.DeadLetterSource
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return source
+	}
+	source.RemoteAddr = p.Addr.String()
+	if host, _, err := net.SplitHostPort(source.RemoteAddr); err == nil {
+		source.RemoteAddr = host
+	}
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+		source.CertSubject = tlsInfo.State.PeerCertificates[0].Subject.String()
+	}
+	return source
+}
+
+// streamAcks tracks which sequences of a stream are finished and sends acks
+// for them. Sends are serialized by mu, since the ack loop and the receive
+// loop both flush.
+type streamAcks struct {
+	stream   ingestpb.Ingest_StreamEventsServer
+	mu       sync.Mutex
+	finished uint64
+	acked    uint64
+	pending  int
+	rejected []*ingestpb.Rejection
+}
+
+// finish marks seq as done, with its rejection if any, and returns how many
+// events are waiting to be acked
+func (a *streamAcks) finish(seq uint64, rejection *ingestpb.Rejection) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.finished = seq
+	if rejection != nil {
+		a.rejected = append(a.rejected, rejection)
+	}
+	a.pending++
+	return a.pending
+}
+
+// flush sends an ack if anything finished since the last one, or always when
+// retryAfter is set
+func (a *streamAcks) flush(retryAfter time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.finished == a.acked && len(a.rejected) == 0 && retryAfter == 0 {
+		return nil
+	}
+	ack := &ingestpb.Ack{
+		AckedSequence: a.finished,
+		Rejected:      a.rejected,
+		RetryAfterMs:  uint32(retryAfter.Milliseconds()),
+	}
+	if err := a.stream.Send(ack); err != nil {
+		return err
+	}
+	a.acked = a.finished
+	a.pending = 0
+	a.rejected = nil
+	return nil
+}
+
+func (a *streamAcks) loop(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.flush(0); err != nil {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
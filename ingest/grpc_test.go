@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/ingestpb"
+	"github.com/podwatch/podwatch/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startTestStream serves svc over an in-memory connection and opens a stream
+func startTestStream(t *testing.T, svc *grpcIngest) ingestpb.Ingest_StreamEventsClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	ingestpb.RegisterIngestServer(server, svc)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	stream, err := ingestpb.NewIngestClient(conn).StreamEvents(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	return stream
+}
+
+// readAcks reads acks until the server ends the stream
+func readAcks(stream ingestpb.Ingest_StreamEventsClient) ([]*ingestpb.Ack, error) {
+	var acks []*ingestpb.Ack
+	for {
+		ack, err := stream.Recv()
+		if err == io.EOF {
+			return acks, nil
+		}
+		if err != nil {
+			return acks, err
+		}
+		acks = append(acks, ack)
+	}
+}
+
+func TestStreamEvents_AcksAndRejections(t *testing.T) {
+	var published []*models.RuntimeEvent
+	stream := startTestStream(t, &grpcIngest{
+		ackInterval: time.Hour,
+		accept: func(event *models.RuntimeEvent, data []byte) error {
+			published = append(published, event)
+			return nil
+		},
+	})
+
+	events := []*ingestpb.RuntimeEvent{
+		{ClusterId: "c", NodeId: "n1", EventType: "process_exec", EventId: "evt-1",
+			Process: &ingestpb.ProcessInfo{Pid: 42, Cmdline: "sh -c id"}},
+		{ClusterId: "c", EventType: "process_exec", EventId: "evt-2"},
+		{ClusterId: "c", NodeId: "n1", EventType: "network_connect", EventId: "evt-3"},
+	}
+	for i, e := range events {
+		if err := stream.Send(&ingestpb.StreamEventsRequest{Sequence: uint64(i + 1), Event: e}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	stream.CloseSend()
+
+	acks, err := readAcks(stream)
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if len(acks) != 1 || acks[0].AckedSequence != 3 {
+		t.Fatalf("Expected a final ack for sequence 3, got %v", acks)
+	}
+	if rej := acks[0].Rejected; len(rej) != 1 || rej[0].Sequence != 2 || rej[0].Code != rejectInvalid {
+		t.Errorf("Expected sequence 2 to be rejected as invalid, got %v", rej)
+	}
+	if len(published) != 2 || published[0].Process.PID != 42 || published[0].Process.Cmdline != "sh -c id" {
+		t.Errorf("Unexpected published events: %+v", published)
+	}
+}
+
+func TestStreamEvents_Backpressure(t *testing.T) {
+	stream := startTestStream(t, &grpcIngest{
+		ackInterval: time.Hour,
+		accept: func(event *models.RuntimeEvent, data []byte) error {
+			if event.EventID == "evt-2" {
+				return jetstream.ErrNoStreamResponse
+			}
+			return nil
+		},
+	})
+
+	for i := 1; i <= 3; i++ {
+		stream.Send(&ingestpb.StreamEventsRequest{
+			Sequence: uint64(i),
+			Event:    &ingestpb.RuntimeEvent{ClusterId: "c", NodeId: "n1", EventId: fmt.Sprintf("evt-%d", i)},
+		})
+	}
+
+	acks, err := readAcks(stream)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected the stream to end with UNAVAILABLE, got %v", err)
+	}
+	if len(acks) != 1 || acks[0].AckedSequence != 1 || acks[0].RetryAfterMs != 5000 {
+		t.Errorf("Expected a final ack for sequence 1 with a retry delay, got %v", acks)
+	}
+}
+
+func TestStreamEvents_SequenceMustIncrease(t *testing.T) {
+	stream := startTestStream(t, &grpcIngest{
+		ackInterval: time.Hour,
+		accept:      func(*models.RuntimeEvent, []byte) error { return nil },
+	})
+
+	event := &ingestpb.RuntimeEvent{ClusterId: "c", NodeId: "n1"}
+	stream.Send(&ingestpb.StreamEventsRequest{Sequence: 5, Event: event})
+	stream.Send(&ingestpb.StreamEventsRequest{Sequence: 5, Event: event})
+
+	if _, err := readAcks(stream); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected INVALID_ARGUMENT for a repeated sequence, got %v", err)
+	}
+}
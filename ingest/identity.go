@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/logging"
+	"github.com/podwatch/podwatch/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// nodeFromCommonName is a node_id value meaning the event's node_id must equal
+// the client certificate's common name (for per-node sensor certificates)
+const nodeFromCommonName = "$CN"
+
+// identityContextKey is the gin context key holding the caller's *SensorIdentity
+const identityContextKey = "sensor_identity"
+
+// IdentityRule maps client certificates to the cluster (and optionally node)
+// they may send events for. Match fields are glob patterns; every field that is
+// set must match for the rule to apply.
+type IdentityRule struct {
+	Name       string `yaml:"name"`
+	CommonName string `yaml:"common_name"`
+	DNSName    string `yaml:"dns_name"`
+	URI        string `yaml:"uri"`
+	ClusterID  string `yaml:"cluster_id"`
+	NodeID     string `yaml:"node_id"`
+}
+
+type identityFile struct {
+	Identities []IdentityRule `yaml:"identities"`
+}
+
+// SensorIdentity is the set of rules that matched a client certificate
+type SensorIdentity struct {
+	Subject string
+	SANs    []string
+	cn      string
+	rules   []IdentityRule
+}
+
+// Allows reports whether the identity may send events for clusterID/nodeID
+func (id *SensorIdentity) Allows(clusterID, nodeID string) bool {
+	for _, rule := range id.rules {
+		if ok, _ := path.Match(rule.ClusterID, clusterID); !ok {
+			continue
+		}
+		switch rule.NodeID {
+		case "":
+			return true
+		case nodeFromCommonName:
+			if nodeID == id.cn {
+				return true
+			}
+		default:
+			if ok, _ := path.Match(rule.NodeID, nodeID); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SingleCluster returns the cluster this identity is bound to when every
+// matching rule names the same, literal cluster_id, and "" otherwise
+func (id *SensorIdentity) SingleCluster() string {
+	cluster := ""
+	for _, rule := range id.rules {
+		if strings.ContainsAny(rule.ClusterID, "*?[") {
+			return ""
+		}
+		if cluster != "" && cluster != rule.ClusterID {
+			return ""
+		}
+		cluster = rule.ClusterID
+	}
+	return cluster
+}
+
+// IdentityMap resolves client certificates to sensor identities. It is safe
+// for concurrent use and can be reloaded in place.
+type IdentityMap struct {
+	file  string
+	mu    sync.RWMutex
+	rules []IdentityRule
+}
+
+func NewIdentityMap(file string) (*IdentityMap, error) {
+	m := &IdentityMap{file: file}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *IdentityMap) reload() error {
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		return fmt.Errorf("failed to read identity map: %w", err)
+	}
+	var f identityFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("failed to parse identity map: %w", err)
+	}
+
+	for i, rule := range f.Identities {
+		if rule.ClusterID == "" {
+			return fmt.Errorf("identity %d (%s): cluster_id is required", i, rule.Name)
+		}
+		if rule.CommonName == "" && rule.DNSName == "" && rule.URI == "" {
+			return fmt.Errorf("identity %d (%s): at least one of common_name, dns_name or uri is required", i, rule.Name)
+		}
+		for _, pattern := range []string{rule.CommonName, rule.DNSName, rule.URI, rule.ClusterID, rule.NodeID} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("identity %d (%s): bad pattern %q", i, rule.Name, pattern)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.rules = f.Identities
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *IdentityMap) onChange() {
+	if err := m.reload(); err != nil {
+		log.Printf("Error reloading identity map, keeping previous: %v", err)
+		return
+	}
+	log.Printf("Reloaded identity map from %s", m.file)
+}
+
+// Resolve returns the identity for cert, or nil if no rule matches
+func (m *IdentityMap) Resolve(cert *x509.Certificate) *SensorIdentity {
+	id := &SensorIdentity{
+		Subject: cert.Subject.String(),
+		cn:      cert.Subject.CommonName,
+	}
+	id.SANs = append(id.SANs, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		id.SANs = append(id.SANs, u.String())
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, rule := range m.rules {
+		if rule.CommonName != "" && !globMatch(rule.CommonName, cert.Subject.CommonName) {
+			continue
+		}
+		if rule.DNSName != "" && !anyGlobMatch(rule.DNSName, cert.DNSNames) {
+			continue
+		}
+		if rule.URI != "" {
+			uris := make([]string, 0, len(cert.URIs))
+			for _, u := range cert.URIs {
+				uris = append(uris, u.String())
+			}
+			if !anyGlobMatch(rule.URI, uris) {
+				continue
+			}
+		}
+		id.rules = append(id.rules, rule)
+	}
+
+	if len(id.rules) == 0 {
+		return nil
+	}
+	return id
+}
+
+func globMatch(pattern, value string) bool {
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+func anyGlobMatch(pattern string, values []string) bool {
+	for _, v := range values {
+		if globMatch(pattern, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityMiddleware resolves the client certificate to a sensor identity
+// and rejects callers whose certificate isn't mapped to any cluster
+func identityMiddleware(m *IdentityMap) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.PeerCertificates) == 0 {
+			auditIdentity("Request without client certificate rejected", nil, c.ClientIP(), nil)
+			c.AbortWithStatusJSON(403, gin.H{"error": "client certificate required"})
+			return
+		}
+
+		id := m.Resolve(c.Request.TLS.PeerCertificates[0])
+		if id == nil {
+			auditIdentity("Client certificate not mapped to any cluster", &SensorIdentity{
+				Subject: c.Request.TLS.PeerCertificates[0].Subject.String(),
+			}, c.ClientIP(), nil)
+			c.AbortWithStatusJSON(403, gin.H{"error": "client certificate not authorized"})
+			return
+		}
+
+		c.Set(identityContextKey, id)
+		c.Next()
+	}
+}
+
+// requestIdentity returns the sensor identity for the request, or nil when
+// identity binding is disabled
+func requestIdentity(c *gin.Context) *SensorIdentity {
+	if v, ok := c.Get(identityContextKey); ok {
+		return v.(*SensorIdentity)
+	}
+	return nil
+}
+
+// errIdentityMismatch is returned when an event claims a cluster/node its
+// client certificate is not allowed to send for
+var errIdentityMismatch = errors.New("cluster_id/node_id not allowed for client certificate")
+
+// authorizeEvent checks the event's claimed cluster and node against the
+// caller's identity. A nil identity means binding is disabled.
+func authorizeEvent(id *SensorIdentity, event *models.RuntimeEvent, remoteAddr string) error {
+	if id == nil || id.Allows(event.ClusterID, event.NodeID) {
+		return nil
+	}
+	auditIdentity("Event identity does not match client certificate", id, remoteAddr, event)
+	return errIdentityMismatch
+}
+
+var auditLogger = logging.NewLogger("podwatch-ingest", "identity")
+
+func auditIdentity(msg string, id *SensorIdentity, remoteAddr string, event *models.RuntimeEvent) {
+	metadata := map[string]interface{}{
+		"remote_addr": remoteAddr,
+	}
+	if id != nil {
+		metadata["cert_subject"] = id.Subject
+		metadata["cert_sans"] = id.SANs
+	}
+
+	entry := logging.SecurityEvent{
+		Level:    logging.LevelAlert,
+		Message:  msg,
+		Metadata: metadata,
+	}
+	if event != nil {
+		entry.EventID = event.EventID
+		entry.Target = &logging.TargetInfo{
+			ClusterID: event.ClusterID,
+			Node:      event.NodeID,
+		}
+	}
+	auditLogger.Log(entry)
+}
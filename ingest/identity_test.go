@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+const testIdentityMap = `
+identities:
+  - name: kind-local-sensors
+    dns_name: "*.sensors.kind-local.podwatch.io"
+    cluster_id: kind-local
+  - name: prod-per-node
+    common_name: "prod-*"
+    cluster_id: prod-east
+    node_id: "$CN"
+  - name: spiffe-staging
+    uri: "spiffe://podwatch/cluster/staging/*"
+    cluster_id: staging
+    node_id: "staging-worker-*"
+`
+
+func loadTestIdentityMap(t *testing.T) *IdentityMap {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "identities.yaml")
+	if err := os.WriteFile(path, []byte(testIdentityMap), 0o600); err != nil {
+		t.Fatalf("Failed to write identity map: %v", err)
+	}
+	m, err := NewIdentityMap(path)
+	if err != nil {
+		t.Fatalf("Failed to load identity map: %v", err)
+	}
+	return m
+}
+
+func TestIdentityMap_ClusterBinding(t *testing.T) {
+	m := loadTestIdentityMap(t)
+
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "falco"},
+		DNSNames: []string{"node-1.sensors.kind-local.podwatch.io"},
+	}
+	id := m.Resolve(cert)
+	if id == nil {
+		t.Fatal("Expected certificate to resolve")
+	}
+	if !id.Allows("kind-local", "any-node") {
+		t.Error("Expected kind-local to be allowed for any node")
+	}
+	if id.Allows("prod-east", "prod-1") {
+		t.Error("Expected prod-east to be rejected for a kind-local certificate")
+	}
+}
+
+func TestIdentityMap_NodeFromCommonName(t *testing.T) {
+	m := loadTestIdentityMap(t)
+
+	id := m.Resolve(&x509.Certificate{Subject: pkix.Name{CommonName: "prod-worker-3"}})
+	if id == nil {
+		t.Fatal("Expected certificate to resolve")
+	}
+	if !id.Allows("prod-east", "prod-worker-3") {
+		t.Error("Expected node matching the CN to be allowed")
+	}
+	if id.Allows("prod-east", "prod-worker-4") {
+		t.Error("Expected a different node to be rejected")
+	}
+}
+
+func TestIdentityMap_URIAndUnmapped(t *testing.T) {
+	m := loadTestIdentityMap(t)
+
+	u, _ := url.Parse("spiffe://podwatch/cluster/staging/falco")
+	id := m.Resolve(&x509.Certificate{URIs: []*url.URL{u}})
+	if id == nil {
+		t.Fatal("Expected SPIFFE certificate to resolve")
+	}
+	if !id.Allows("staging", "staging-worker-1") || id.Allows("staging", "other-node") {
+		t.Error("Unexpected node binding for SPIFFE identity")
+	}
+
+	if m.Resolve(&x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}}) != nil {
+		t.Error("Expected unmapped certificate to resolve to nil")
+	}
+}
+
+func TestRedriveSubject(t *testing.T) {
+	m := loadTestIdentityMap(t)
+	id := m.Resolve(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "falco"},
+		DNSNames: []string{"node-1.sensors.kind-local.podwatch.io"},
+	})
+
+	allowed := &models.RuntimeEvent{ClusterID: "kind-local", NodeID: "node-1"}
+	if got := redriveSubject(id, allowed); got != "redrive.ingest" {
+		t.Errorf("Expected an allowed event to be re-drivable, got %q", got)
+	}
+	if got := redriveSubject(nil, allowed); got != "redrive.ingest" {
+		t.Errorf("Expected events to be re-drivable without identity binding, got %q", got)
+	}
+	if got := redriveSubject(id, &models.RuntimeEvent{ClusterID: "prod-east", NodeID: "prod-1"}); got != "" {
+		t.Errorf("Expected an event the certificate may not send to be left alone, got %q", got)
+	}
+	if got := redriveSubject(nil, nil); got != "" {
+		t.Errorf("Expected undecoded payloads not to be re-drivable, got %q", got)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+)
+
+// auditNodeID is the node_id given to k8s_audit events, which come from the
+// API server rather than a node. Identity rules for the audit webhook's client
+// certificate must allow it.
+const auditNodeID = "kube-apiserver"
+
+// AuditEventList is the audit.k8s.io/v1 EventList the API server's audit
+// webhook backend sends
+type AuditEventList struct {
+	Kind       string       `json:"kind"`
+	APIVersion string       `json:"apiVersion"`
+	Items      []AuditEvent `json:"items"`
+}
+
+// AuditEvent holds the audit.k8s.io/v1 Event fields PodWatch uses
+type AuditEvent struct {
+	AuditID    string `json:"auditID"`
+	Stage      string `json:"stage"`
+	RequestURI string `json:"requestURI"`
+	Verb       string `json:"verb"`
+	User       struct {
+		Username string   `json:"username"`
+		Groups   []string `json:"groups"`
+	} `json:"user"`
+	ImpersonatedUser *struct {
+		Username string `json:"username"`
+	} `json:"impersonatedUser"`
+	SourceIPs []string `json:"sourceIPs"`
+	UserAgent string   `json:"userAgent"`
+	ObjectRef *struct {
+		Resource    string `json:"resource"`
+		Namespace   string `json:"namespace"`
+		Name        string `json:"name"`
+		APIGroup    string `json:"apiGroup"`
+		Subresource string `json:"subresource"`
+	} `json:"objectRef"`
+	ResponseStatus *struct {
+		Code int `json:"code"`
+	} `json:"responseStatus"`
+	StageTimestamp time.Time `json:"stageTimestamp"`
+}
+
+// AuditBatchResult is the response body for POST /v1/k8s-audit. Events at
+// stages other than ResponseComplete and Panic are skipped, since they carry
+// no response code and would duplicate the completed request.
+type AuditBatchResult struct {
+	Accepted    int      `json:"accepted"`
+	Duplicates  int      `json:"duplicates"`
+	Rejected    int      `json:"rejected"`
+	Skipped     int      `json:"skipped"`
+	Unavailable bool     `json:"unavailable,omitempty"`
+	Throttled   bool     `json:"throttled,omitempty"`
+	Errors      []string `json:"errors,omitempty"`
+
+	retryAfter string
+}
+
+// handleK8sAudit accepts an audit EventList from the API server's audit
+// webhook and ingests each completed request as a k8s_audit event. The API
+// server retries the whole batch on a non-2xx response, which is safe because
+// event IDs are the audit IDs. Bodies are capped like batches.
+func handleK8sAudit(c *gin.Context) {
+	bodyBytes, ok := readBody(c, maxBatchBytes)
+	if !ok {
+		return
+	}
+
+	var list AuditEventList
+	if err := json.Unmarshal(bodyBytes, &list); err != nil {
+		deadLetter(c, bodyBytes, nil, err)
+		c.JSON(400, gin.H{"error": "invalid json"})
+		return
+	}
+	if list.Kind != "EventList" || list.APIVersion != "audit.k8s.io/v1" {
+		deadLetter(c, bodyBytes, nil, fmt.Errorf("unexpected kind %s/%s", list.APIVersion, list.Kind))
+		c.JSON(400, gin.H{"error": "expected audit.k8s.io/v1 EventList"})
+		return
+	}
+	if len(list.Items) > maxBatchLines {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("batch exceeds %d events", maxBatchLines)})
+		return
+	}
+
+	id := requestIdentity(c)
+	clusterID := resolveClusterID(id)
+	check := func(event *models.RuntimeEvent) error {
+		if err := validateEvent(event); err != nil {
+			return err
+		}
+		if err := authorizeEvent(id, event, c.ClientIP()); err != nil {
+			return err
+		}
+		return admitEvent(event)
+	}
+
+	result := processAuditList(list.Items, clusterID, check, acceptEvent, batchRejecter(c))
+	log.Printf("Audit batch received: %d accepted, %d duplicates, %d rejected, %d skipped", result.Accepted, result.Duplicates, result.Rejected, result.Skipped)
+	if result.Unavailable {
+		c.Header("Retry-After", retryAfterSeconds)
+		c.JSON(503, result)
+		return
+	}
+	if result.Throttled {
+		rateLimitStats.Add("throttled_requests", 1)
+		c.Header("Retry-After", result.retryAfter)
+		c.JSON(429, result)
+		return
+	}
+	c.JSON(200, result)
+}
+
+// processAuditList normalizes completed audit events and hands each one that
+// passes check to publish. It stops at the first event that could not be
+// published because the stream is full, or that is over its rate limit.
+// Events that fail check for another reason are passed to reject, if set.
+func processAuditList(items []AuditEvent, clusterID string, check func(*models.RuntimeEvent) error, publish func(*models.RuntimeEvent, []byte) error, reject func([]byte, *models.RuntimeEvent, error)) *AuditBatchResult {
+	result := &AuditBatchResult{}
+	for i := range items {
+		item := &items[i]
+		if item.Stage != "ResponseComplete" && item.Stage != "Panic" {
+			result.Skipped++
+			continue
+		}
+
+		event := auditToEvent(item)
+		event.ClusterID = clusterID
+		if err := check(event); err != nil {
+			result.Rejected++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", item.AuditID, err))
+			if rl := asRateLimited(err); rl != nil {
+				if !rl.shed {
+					result.Throttled = true
+					result.retryAfter = rl.RetryAfter()
+					break
+				}
+			} else if reject != nil {
+				data, _ := json.Marshal(event)
+				reject(data, event, err)
+			}
+			continue
+		}
+
+		data, err := json.Marshal(event)
+		if err == nil {
+			err = publish(event, data)
+		}
+		if errors.Is(err, errDuplicate) {
+			result.Duplicates++
+			continue
+		}
+		if err != nil {
+			log.Printf("Error publishing to NATS: %v", err)
+			result.Rejected++
+			if pipeline.IsUnavailable(err) {
+				result.Unavailable = true
+				break
+			}
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: internal error", item.AuditID))
+			continue
+		}
+		result.Accepted++
+	}
+	return result
+}
+
+// auditToEvent normalizes an audit event. Requests against a pod also fill in
+// the container's pod and namespace, so API activity can be grouped with the
+// runtime events from that pod.
+func auditToEvent(item *AuditEvent) *models.RuntimeEvent {
+	audit := &models.K8sAuditInfo{
+		AuditID:    item.AuditID,
+		User:       item.User.Username,
+		Groups:     item.User.Groups,
+		Verb:       item.Verb,
+		RequestURI: item.RequestURI,
+		SourceIPs:  item.SourceIPs,
+		UserAgent:  item.UserAgent,
+	}
+	if item.ImpersonatedUser != nil {
+		audit.ImpersonatedUser = item.ImpersonatedUser.Username
+	}
+	if ref := item.ObjectRef; ref != nil {
+		audit.APIGroup = ref.APIGroup
+		audit.Resource = ref.Resource
+		audit.Subresource = ref.Subresource
+		audit.Namespace = ref.Namespace
+		audit.Name = ref.Name
+	}
+	if item.ResponseStatus != nil {
+		audit.ResponseCode = item.ResponseStatus.Code
+	}
+
+	event := &models.RuntimeEvent{
+		Timestamp: item.StageTimestamp,
+		NodeID:    auditNodeID,
+		EventType: "k8s_audit",
+		EventID:   item.AuditID,
+		K8sAudit:  audit,
+		Metadata: map[string]string{
+			"source":      "k8s_audit",
+			"audit.stage": item.Stage,
+		},
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	if audit.Resource == "pods" && audit.Name != "" {
+		event.Container = &models.ContainerInfo{
+			Pod:       audit.Name,
+			Namespace: audit.Namespace,
+		}
+	}
+	return event
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+func TestProcessAuditList_FromFixture(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "test", "fixtures", "k8s_audit_eventlist.json"))
+	if err != nil {
+		t.Fatalf("Fixture not found: %v", err)
+	}
+	var list AuditEventList
+	if err := json.Unmarshal(data, &list); err != nil {
+		t.Fatalf("Failed to unmarshal fixture: %v", err)
+	}
+
+	var published []*models.RuntimeEvent
+	publish := func(event *models.RuntimeEvent, data []byte) error {
+		published = append(published, event)
+		return nil
+	}
+
+	result := processAuditList(list.Items, "kind-local", validateEvent, publish, nil)
+	if result.Accepted != 2 || result.Skipped != 1 || result.Rejected != 0 {
+		t.Fatalf("Expected 2 accepted and 1 skipped, got %+v", result)
+	}
+
+	exec := published[0]
+	if exec.EventType != "k8s_audit" || exec.EventID != "5b1d9c2e-3f4a-4b6c-8d7e-9f0a1b2c3d4e" {
+		t.Errorf("Unexpected event type/id: %q/%q", exec.EventType, exec.EventID)
+	}
+	if exec.ClusterID != "kind-local" || exec.NodeID != auditNodeID {
+		t.Errorf("Unexpected cluster/node: %q/%q", exec.ClusterID, exec.NodeID)
+	}
+	a := exec.K8sAudit
+	if a == nil || a.User != "alice@example.com" || a.Verb != "create" || a.Resource != "pods" ||
+		a.Subresource != "exec" || a.Namespace != "prod" || a.ResponseCode != 101 {
+		t.Fatalf("Unexpected audit info: %+v", a)
+	}
+	if exec.Container == nil || exec.Container.Pod != "vuln-nginx-7c9b" || exec.Container.Namespace != "prod" {
+		t.Errorf("Expected pod request to fill in container, got %+v", exec.Container)
+	}
+
+	if published[1].Container != nil {
+		t.Errorf("Expected no container for a secrets request, got %+v", published[1].Container)
+	}
+}
+
+func TestProcessAuditList_StopsWhenUnavailable(t *testing.T) {
+	items := []AuditEvent{
+		{AuditID: "a1", Stage: "ResponseComplete"},
+		{AuditID: "a2", Stage: "ResponseComplete"},
+	}
+	calls := 0
+	publish := func(event *models.RuntimeEvent, data []byte) error {
+		calls++
+		return jetstream.ErrNoStreamResponse
+	}
+
+	result := processAuditList(items, "c", validateEvent, publish, nil)
+	if !result.Unavailable || calls != 1 {
+		t.Errorf("Expected processing to stop after one unavailable publish, got %+v after %d calls", result, calls)
+	}
+
+	result = processAuditList(items, "", validateEvent, publish, nil)
+	if result.Rejected != 2 || len(result.Errors) != 2 {
+		t.Errorf("Expected events without a cluster to be rejected, got %+v", result)
+	}
+}
+
+// endlessReader reads as many bytes as asked for, to build oversized bodies
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	return len(p), nil
+}
+
+func TestHandleK8sAudit_BodyTooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/k8s-audit", handleK8sAudit)
+
+	body := io.LimitReader(endlessReader{}, maxBatchBytes+1)
+	req := httptest.NewRequest(http.MethodPost, "/v1/k8s-audit", body)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 413 {
+		t.Errorf("Expected 413, got %d: %s", w.Code, w.Body.String())
+	}
+}
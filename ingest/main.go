@@ -2,23 +2,46 @@ package main
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/filewatch"
+	"github.com/podwatch/podwatch/pkg/fleet"
 	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
 )
 
 var (
-	natsConn *nats.Conn
+	natsConn    *nats.Conn
+	js          jetstream.JetStream
+	archiver    *Archiver
+	rateLimiter *RateLimiter
+	deduper     DedupStore
+	inventory   *fleet.Inventory
+	sensors     *SensorTracker
+	redactor    *Redactor
+	clockSkew   *ClockSkewTracker
+)
+
+const (
+	// retryAfterSeconds is sent with 503 responses when the event stream is full
+	retryAfterSeconds = "5"
+	// reloadInterval is how often certificates and config files are checked for changes
+	reloadInterval = 30 * time.Second
 )
 
 func main() {
@@ -44,56 +67,228 @@ func main() {
 	}
 	defer natsConn.Close()
 
-	// 3. Setup Gin
+	js, err = pipeline.New(natsConn)
+	if err != nil {
+		log.Fatalf("Error setting up JetStream: %v", err)
+	}
+
+	// 3. Raw event archive
+	store, err := newArchiveStore()
+	if err != nil {
+		log.Fatalf("Error configuring archive: %v", err)
+	}
+	if store != nil {
+		flushInterval := 60 * time.Second
+		if v := os.Getenv("ARCHIVE_FLUSH_INTERVAL"); v != "" {
+			if flushInterval, err = time.ParseDuration(v); err != nil || flushInterval <= 0 {
+				log.Fatalf("Invalid ARCHIVE_FLUSH_INTERVAL: %q", v)
+			}
+		}
+		archiver = NewArchiver(store, flushInterval)
+		log.Printf("Archiving raw events (flush every %s)", flushInterval)
+	} else {
+		log.Printf("Raw event archive disabled")
+	}
+
+	// 4. Secret redaction
+	redactionFile := os.Getenv("REDACTION_FILE")
+	if redactor, err = NewRedactor(redactionFile, []byte(os.Getenv("REDACTION_SALT"))); err != nil {
+		log.Fatalf("Error loading redaction config: %v", err)
+	}
+	if redactionFile != "" {
+		defer filewatch.Watch(reloadInterval, []string{redactionFile}, redactor.onChange)()
+	}
+
+	// 5. Clock skew
+	clockSkewTolerance := 30 * time.Second
+	if v := os.Getenv("CLOCK_SKEW_TOLERANCE"); v != "" {
+		if clockSkewTolerance, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid CLOCK_SKEW_TOLERANCE: %v", err)
+		}
+	}
+	switch mode := os.Getenv("CLOCK_SKEW_MODE"); mode {
+	case "", "flag":
+		clockSkew = NewClockSkewTracker(clockSkewTolerance, false)
+	case "correct":
+		clockSkew = NewClockSkewTracker(clockSkewTolerance, true)
+	default:
+		log.Fatalf("Invalid CLOCK_SKEW_MODE %q: must be flag or correct", mode)
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			clockSkew.Sweep()
+		}
+	}()
+
+	// 6. Event de-duplication
+	if deduper, err = newDedupStore(); err != nil {
+		log.Fatalf("Error configuring dedup: %v", err)
+	}
+	if deduper != nil {
+		log.Printf("De-duplicating events by cluster_id/node_id/event_id (%s backend)", os.Getenv("DEDUP_BACKEND"))
+	}
+
+	// 7. Sensor inventory
+	if inventory, err = fleet.Open(js); err != nil {
+		log.Fatalf("Error opening sensor inventory: %v", err)
+	}
+	sensorFlushInterval := 15 * time.Second
+	if v := os.Getenv("SENSOR_FLUSH_INTERVAL"); v != "" {
+		if sensorFlushInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid SENSOR_FLUSH_INTERVAL: %v", err)
+		}
+	}
+	sensors = NewSensorTracker(inventory, clockSkew, sensorFlushInterval)
+
+	// 8. mTLS and identity binding
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	caFile := os.Getenv("TLS_CA_FILE")
+	useTLS := certFile != "" && keyFile != "" && caFile != ""
+
+	var certs *certReloader
+	if useTLS {
+		if certs, err = newCertReloader(certFile, keyFile, caFile); err != nil {
+			log.Fatalf("Error loading TLS material: %v", err)
+		}
+		defer filewatch.Watch(reloadInterval, certs.files(), certs.onChange)()
+	}
+
+	var identities *IdentityMap
+	if identityFile := os.Getenv("IDENTITY_MAP_FILE"); identityFile != "" {
+		if !useTLS {
+			log.Fatalf("IDENTITY_MAP_FILE requires mTLS (TLS_CERT_FILE, TLS_KEY_FILE, TLS_CA_FILE)")
+		}
+		if identities, err = NewIdentityMap(identityFile); err != nil {
+			log.Fatalf("Error loading identity map: %v", err)
+		}
+		defer filewatch.Watch(reloadInterval, []string{identityFile}, identities.onChange)()
+		log.Printf("Binding cluster_id/node_id to client certificates from %s", identityFile)
+	}
+
+	// 9. Rate limits
+	if rateLimitFile := os.Getenv("RATE_LIMIT_FILE"); rateLimitFile != "" {
+		if rateLimiter, err = NewRateLimiter(rateLimitFile); err != nil {
+			log.Fatalf("Error loading rate limits: %v", err)
+		}
+		defer filewatch.Watch(reloadInterval, []string{rateLimitFile}, rateLimiter.onChange)()
+		log.Printf("Rate limiting events per cluster and node from %s", rateLimitFile)
+		go func() {
+			for range time.Tick(time.Minute) {
+				rateLimiter.Sweep()
+			}
+		}()
+	}
+
+	// 10. Dead letters re-driven to ingest
+	redrives, err := consumeRedrives()
+	if err != nil {
+		log.Fatalf("Error subscribing to re-driven events: %v", err)
+	}
+
+	// 11. Setup Gin
 	r := gin.Default()
 
-	r.POST("/v1/events", handleEvent)
+	v1 := r.Group("/v1")
+	if identities != nil {
+		v1.Use(identityMiddleware(identities))
+	}
+	v1.POST("/events", handleEvent)
+	for i := range eventAdapters {
+		v1.POST("/"+eventAdapters[i].Name, adapterHandler(&eventAdapters[i]))
+	}
+	v1.POST("/k8s-audit", handleK8sAudit)
+	v1.GET("/sensors", listSensors)
+	v1.GET("/archive/events", searchArchive)
+	// Gin reads ':' as a path parameter, so custom methods such as
+	// /v1/events:batch are routed through one and dispatched by name
+	v1.POST("/:method", handleCustomMethod)
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
+	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 
-	// 4. Run with mTLS
+	// 12. Run
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	certFile := os.Getenv("TLS_CERT_FILE")
-	keyFile := os.Getenv("TLS_KEY_FILE")
-	caFile := os.Getenv("TLS_CA_FILE")
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+	if useTLS {
+		server.TLSConfig = certs.TLSConfig()
+	}
 
-	if certFile != "" && keyFile != "" && caFile != "" {
-		caCert, err := os.ReadFile(caFile)
-		if err != nil {
-			log.Fatalf("Error reading CA file: %v", err)
+	go func() {
+		var err error
+		if useTLS {
+			log.Printf("Starting Ingest API on port %s with mTLS", port)
+			// Certificates come from TLSConfig so they can be reloaded
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Starting Ingest API on port %s WITHOUT mTLS (dev mode)", port)
+			err = server.ListenAndServe()
 		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-
-		tlsConfig := &tls.Config{
-			ClientCAs:  caCertPool,
-			ClientAuth: tls.RequireAndVerifyClientCert,
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
 		}
+	}()
 
-		server := &http.Server{
-			Addr:      ":" + port,
-			Handler:   r,
-			TLSConfig: tlsConfig,
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	ackInterval := time.Second
+	if v := os.Getenv("GRPC_ACK_INTERVAL"); v != "" {
+		if ackInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid GRPC_ACK_INTERVAL: %v", err)
 		}
-
-		log.Printf("Starting Ingest API on port %s with mTLS", port)
-		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil {
-			log.Fatalf("Server failed: %v", err)
+	}
+	grpcListener, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		log.Fatalf("Error listening on gRPC port %s: %v", grpcPort, err)
+	}
+	grpcServer := newGRPCServer(certs, identities, ackInterval)
+	go func() {
+		log.Printf("Starting streaming ingest (gRPC) on port %s", grpcPort)
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
 		}
-	} else {
-		log.Printf("Starting Ingest API on port %s WITHOUT mTLS (dev mode)", port)
-		if err := r.Run(":" + port); err != nil {
-			log.Fatalf("Server failed: %v", err)
+	}()
+
+	// 13. Graceful shutdown: stop accepting events, then flush the archive and
+	// sensor inventory
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	log.Printf("Shutting down Ingest API")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error during server shutdown: %v", err)
+	}
+	// Streams don't end on their own; sensors resend whatever wasn't acked
+	grpcServer.Stop()
+	redrives.Stop()
+	if archiver != nil {
+		if err := archiver.Close(); err != nil {
+			log.Printf("Error flushing archive: %v", err)
 		}
 	}
+	sensors.Close()
 }
 
 func handleEvent(c *gin.Context) {
+	// Third-party formats sent to the generic endpoint are picked by media type
+	if adapter := adapterForContentType(c.ContentType()); adapter != nil {
+		handleAdaptedEvent(c, adapter)
+		return
+	}
+
 	// Read raw body
 	bodyBytes, err := io.ReadAll(c.Request.Body)
 	if err != nil {
@@ -106,28 +301,108 @@ func handleEvent(c *gin.Context) {
 	// Validate JSON schema
 	var event models.RuntimeEvent
 	if err := json.Unmarshal(bodyBytes, &event); err != nil {
+		deadLetter(c, bodyBytes, nil, err)
 		c.JSON(400, gin.H{"error": "invalid json"})
 		return
 	}
 
 	// Basic validation
-	if event.ClusterID == "" || event.NodeID == "" {
-		c.JSON(400, gin.H{"error": "missing cluster_id or node_id"})
+	if err := validateEvent(&event); err != nil {
+		deadLetter(c, bodyBytes, &event, err)
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 1. Publish to NATS
-	// Subject: events.raw.<cluster>.<node>
-	subject := fmt.Sprintf("events.raw.%s.%s", event.ClusterID, event.NodeID)
-	if err := natsConn.Publish(subject, bodyBytes); err != nil {
+	// The claimed cluster/node must match the client certificate
+	if err := authorizeEvent(requestIdentity(c), &event, c.ClientIP()); err != nil {
+		deadLetter(c, bodyBytes, &event, err)
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := admitEvent(&event); err != nil {
+		respondRateLimited(c, asRateLimited(err))
+		return
+	}
+
+	// Publish to NATS and archive
+	if err := acceptEvent(&event, bodyBytes); err != nil {
+		if errors.Is(err, errDuplicate) {
+			c.JSON(200, gin.H{"status": "duplicate"})
+			return
+		}
 		log.Printf("Error publishing to NATS: %v", err)
+		if pipeline.IsUnavailable(err) {
+			c.Header("Retry-After", retryAfterSeconds)
+			c.JSON(503, gin.H{"error": "event stream unavailable"})
+			return
+		}
 		c.JSON(500, gin.H{"error": "internal error"})
 		return
 	}
 
-	// 2. Write to S3 (Async/Buffered) - Disabled for demo
-	// In production, this would batch events to S3
 	log.Printf("Event received: %s from %s/%s", event.EventType, event.ClusterID, event.NodeID)
 
 	c.JSON(200, gin.H{"status": "accepted"})
 }
+
+func handleCustomMethod(c *gin.Context) {
+	switch c.Param("method") {
+	case "events:batch":
+		handleEventBatch(c)
+	case "sensors:heartbeat":
+		handleHeartbeat(c)
+	default:
+		c.JSON(404, gin.H{"error": "not found"})
+	}
+}
+
+// validateEvent checks the fields every event needs before it can be routed.
+func validateEvent(event *models.RuntimeEvent) error {
+	if event.ClusterID == "" || event.NodeID == "" {
+		return errors.New("missing cluster_id or node_id")
+	}
+	// Both end up in NATS subjects and archive paths
+	if !validClusterID(event.ClusterID) || !validKeySegment(event.NodeID) {
+		return errors.New("invalid cluster_id or node_id")
+	}
+	return nil
+}
+
+// acceptEvent publishes a validated event and archives it. Secrets are
+// masked and the event is stamped with its receive time and checked for
+// clock skew first, so the event is re-encoded, and the archive keeps it as
+// published rather than the payload it was decoded from: archive searches
+// select events by received_at. Events already
+// accepted within the dedup window are dropped with errDuplicate. Archive
+// failures are logged but don't fail the request, since the event has
+// already entered the pipeline.
+func acceptEvent(event *models.RuntimeEvent, _ []byte) error {
+	redactEvent(event)
+	stampEvent(event)
+	normalized, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	key, err := claimEvent(event)
+	if err != nil {
+		return err
+	}
+	if err := publishEvent(event, normalized); err != nil {
+		releaseEvent(key)
+		return err
+	}
+	observeEvent(event)
+	if archiver != nil {
+		if err := archiver.Write(event, normalized); err != nil {
+			log.Printf("Error archiving event %s: %v", event.EventID, err)
+		}
+	}
+	return nil
+}
+
+// publishEvent sends the raw event payload to events.raw.<cluster>.<node>.
+func publishEvent(event *models.RuntimeEvent, data []byte) error {
+	subject := fmt.Sprintf("events.raw.%s.%s", event.ClusterID, event.NodeID)
+	return pipeline.Publish(js, subject, data)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/models"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+// rateLimitStats counts events refused by the rate limiter. throttled_requests
+// counts 429 responses; dropped_events counts refused events, including the
+// shed_events refused early for being low priority.
+var rateLimitStats = expvar.NewMap("ingest_rate_limit")
+
+// bucketIdleTTL is how long a bucket goes unused before Sweep drops it
+const bucketIdleTTL = 10 * time.Minute
+
+// RateLimit is a token bucket: Rate events per second, up to Burst at once
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// ClusterLimits holds the bucket for a whole cluster and the bucket each of
+// its nodes gets. A nil bucket means that scope is not limited.
+type ClusterLimits struct {
+	Cluster *RateLimit `yaml:"cluster"`
+	Node    *RateLimit `yaml:"node"`
+}
+
+// ShedPolicy lists event types that are refused first. They are only admitted
+// while their buckets hold more than Reserve (a fraction of the burst), which
+// keeps the rest of the bucket for security-relevant events.
+type ShedPolicy struct {
+	EventTypes []string `yaml:"event_types"`
+	Reserve    float64  `yaml:"reserve"`
+}
+
+type rateLimitFile struct {
+	Default  ClusterLimits            `yaml:"default"`
+	Clusters map[string]ClusterLimits `yaml:"clusters"`
+	Shed     ShedPolicy               `yaml:"shed"`
+}
+
+// rateLimitError is returned when an event is over its cluster or node limit
+type rateLimitError struct {
+	scope      string
+	retryAfter time.Duration
+	shed       bool
+}
+
+func (e *rateLimitError) Error() string {
+	if e.shed {
+		return "low priority event shed for " + e.scope
+	}
+	return "rate limit exceeded for " + e.scope
+}
+
+// RetryAfter returns the Retry-After value in whole seconds, at least 1
+func (e *rateLimitError) RetryAfter() string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(e.retryAfter.Seconds()))))
+}
+
+// asRateLimited returns the rate limit error in err's chain, if any
+func asRateLimited(err error) *rateLimitError {
+	var rlErr *rateLimitError
+	if errors.As(err, &rlErr) {
+		return rlErr
+	}
+	return nil
+}
+
+// RateLimiter enforces per-cluster and per-node token buckets loaded from a
+// file. Buckets are per ingest replica. It is safe for concurrent use and can
+// be reloaded in place; reloading refills every bucket.
+type RateLimiter struct {
+	file    string
+	mu      sync.Mutex
+	cfg     rateLimitFile
+	shed    map[string]bool
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+func NewRateLimiter(file string) (*RateLimiter, error) {
+	l := &RateLimiter{file: file}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *RateLimiter) reload() error {
+	data, err := os.ReadFile(l.file)
+	if err != nil {
+		return fmt.Errorf("failed to read rate limits: %w", err)
+	}
+	var cfg rateLimitFile
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("failed to parse rate limits: %w", err)
+	}
+
+	check := func(name string, limits ClusterLimits) error {
+		for _, lim := range []*RateLimit{limits.Cluster, limits.Node} {
+			if lim != nil && (lim.Rate <= 0 || lim.Burst <= 0) {
+				return fmt.Errorf("%s: rate and burst must be positive", name)
+			}
+		}
+		return nil
+	}
+	if err := check("default", cfg.Default); err != nil {
+		return err
+	}
+	for name, limits := range cfg.Clusters {
+		if err := check("cluster "+name, limits); err != nil {
+			return err
+		}
+	}
+	if cfg.Shed.Reserve < 0 || cfg.Shed.Reserve >= 1 {
+		return errors.New("shed.reserve must be in [0, 1)")
+	}
+
+	shed := make(map[string]bool, len(cfg.Shed.EventTypes))
+	for _, t := range cfg.Shed.EventTypes {
+		shed[t] = true
+	}
+
+	l.mu.Lock()
+	l.cfg = cfg
+	l.shed = shed
+	l.buckets = make(map[string]*bucket)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *RateLimiter) onChange() {
+	if err := l.reload(); err != nil {
+		log.Printf("Error reloading rate limits, keeping previous: %v", err)
+		return
+	}
+	log.Printf("Reloaded rate limits from %s", l.file)
+}
+
+// Allow takes a token for event from its node and cluster buckets, or from
+// neither. It returns a *rateLimitError when the event is over either limit.
+func (l *RateLimiter) Allow(event *models.RuntimeEvent) error {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits, ok := l.cfg.Clusters[event.ClusterID]
+	if !ok {
+		limits = l.cfg.Default
+	}
+	type scoped struct {
+		scope   string
+		limiter *rate.Limiter
+	}
+	var buckets []scoped
+	if limits.Node != nil {
+		scope := "node " + event.ClusterID + "/" + event.NodeID
+		buckets = append(buckets, scoped{scope, l.bucket(scope, limits.Node, now)})
+	}
+	if limits.Cluster != nil {
+		scope := "cluster " + event.ClusterID
+		buckets = append(buckets, scoped{scope, l.bucket(scope, limits.Cluster, now)})
+	}
+
+	if l.shed[event.EventType] {
+		for _, b := range buckets {
+			if b.limiter.TokensAt(now) < l.cfg.Shed.Reserve*float64(b.limiter.Burst()) {
+				rateLimitStats.Add("dropped_events", 1)
+				rateLimitStats.Add("shed_events", 1)
+				return &rateLimitError{scope: b.scope, retryAfter: time.Second, shed: true}
+			}
+		}
+	}
+
+	// Reserve from every bucket first so that a refusal by one doesn't use
+	// up a token in the others
+	reservations := make([]*rate.Reservation, 0, len(buckets))
+	for _, b := range buckets {
+		r := b.limiter.ReserveN(now, 1)
+		reservations = append(reservations, r)
+		if delay := r.DelayFrom(now); delay > 0 {
+			for _, prev := range reservations {
+				prev.CancelAt(now)
+			}
+			rateLimitStats.Add("dropped_events", 1)
+			return &rateLimitError{scope: b.scope, retryAfter: delay}
+		}
+	}
+	return nil
+}
+
+// bucket returns the limiter for scope, creating it full, and marks it used.
+// The caller holds l.mu.
+func (l *RateLimiter) bucket(scope string, lim *RateLimit, now time.Time) *rate.Limiter {
+	b, ok := l.buckets[scope]
+	if !ok {
+		b = &bucket{Limiter: rate.NewLimiter(rate.Limit(lim.Rate), lim.Burst)}
+		l.buckets[scope] = b
+	}
+	b.lastUsed = now
+	return b.Limiter
+}
+
+// Sweep drops buckets unused for bucketIdleTTL, so nodes that are gone don't
+// keep theirs forever
+func (l *RateLimiter) Sweep() {
+	l.sweep(time.Now())
+}
+
+// sweep drops the buckets idle past bucketIdleTTL at now. Buckets that
+// haven't refilled yet are kept, since dropping them would hand out a full
+// burst early.
+func (l *RateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for scope, b := range l.buckets {
+		if now.Sub(b.lastUsed) > bucketIdleTTL && b.TokensAt(now) >= float64(b.Burst()) {
+			delete(l.buckets, scope)
+		}
+	}
+}
+
+// Len returns the number of buckets held
+func (l *RateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// admitEvent applies the rate limiter, if one is configured
+func admitEvent(event *models.RuntimeEvent) error {
+	if rateLimiter == nil {
+		return nil
+	}
+	return rateLimiter.Allow(event)
+}
+
+// respondRateLimited sends a 429 with Retry-After for a rate limit error
+func respondRateLimited(c *gin.Context, err *rateLimitError) {
+	rateLimitStats.Add("throttled_requests", 1)
+	c.Header("Retry-After", err.RetryAfter())
+	c.JSON(429, gin.H{"error": err.Error()})
+}
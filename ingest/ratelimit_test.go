@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+const testRateLimits = `
+default:
+  node: {rate: 0.001, burst: 2}
+clusters:
+  prod:
+    cluster: {rate: 0.001, burst: 3}
+    node: {rate: 0.001, burst: 10}
+shed:
+  event_types: [file_open]
+  reserve: 0.5
+`
+
+func newTestRateLimiter(t *testing.T, config string) *RateLimiter {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "ratelimits.yaml")
+	if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+		t.Fatalf("Failed to write rate limits: %v", err)
+	}
+	l, err := NewRateLimiter(file)
+	if err != nil {
+		t.Fatalf("Failed to load rate limits: %v", err)
+	}
+	return l
+}
+
+func TestRateLimiter_PerNode(t *testing.T) {
+	l := newTestRateLimiter(t, testRateLimits)
+	n1 := &models.RuntimeEvent{ClusterID: "staging", NodeID: "n1", EventType: "process_exec"}
+	n2 := &models.RuntimeEvent{ClusterID: "staging", NodeID: "n2", EventType: "process_exec"}
+
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(n1); err != nil {
+			t.Fatalf("Event %d: unexpected error: %v", i, err)
+		}
+	}
+	err := l.Allow(n1)
+	rl := asRateLimited(err)
+	if rl == nil || rl.shed || !strings.Contains(rl.scope, "staging/n1") {
+		t.Fatalf("Expected node limit error, got %v", err)
+	}
+	if rl.RetryAfter() == "0" {
+		t.Errorf("Expected a positive Retry-After, got %s", rl.RetryAfter())
+	}
+
+	// Other nodes have their own bucket
+	if err := l.Allow(n2); err != nil {
+		t.Errorf("Expected n2 to be allowed, got %v", err)
+	}
+}
+
+func TestRateLimiter_ClusterLimitKeepsNodeTokens(t *testing.T) {
+	l := newTestRateLimiter(t, testRateLimits)
+	event := &models.RuntimeEvent{ClusterID: "prod", NodeID: "n1", EventType: "process_exec"}
+
+	for i := 0; i < 3; i++ {
+		if err := l.Allow(event); err != nil {
+			t.Fatalf("Event %d: unexpected error: %v", i, err)
+		}
+	}
+	if rl := asRateLimited(l.Allow(event)); rl == nil || rl.scope != "cluster prod" {
+		t.Fatalf("Expected cluster limit error, got %v", rl)
+	}
+
+	node := l.buckets["node prod/n1"]
+	if tokens := node.Tokens(); tokens < 6.9 {
+		t.Errorf("Expected refused event to leave node tokens at 7, got %.2f", tokens)
+	}
+}
+
+func TestRateLimiter_ShedsLowPriorityFirst(t *testing.T) {
+	l := newTestRateLimiter(t, testRateLimits)
+	exec := &models.RuntimeEvent{ClusterID: "prod", NodeID: "n1", EventType: "process_exec"}
+	open := &models.RuntimeEvent{ClusterID: "prod", NodeID: "n1", EventType: "file_open"}
+
+	if err := l.Allow(open); err != nil {
+		t.Fatalf("Expected file_open to be allowed with a full bucket, got %v", err)
+	}
+	// The cluster bucket is now at 2 of 3; below half the burst, file_open is shed
+	if err := l.Allow(exec); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rl := asRateLimited(l.Allow(open)); rl == nil || !rl.shed {
+		t.Fatalf("Expected file_open to be shed, got %v", rl)
+	}
+	if err := l.Allow(exec); err != nil {
+		t.Errorf("Expected process_exec to use the reserve, got %v", err)
+	}
+}
+
+func TestRateLimiter_SweepsIdleBuckets(t *testing.T) {
+	l := newTestRateLimiter(t, `
+default:
+  node: {rate: 10, burst: 2}
+clusters:
+  slow:
+    node: {rate: 0.001, burst: 2}
+`)
+	for _, event := range []*models.RuntimeEvent{
+		{ClusterID: "staging", NodeID: "n1"},
+		{ClusterID: "slow", NodeID: "n1"},
+	} {
+		if err := l.Allow(event); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	l.sweep(time.Now())
+	if l.Len() != 2 {
+		t.Fatalf("Expected recently used buckets to be kept, got %d", l.Len())
+	}
+
+	// Both are idle, but the slow bucket hasn't refilled
+	l.sweep(time.Now().Add(bucketIdleTTL + time.Minute))
+	if _, ok := l.buckets["node staging/n1"]; ok || l.Len() != 1 {
+		t.Errorf("Expected only the refilled idle bucket to be dropped, got %d buckets", l.Len())
+	}
+}
+
+func TestRateLimiter_InvalidConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ratelimits.yaml")
+	os.WriteFile(file, []byte("default:\n  node: {rate: 0, burst: 5}\n"), 0o644)
+	if _, err := NewRateLimiter(file); err == nil {
+		t.Error("Expected an error for a zero rate")
+	}
+}
+
+func TestProcessBatch_Throttled(t *testing.T) {
+	rateLimiter = newTestRateLimiter(t, testRateLimits)
+	defer func() { rateLimiter = nil }()
+
+	body := strings.Join([]string{
+		`{"cluster_id":"staging","node_id":"n1","event_type":"process_exec","event_id":"evt-1"}`,
+		`{"cluster_id":"staging","node_id":"n1","event_type":"process_exec","event_id":"evt-2"}`,
+		`{"cluster_id":"staging","node_id":"n1","event_type":"process_exec","event_id":"evt-3"}`,
+		`{"cluster_id":"staging","node_id":"n1","event_type":"process_exec","event_id":"evt-4"}`,
+	}, "\n")
+	publish := func(event *models.RuntimeEvent, data []byte) error { return nil }
+
+	result, err := processBatch(strings.NewReader(body), admitEvent, publish, nil)
+	if err != nil {
+		t.Fatalf("processBatch failed: %v", err)
+	}
+	if !result.Throttled || result.Accepted != 2 || result.Rejected != 1 || len(result.Results) != 3 {
+		t.Errorf("Expected processing to stop at the first throttled line, got %+v", result)
+	}
+	if result.retryAfter == "" {
+		t.Error("Expected Retry-After to be set")
+	}
+}
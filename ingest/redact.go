@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/podwatch/podwatch/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// redactionStats counts events with masked secrets, in total and per detector
+var redactionStats = expvar.NewMap("ingest_redaction")
+
+// SecretPattern masks matches of Regex. When the regex has a group named
+// "secret", only that group is masked, so "--password=hunter2" keeps its flag.
+type SecretPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+}
+
+// EntropyDetector masks tokens of at least MinLength characters whose Shannon
+// entropy is at least Threshold bits per character, which catches keys and
+// tokens no pattern knows about
+type EntropyDetector struct {
+	Enabled   bool    `yaml:"enabled"`
+	MinLength int     `yaml:"min_length"`
+	Threshold float64 `yaml:"threshold"`
+}
+
+type redactionFile struct {
+	// DisableBuiltin drops the built-in patterns, leaving only Patterns
+	DisableBuiltin bool            `yaml:"disable_builtin"`
+	Patterns       []SecretPattern `yaml:"patterns"`
+	Entropy        EntropyDetector `yaml:"entropy"`
+}
+
+// builtinPatterns cover common ways secrets end up in argv and environment
+// assignments
+var builtinPatterns = []SecretPattern{
+	{Name: "secret_flag", Regex: `(?i)--?[a-z0-9_-]*(?:password|passwd|secret|token|api[-_]?key|access[-_]?key|credentials?)(?:=|\s+)(?P<secret>[^\s'"]+)`},
+	{Name: "secret_assignment", Regex: `(?i)\b[a-z0-9_]*(?:password|passwd|secret|token|api_?key|access_?key)[a-z0-9_]*=(?P<secret>[^\s'"]+)`},
+	{Name: "bearer_token", Regex: `(?i)\bbearer\s+(?P<secret>[a-z0-9._~+/-]+=*)`},
+	{Name: "basic_auth", Regex: `(?i)\bbasic\s+(?P<secret>[a-z0-9+/]+=*)`},
+	{Name: "url_credentials", Regex: `[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:(?P<secret>[^/\s@]+)@`},
+	{Name: "aws_access_key", Regex: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{Name: "jwt", Regex: `\beyJ[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]+`},
+}
+
+var defaultEntropy = EntropyDetector{Enabled: true, MinLength: 24, Threshold: 4.3}
+
+// entropyDetectorName is reported in Redactions for entropy matches
+const entropyDetectorName = "high_entropy"
+
+type compiledPattern struct {
+	name   string
+	re     *regexp.Regexp
+	secret int // index of the "secret" group, or 0 for the whole match
+}
+
+// Redactor masks secrets in event command lines, working directories and
+// container labels before events are published or archived. With a salt,
+// masks carry a salted hash of the secret so rules can still match on
+// equality without seeing it. It is safe for concurrent use and can be
+// reloaded in place.
+type Redactor struct {
+	file     string
+	salt     []byte
+	mu       sync.RWMutex
+	patterns []compiledPattern
+	entropy  EntropyDetector
+	tokens   *regexp.Regexp
+}
+
+// NewRedactor loads detectors from file, or uses the built-in detectors when
+// file is "". salt may be empty, in which case secrets are masked without a
+// hash.
+func NewRedactor(file string, salt []byte) (*Redactor, error) {
+	r := &Redactor{file: file, salt: salt}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Redactor) reload() error {
+	cfg := redactionFile{Entropy: defaultEntropy}
+	if r.file != "" {
+		data, err := os.ReadFile(r.file)
+		if err != nil {
+			return fmt.Errorf("failed to read redaction config: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("failed to parse redaction config: %w", err)
+		}
+	}
+
+	var defs []SecretPattern
+	if !cfg.DisableBuiltin {
+		defs = append(defs, builtinPatterns...)
+	}
+	defs = append(defs, cfg.Patterns...)
+
+	patterns := make([]compiledPattern, 0, len(defs))
+	for _, def := range defs {
+		if def.Name == "" {
+			return fmt.Errorf("pattern %q: name is required", def.Regex)
+		}
+		re, err := regexp.Compile(def.Regex)
+		if err != nil {
+			return fmt.Errorf("pattern %s: %w", def.Name, err)
+		}
+		secret := re.SubexpIndex("secret")
+		if secret < 0 {
+			secret = 0
+		}
+		patterns = append(patterns, compiledPattern{name: def.Name, re: re, secret: secret})
+	}
+
+	var tokens *regexp.Regexp
+	if cfg.Entropy.Enabled {
+		if cfg.Entropy.MinLength <= 0 || cfg.Entropy.Threshold <= 0 {
+			return fmt.Errorf("entropy: min_length and threshold must be positive")
+		}
+		tokens = regexp.MustCompile(fmt.Sprintf(`[a-zA-Z0-9+/_-]{%d,}=*`, cfg.Entropy.MinLength))
+	}
+
+	r.mu.Lock()
+	r.patterns = patterns
+	r.entropy = cfg.Entropy
+	r.tokens = tokens
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Redactor) onChange() {
+	if err := r.reload(); err != nil {
+		log.Printf("Error reloading redaction config, keeping previous: %v", err)
+		return
+	}
+	log.Printf("Reloaded redaction config from %s", r.file)
+}
+
+// Redact masks secrets in the event's command line, working directory and
+// container label values, and records each masked field in
+// event.Redactions. It reports whether anything was masked.
+func (r *Redactor) Redact(event *models.RuntimeEvent) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	before := len(event.Redactions)
+	if p := event.Process; p != nil {
+		p.Cmdline = r.redactField(event, "process.cmdline", p.Cmdline)
+		p.Cwd = r.redactField(event, "process.cwd", p.Cwd)
+	}
+	if c := event.Container; c != nil && len(c.Labels) > 0 {
+		keys := make([]string, 0, len(c.Labels))
+		for k := range c.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			c.Labels[k] = r.redactField(event, "container.labels."+k, c.Labels[k])
+		}
+	}
+
+	if len(event.Redactions) == before {
+		return false
+	}
+	redactionStats.Add("redacted_events", 1)
+	for _, red := range event.Redactions[before:] {
+		redactionStats.Add("detector."+red.Detector, 1)
+	}
+	return true
+}
+
+// RedactPayload masks secrets anywhere in a payload that couldn't be decoded
+// into an event, e.g. a malformed line that is about to be dead-lettered
+func (r *Redactor) RedactPayload(payload []byte) []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	masked, detectors := r.redactText(string(payload))
+	if len(detectors) == 0 {
+		return payload
+	}
+	redactionStats.Add("redacted_payloads", 1)
+	for _, detector := range detectors {
+		redactionStats.Add("detector."+detector, 1)
+	}
+	return []byte(masked)
+}
+
+// redactField runs every detector over value and appends a Redaction to the
+// event for each detector that matched. The caller holds r.mu.
+func (r *Redactor) redactField(event *models.RuntimeEvent, field, value string) string {
+	value, detectors := r.redactText(value)
+	for _, detector := range detectors {
+		event.Redactions = append(event.Redactions, models.Redaction{Field: field, Detector: detector})
+	}
+	return value
+}
+
+// redactText runs every detector over value and returns it masked, along
+// with the detectors that matched. The caller holds r.mu.
+func (r *Redactor) redactText(value string) (string, []string) {
+	if value == "" {
+		return value, nil
+	}
+	var detectors []string
+	for _, p := range r.patterns {
+		var matched bool
+		value, matched = r.maskPattern(p, value)
+		if matched {
+			detectors = append(detectors, p.name)
+		}
+	}
+	if r.tokens != nil {
+		var matched bool
+		value, matched = r.maskEntropy(value)
+		if matched {
+			detectors = append(detectors, entropyDetectorName)
+		}
+	}
+	return value, detectors
+}
+
+func (r *Redactor) maskPattern(p compiledPattern, value string) (string, bool) {
+	var b strings.Builder
+	last, matched := 0, false
+	for _, m := range p.re.FindAllStringSubmatchIndex(value, -1) {
+		start, end := m[2*p.secret], m[2*p.secret+1]
+		// Skip empty groups and secrets an earlier detector already masked
+		if start < 0 || start == end || strings.HasPrefix(value[start:], "[REDACTED") {
+			continue
+		}
+		b.WriteString(value[last:start])
+		b.WriteString(r.mask(value[start:end]))
+		last, matched = end, true
+	}
+	if !matched {
+		return value, false
+	}
+	b.WriteString(value[last:])
+	return b.String(), true
+}
+
+func (r *Redactor) maskEntropy(value string) (string, bool) {
+	var b strings.Builder
+	last, matched := 0, false
+	for _, m := range r.tokens.FindAllStringIndex(value, -1) {
+		token := value[m[0]:m[1]]
+		if !looksRandom(token) || shannonEntropy(token) < r.entropy.Threshold {
+			continue
+		}
+		b.WriteString(value[last:m[0]])
+		b.WriteString(r.mask(token))
+		last, matched = m[1], true
+	}
+	if !matched {
+		return value, false
+	}
+	b.WriteString(value[last:])
+	return b.String(), true
+}
+
+// mask returns the replacement for secret: [REDACTED], or
+// [REDACTED:<hash>] with the first 16 hex digits of its HMAC-SHA256 under
+// the salt
+func (r *Redactor) mask(secret string) string {
+	if len(r.salt) == 0 {
+		return "[REDACTED]"
+	}
+	return "[REDACTED:" + SecretHash(r.salt, secret) + "]"
+}
+
+// SecretHash is the salted hash masks carry, so rule authors can compute the
+// value to match a known secret against
+func SecretHash(salt []byte, secret string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// looksRandom filters out entropy candidates that are paths or plain words:
+// a token must mix letters and digits and not start with '/'
+func looksRandom(token string) bool {
+	if strings.HasPrefix(token, "/") {
+		return false
+	}
+	return strings.ContainsAny(token, "0123456789") &&
+		strings.ContainsAny(strings.ToLower(token), "abcdefghijklmnopqrstuvwxyz")
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	n := float64(len(s))
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// redactEvent applies the redactor, if one is configured, and reports
+// whether the event changed
+func redactEvent(event *models.RuntimeEvent) bool {
+	if redactor == nil {
+		return false
+	}
+	return redactor.Redact(event)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
+)
+
+// consumeRedrives ingests dead letters re-driven to ingest through the
+// incident API
+func consumeRedrives() (jetstream.ConsumeContext, error) {
+	return pipeline.Consume(js, pipeline.StreamIngestRedrive, pipeline.ConsumerIngest, pipeline.RedriveSubject(pipeline.ConsumerIngest), redriveEvent)
+}
+
+// redriveEvent ingests a re-driven event. It is validated again, but neither
+// authorized nor rate limited: only events their sender was allowed to send
+// are re-drivable, and re-drives are started by an operator.
+func redriveEvent(msg jetstream.Msg) {
+	var event models.RuntimeEvent
+	if err := json.Unmarshal(msg.Data(), &event); err != nil {
+		pipeline.DeadLetter(js, "ingest", msg, err, nil)
+		msg.Term()
+		return
+	}
+	source := &models.DeadLetterSource{ClusterID: event.ClusterID, NodeID: event.NodeID}
+	if err := validateEvent(&event); err != nil {
+		pipeline.DeadLetter(js, "ingest", msg, err, source)
+		msg.Term()
+		return
+	}
+
+	if event.EventType == heartbeatEventType {
+		if sensors != nil {
+			sensors.ObserveHeartbeat(heartbeatFromEvent(&event))
+		}
+		msg.Ack()
+		return
+	}
+	if err := acceptEvent(&event, msg.Data()); err != nil && !errors.Is(err, errDuplicate) {
+		log.Printf("Error publishing re-driven event: %v", err)
+		pipeline.Retry(js, "ingest", msg, err, source)
+		return
+	}
+	log.Printf("Re-driven event accepted: %s from %s/%s", event.EventID, event.ClusterID, event.NodeID)
+	msg.Ack()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/podwatch/podwatch/pkg/fleet"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// heartbeatEventType marks normalized events that only report a sensor is
+// alive, such as Falco's periodic metrics snapshot. They update the
+// inventory but are not published.
+const heartbeatEventType = "sensor_heartbeat"
+
+// Heartbeat is the body of POST /v1/sensors:heartbeat, for sensors that
+// report liveness on their own
+type Heartbeat struct {
+	ClusterID     string `json:"cluster_id"`
+	NodeID        string `json:"node_id"`
+	Source        string `json:"source"`
+	SensorVersion string `json:"sensor_version"`
+}
+
+// SensorTracker aggregates what this replica sees from each sensor and
+// periodically merges it into the shared inventory, so the bucket is
+// written once per node per flush rather than once per event
+type SensorTracker struct {
+	record  func(fleet.Observation) error
+	clock   *ClockSkewTracker // optional source of per-node skew
+	mu      sync.Mutex
+	pending map[string]*fleet.Observation
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewSensorTracker(inventory *fleet.Inventory, clock *ClockSkewTracker, flushInterval time.Duration) *SensorTracker {
+	t := newSensorTracker(inventory.Record)
+	t.clock = clock
+	t.wg.Add(1)
+	go t.loop(flushInterval)
+	return t
+}
+
+func newSensorTracker(record func(fleet.Observation) error) *SensorTracker {
+	return &SensorTracker{
+		record:  record,
+		pending: make(map[string]*fleet.Observation),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// ObserveEvent counts an accepted event for its sensor. Audit events come
+// from the API server rather than a node sensor and aren't tracked.
+func (t *SensorTracker) ObserveEvent(event *models.RuntimeEvent) {
+	source := event.Metadata["source"]
+	if source == "k8s_audit" {
+		return
+	}
+	t.observe(event.ClusterID, event.NodeID, source, event.Metadata["sensor.version"], 1)
+}
+
+// ObserveHeartbeat records that a sensor is alive without counting an event
+func (t *SensorTracker) ObserveHeartbeat(hb *Heartbeat) {
+	t.observe(hb.ClusterID, hb.NodeID, hb.Source, hb.SensorVersion, 0)
+}
+
+func (t *SensorTracker) observe(clusterID, nodeID, source, version string, events uint64) {
+	now := time.Now().UTC()
+	key := fleet.Key(clusterID, nodeID)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	obs := t.pending[key]
+	if obs == nil {
+		obs = &fleet.Observation{ClusterID: clusterID, NodeID: nodeID}
+		t.pending[key] = obs
+	}
+	if source != "" {
+		obs.Source = source
+	}
+	if version != "" {
+		obs.SensorVersion = version
+	}
+	if t.clock != nil {
+		if skew, ok := t.clock.Skew(clusterID, nodeID); ok {
+			obs.ClockSkew = &skew
+		}
+	}
+	obs.LastSeen = now
+	if events > 0 {
+		obs.LastEvent = now
+		obs.Events += events
+	}
+}
+
+func (t *SensorTracker) loop(flushInterval time.Duration) {
+	defer t.wg.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Flush()
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// Flush merges pending observations into the inventory. Observations that
+// fail to merge are kept for the next flush.
+func (t *SensorTracker) Flush() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]*fleet.Observation)
+	t.mu.Unlock()
+
+	for key, obs := range pending {
+		if err := t.record(*obs); err != nil {
+			log.Printf("Error updating sensor inventory for %s/%s: %v", obs.ClusterID, obs.NodeID, err)
+			t.requeue(key, obs)
+		}
+	}
+}
+
+// requeue folds an observation that failed to flush back into pending
+func (t *SensorTracker) requeue(key string, obs *fleet.Observation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	newer := t.pending[key]
+	if newer == nil {
+		t.pending[key] = obs
+		return
+	}
+	newer.Events += obs.Events
+	if newer.Source == "" {
+		newer.Source = obs.Source
+	}
+	if newer.SensorVersion == "" {
+		newer.SensorVersion = obs.SensorVersion
+	}
+	if newer.LastEvent.IsZero() {
+		newer.LastEvent = obs.LastEvent
+	}
+	if newer.ClockSkew == nil {
+		newer.ClockSkew = obs.ClockSkew
+	}
+}
+
+// Close stops the flush loop and writes out pending observations
+func (t *SensorTracker) Close() {
+	close(t.stopCh)
+	t.wg.Wait()
+	t.Flush()
+}
+
+// observeEvent records an accepted event in the inventory, if enabled
+func observeEvent(event *models.RuntimeEvent) {
+	if sensors != nil {
+		sensors.ObserveEvent(event)
+	}
+}
+
+// handleHeartbeat records a sensor heartbeat. The cluster and node are
+// checked against the client certificate like an event's.
+func handleHeartbeat(c *gin.Context) {
+	var hb Heartbeat
+	if err := c.BindJSON(&hb); err != nil {
+		c.JSON(400, gin.H{"error": "invalid json"})
+		return
+	}
+	if hb.ClusterID == "" {
+		hb.ClusterID = resolveClusterID(requestIdentity(c))
+	}
+	event := &models.RuntimeEvent{ClusterID: hb.ClusterID, NodeID: hb.NodeID, EventType: heartbeatEventType}
+	if err := validateEvent(event); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := authorizeEvent(requestIdentity(c), event, c.ClientIP()); err != nil {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+	if sensors != nil {
+		sensors.ObserveHeartbeat(&hb)
+	}
+	c.JSON(200, gin.H{"status": "ok"})
+}
+
+// heartbeatFromEvent converts a normalized heartbeat event, such as Falco's
+// metrics snapshot, to a Heartbeat
+func heartbeatFromEvent(event *models.RuntimeEvent) *Heartbeat {
+	return &Heartbeat{
+		ClusterID:     event.ClusterID,
+		NodeID:        event.NodeID,
+		Source:        event.Metadata["source"],
+		SensorVersion: event.Metadata["sensor.version"],
+	}
+}
+
+// listSensors returns the fleet inventory. Filters: cluster_id, status
+// (active/silent). With identity binding, callers only see the clusters and
+// nodes their certificate may report for.
+func listSensors(c *gin.Context) {
+	if inventory == nil {
+		c.JSON(503, gin.H{"error": "sensor inventory unavailable"})
+		return
+	}
+	all, err := inventory.List(fleet.SilentAfter())
+	if err != nil {
+		log.Printf("Error listing sensors: %v", err)
+		c.JSON(500, gin.H{"error": "internal error"})
+		return
+	}
+
+	id := requestIdentity(c)
+	result := []models.Sensor{}
+	for _, s := range all {
+		if id != nil && !id.Allows(s.ClusterID, s.NodeID) {
+			continue
+		}
+		if cluster := c.Query("cluster_id"); cluster != "" && s.ClusterID != cluster {
+			continue
+		}
+		if status := c.Query("status"); status != "" && s.Status != status {
+			continue
+		}
+		result = append(result, s)
+	}
+	c.JSON(200, result)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/podwatch/podwatch/pkg/fleet"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+func TestSensorTracker_AggregatesPerNode(t *testing.T) {
+	var flushed []fleet.Observation
+	tracker := newSensorTracker(func(obs fleet.Observation) error {
+		flushed = append(flushed, obs)
+		return nil
+	})
+
+	falco := map[string]string{"source": "falco"}
+	tracker.ObserveEvent(&models.RuntimeEvent{ClusterID: "c", NodeID: "n1", Metadata: falco})
+	tracker.ObserveEvent(&models.RuntimeEvent{ClusterID: "c", NodeID: "n1", Metadata: falco})
+	tracker.ObserveHeartbeat(&Heartbeat{ClusterID: "c", NodeID: "n1", SensorVersion: "0.38.1"})
+	tracker.ObserveHeartbeat(&Heartbeat{ClusterID: "c", NodeID: "n2"})
+	tracker.ObserveEvent(&models.RuntimeEvent{ClusterID: "c", NodeID: auditNodeID, Metadata: map[string]string{"source": "k8s_audit"}})
+	tracker.Flush()
+
+	if len(flushed) != 2 {
+		t.Fatalf("Expected 2 sensors flushed, got %d", len(flushed))
+	}
+	for _, obs := range flushed {
+		switch obs.NodeID {
+		case "n1":
+			if obs.Events != 2 || obs.Source != "falco" || obs.SensorVersion != "0.38.1" || obs.LastEvent.IsZero() {
+				t.Errorf("Unexpected observation for n1: %+v", obs)
+			}
+		case "n2":
+			if obs.Events != 0 || !obs.LastEvent.IsZero() || obs.LastSeen.IsZero() {
+				t.Errorf("Expected a heartbeat-only observation for n2, got %+v", obs)
+			}
+		default:
+			t.Errorf("Unexpected node %s", obs.NodeID)
+		}
+	}
+
+	flushed = nil
+	tracker.Flush()
+	if len(flushed) != 0 {
+		t.Errorf("Expected nothing to flush, got %d", len(flushed))
+	}
+}
+
+func TestSensorTracker_RequeuesFailedFlush(t *testing.T) {
+	fail := true
+	var flushed []fleet.Observation
+	tracker := newSensorTracker(func(obs fleet.Observation) error {
+		if fail {
+			return errors.New("nats down")
+		}
+		flushed = append(flushed, obs)
+		return nil
+	})
+
+	event := &models.RuntimeEvent{ClusterID: "c", NodeID: "n1"}
+	tracker.ObserveEvent(event)
+	tracker.Flush()
+	tracker.ObserveEvent(event)
+
+	fail = false
+	tracker.Flush()
+	if len(flushed) != 1 || flushed[0].Events != 2 {
+		t.Errorf("Expected the failed flush to be retried with 2 events, got %+v", flushed)
+	}
+}
+
+func TestNormalizeFalco_MetricsSnapshot(t *testing.T) {
+	raw := []byte(`{
+		"hostname": "worker-1",
+		"output": "Falco metrics snapshot",
+		"priority": "Informational",
+		"rule": "Falco internal: metrics snapshot",
+		"source": "internal",
+		"time": "2026-01-02T03:04:05Z",
+		"output_fields": {"evt.source": "syscall", "falco.version": "0.38.1", "falco.num_evts": 12345}
+	}`)
+
+	event, err := normalizeFalco(raw)
+	if err != nil {
+		t.Fatalf("normalizeFalco failed: %v", err)
+	}
+	if event.EventType != heartbeatEventType {
+		t.Errorf("Expected event type %s, got %s", heartbeatEventType, event.EventType)
+	}
+	hb := heartbeatFromEvent(event)
+	if hb.NodeID != "worker-1" || hb.Source != "falco" || hb.SensorVersion != "0.38.1" {
+		t.Errorf("Unexpected heartbeat: %+v", hb)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// TetragonEvent is one line of Tetragon's JSON export (tetra getevents -o json
+// or the export file). Exactly one of the event fields is set.
+type TetragonEvent struct {
+	ProcessExec   *TetragonProcessExec   `json:"process_exec,omitempty"`
+	ProcessKprobe *TetragonProcessKprobe `json:"process_kprobe,omitempty"`
+	NodeName      string                 `json:"node_name"`
+	Time          time.Time              `json:"time"`
+}
+
+type TetragonProcessExec struct {
+	Process *TetragonProcess `json:"process"`
+	Parent  *TetragonProcess `json:"parent"`
+}
+
+type TetragonProcessKprobe struct {
+	Process      *TetragonProcess    `json:"process"`
+	Parent       *TetragonProcess    `json:"parent"`
+	FunctionName string              `json:"function_name"`
+	Args         []TetragonKprobeArg `json:"args"`
+	PolicyName   string              `json:"policy_name"`
+}
+
+type TetragonProcess struct {
+	ExecID             string        `json:"exec_id"`
+	PID                int           `json:"pid"`
+	UID                int           `json:"uid"`
+	Cwd                string        `json:"cwd"`
+	Binary             string        `json:"binary"`
+	Arguments          string        `json:"arguments"`
+	Pod                *TetragonPod  `json:"pod"`
+	Cap                *TetragonCaps `json:"cap"`
+	ProcessCredentials *struct {
+		UID int `json:"uid"`
+		GID int `json:"gid"`
+	} `json:"process_credentials"`
+}
+
+// TetragonCaps is a process's permitted and effective capability sets
+type TetragonCaps struct {
+	Permitted []string `json:"permitted"`
+	Effective []string `json:"effective"`
+}
+
+// all returns the capabilities in either set
+func (c *TetragonCaps) all() []string {
+	if c == nil {
+		return nil
+	}
+	return append(append([]string(nil), c.Permitted...), c.Effective...)
+}
+
+type TetragonPod struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"pod_labels"`
+	Container *struct {
+		ID    string `json:"id"`
+		Image *struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"image"`
+	} `json:"container"`
+}
+
+type TetragonKprobeArg struct {
+	SockArg *struct {
+		Protocol string `json:"protocol"`
+		Daddr    string `json:"daddr"`
+		Dport    int    `json:"dport"`
+	} `json:"sock_arg,omitempty"`
+	FileArg *struct {
+		Path string `json:"path"`
+	} `json:"file_arg,omitempty"`
+	// Credentials being installed, for commit_creds
+	ProcessCredentialsArg *struct {
+		Caps *TetragonCaps `json:"caps"`
+	} `json:"process_credentials_arg,omitempty"`
+	// Capability being checked, for cap_capable
+	CapabilityArg *struct {
+		Name string `json:"name"`
+	} `json:"capability_arg,omitempty"`
+}
+
+// tetragonKprobeTypes maps hooked kernel functions to PodWatch event types
+var tetragonKprobeTypes = map[string]string{
+	"tcp_connect":        "network_connect",
+	"tcp_sendmsg":        "network_connect",
+	"udp_sendmsg":        "network_connect",
+	"security_file_open": "file_open",
+	"fd_install":         "file_open",
+	"cap_capable":        "capability_check",
+	"commit_creds":       "capability_change",
+	"__sys_setuid":       "capability_change",
+}
+
+// normalizeTetragon decodes a single Tetragon process_exec or process_kprobe
+// event. Tetragon events carry no per-event ID, so it is derived from the raw
+// payload.
+func normalizeTetragon(raw []byte) (*models.RuntimeEvent, error) {
+	var te TetragonEvent
+	if err := json.Unmarshal(raw, &te); err != nil {
+		return nil, err
+	}
+
+	var proc, parent *TetragonProcess
+	event := &models.RuntimeEvent{
+		Timestamp: te.Time,
+		NodeID:    te.NodeName,
+		EventID:   uuid.NewSHA1(uuid.NameSpaceOID, raw).String(),
+		Metadata:  map[string]string{"source": "tetragon"},
+	}
+	switch {
+	case te.ProcessExec != nil:
+		proc, parent = te.ProcessExec.Process, te.ProcessExec.Parent
+		event.EventType = "process_exec"
+	case te.ProcessKprobe != nil:
+		k := te.ProcessKprobe
+		proc, parent = k.Process, k.Parent
+		event.EventType = tetragonKprobeTypes[k.FunctionName]
+		if event.EventType == "" {
+			event.EventType = "process_kprobe"
+		}
+		event.Metadata["tetragon.function"] = k.FunctionName
+		if k.PolicyName != "" {
+			event.Metadata["tetragon.policy"] = k.PolicyName
+		}
+		event.Network = tetragonNetwork(k.Args)
+		for _, arg := range k.Args {
+			if arg.FileArg != nil {
+				event.Metadata["file.path"] = arg.FileArg.Path
+				break
+			}
+		}
+		for _, arg := range k.Args {
+			if arg.CapabilityArg != nil {
+				event.Metadata["capability"] = strings.TrimPrefix(arg.CapabilityArg.Name, "CAP_")
+				break
+			}
+		}
+	default:
+		return nil, errors.New("unsupported tetragon event")
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	if proc != nil {
+		event.Process = &models.ProcessInfo{
+			PID:     proc.PID,
+			UID:     proc.UID,
+			Exe:     proc.Binary,
+			Cmdline: strings.TrimSpace(path.Base(proc.Binary) + " " + proc.Arguments),
+			Cwd:     proc.Cwd,
+		}
+		if parent != nil {
+			event.Process.PPID = parent.PID
+		}
+		if proc.ProcessCredentials != nil {
+			event.Process.GID = proc.ProcessCredentials.GID
+		}
+		// Only new credentials add capabilities; the process's own sets are
+		// what it already had, and cap_capable is just a check
+		if te.ProcessKprobe != nil {
+			for _, arg := range te.ProcessKprobe.Args {
+				if arg.ProcessCredentialsArg != nil {
+					event.Process.CapabilitiesAdded = gainedCapabilities(proc.Cap.all(), arg.ProcessCredentialsArg.Caps.all())
+					break
+				}
+			}
+		}
+		event.Metadata["tetragon.exec_id"] = proc.ExecID
+		event.Container = tetragonContainer(proc.Pod)
+	}
+
+	return event, nil
+}
+
+func tetragonContainer(pod *TetragonPod) *models.ContainerInfo {
+	if pod == nil || pod.Container == nil || pod.Container.ID == "" {
+		return nil
+	}
+	container := &models.ContainerInfo{
+		ContainerID: pod.Container.ID,
+		Pod:         pod.Name,
+		Namespace:   pod.Namespace,
+		Labels:      pod.Labels,
+	}
+	if img := pod.Container.Image; img != nil {
+		container.Image = img.Name
+		// The image ID is the resolved reference, e.g. docker.io/library/nginx@sha256:...
+		if i := strings.LastIndex(img.ID, "@"); i >= 0 {
+			container.ImageDigest = img.ID[i+1:]
+		}
+	}
+	return container
+}
+
+func tetragonNetwork(args []TetragonKprobeArg) *models.NetworkInfo {
+	for _, arg := range args {
+		if arg.SockArg == nil || arg.SockArg.Daddr == "" {
+			continue
+		}
+		return &models.NetworkInfo{
+			DstIP:   arg.SockArg.Daddr,
+			DstPort: arg.SockArg.Dport,
+			Proto:   strings.ToLower(strings.TrimPrefix(arg.SockArg.Protocol, "IPPROTO_")),
+		}
+	}
+	return nil
+}
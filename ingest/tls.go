@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// certReloader holds the server certificate and client CA pool and reloads
+// them from disk, so certificates can be rotated without restarting ingest
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	caPool *x509.CertPool
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the certificate, key and CA bundle. On error the previously
+// loaded material stays in use.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	caCert, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CA file: %w", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return fmt.Errorf("no certificates found in CA file %s", r.caFile)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.caPool = caPool
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *certReloader) onChange() {
+	if err := r.reload(); err != nil {
+		log.Printf("Error reloading TLS material, keeping previous: %v", err)
+		return
+	}
+	log.Printf("Reloaded TLS certificate and CA bundle")
+}
+
+func (r *certReloader) files() []string {
+	return []string{r.certFile, r.keyFile, r.caFile}
+}
+
+// TLSConfig returns a server config that picks up the current certificate
+// and CA pool for every new connection. nextProtos are offered in ALPN, e.g.
+// "h2" for gRPC.
+func (r *certReloader) TLSConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientCAs:    r.caPool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				NextProtos:   nextProtos,
+			}, nil
+		},
+	}
+}
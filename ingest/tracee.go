@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// TraceeEvent is the JSON Tracee writes with --output json and its webhook
+// output
+type TraceeEvent struct {
+	Timestamp       int64  `json:"timestamp"` // Nanoseconds since the epoch
+	ProcessID       int    `json:"processId"`
+	ParentProcessID int    `json:"parentProcessId"`
+	UserID          int    `json:"userId"`
+	ProcessName     string `json:"processName"`
+	Executable      struct {
+		Path string `json:"path"`
+	} `json:"executable"`
+	HostName  string `json:"hostName"`
+	Container struct {
+		ID          string `json:"id"`
+		Image       string `json:"image"`
+		ImageDigest string `json:"imageDigest"`
+	} `json:"container"`
+	Kubernetes struct {
+		PodName      string `json:"podName"`
+		PodNamespace string `json:"podNamespace"`
+	} `json:"kubernetes"`
+	EventName   string      `json:"eventName"`
+	Syscall     string      `json:"syscall"`
+	ReturnValue int         `json:"returnValue"`
+	Args        []TraceeArg `json:"args"`
+}
+
+type TraceeArg struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// traceeEventTypes maps Tracee event names to PodWatch event types
+var traceeEventTypes = map[string]string{
+	"sched_process_exec":      "process_exec",
+	"execve":                  "process_exec",
+	"execveat":                "process_exec",
+	"security_socket_connect": "network_connect",
+	"connect":                 "network_connect",
+	"security_file_open":      "file_open",
+	"open":                    "file_open",
+	"openat":                  "file_open",
+	"cap_capable":             "capability_check",
+	"commit_creds":            "capability_change",
+	"setuid":                  "capability_change",
+}
+
+// normalizeTracee decodes a single Tracee event. Tracee events carry no
+// per-event ID, so it is derived from the raw payload.
+func normalizeTracee(raw []byte) (*models.RuntimeEvent, error) {
+	var te TraceeEvent
+	if err := json.Unmarshal(raw, &te); err != nil {
+		return nil, err
+	}
+	if te.EventName == "" {
+		return nil, errors.New("missing eventName")
+	}
+	args := newTraceeArgs(te.Args)
+
+	event := &models.RuntimeEvent{
+		NodeID:    te.HostName,
+		EventID:   uuid.NewSHA1(uuid.NameSpaceOID, raw).String(),
+		EventType: traceeEventTypes[te.EventName],
+		Metadata: map[string]string{
+			"source":       "tracee",
+			"tracee.event": te.EventName,
+		},
+	}
+	if te.Timestamp > 0 {
+		event.Timestamp = time.Unix(0, te.Timestamp).UTC()
+	} else {
+		event.Timestamp = time.Now().UTC()
+	}
+	if event.EventType == "" {
+		event.EventType = te.EventName
+	}
+	if te.Syscall != "" {
+		event.Metadata["tracee.syscall"] = te.Syscall
+	}
+	if path := args.str("pathname"); path != "" && event.EventType == "file_open" {
+		event.Metadata["file.path"] = path
+	}
+
+	exe := args.str("pathname")
+	if event.EventType != "process_exec" || exe == "" {
+		exe = te.Executable.Path
+	}
+	cmdline := te.ProcessName
+	if argv := args.strs("argv"); len(argv) > 0 {
+		cmdline = strings.Join(argv, " ")
+	}
+	event.Process = &models.ProcessInfo{
+		PID:     te.ProcessID,
+		PPID:    te.ParentProcessID,
+		UID:     te.UserID,
+		Exe:     exe,
+		Cmdline: cmdline,
+		Cwd:     args.str("cwd"),
+		// Set for sched_process_exec when Tracee is run with exec-hash
+		ExeSHA256: args.str("sha256"),
+	}
+	// cap_capable only checks a capability; commit_creds shows the old and
+	// new credentials, so the capabilities gained can be told apart
+	if capName := args.str("cap"); capName != "" && event.EventType == "capability_check" {
+		event.Metadata["capability"] = strings.TrimPrefix(capName, "CAP_")
+	}
+	if oldCred, ok := args["old_cred"].(map[string]interface{}); ok {
+		if newCred, ok := args["new_cred"].(map[string]interface{}); ok {
+			event.Process.CapabilitiesAdded = gainedCapabilities(traceeCaps(oldCred), traceeCaps(newCred))
+		}
+	}
+
+	if te.Container.ID != "" {
+		event.Container = &models.ContainerInfo{
+			ContainerID: te.Container.ID,
+			Image:       te.Container.Image,
+			ImageDigest: te.Container.ImageDigest,
+			Pod:         te.Kubernetes.PodName,
+			Namespace:   te.Kubernetes.PodNamespace,
+		}
+	}
+
+	if addr, ok := args["remote_addr"].(map[string]interface{}); ok {
+		event.Network = traceeNetwork(addr, args.str("type"))
+	}
+
+	return event, nil
+}
+
+// traceeNetwork converts a Tracee sockaddr argument. Ports are reported as
+// strings.
+func traceeNetwork(addr map[string]interface{}, sockType string) *models.NetworkInfo {
+	f := falcoFields(addr)
+	ip := f.str("sin_addr")
+	port := f.str("sin_port")
+	if ip == "" {
+		ip, port = f.str("sin6_addr"), f.str("sin6_port")
+	}
+	if ip == "" {
+		return nil
+	}
+	n, _ := strconv.Atoi(port)
+
+	proto := ""
+	switch {
+	case strings.Contains(sockType, "SOCK_STREAM"):
+		proto = "tcp"
+	case strings.Contains(sockType, "SOCK_DGRAM"):
+		proto = "udp"
+	}
+	return &models.NetworkInfo{DstIP: ip, DstPort: n, Proto: proto}
+}
+
+// traceeCaps returns the permitted and effective capabilities of a Tracee
+// slim_cred_t, whose sets are bitmasks
+func traceeCaps(cred map[string]interface{}) []string {
+	var caps []string
+	for _, field := range []string{"CapPermitted", "CapEffective"} {
+		mask, _ := cred[field].(float64)
+		for bit, name := range capabilityNames {
+			if uint64(mask)&(1<<bit) != 0 {
+				caps = append(caps, name)
+			}
+		}
+	}
+	return caps
+}
+
+// traceeArgs indexes event arguments by name
+type traceeArgs map[string]interface{}
+
+func newTraceeArgs(args []TraceeArg) traceeArgs {
+	m := make(traceeArgs, len(args))
+	for _, a := range args {
+		m[a.Name] = a.Value
+	}
+	return m
+}
+
+func (a traceeArgs) str(name string) string {
+	switch v := a[name].(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (a traceeArgs) strs(name string) []string {
+	list, _ := a[name].([]interface{})
+	out := make([]string, 0, len(list))
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
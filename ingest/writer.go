@@ -8,49 +8,48 @@ import (
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/podwatch/podwatch/pkg/models"
 )
 
-// BatchWriter buffers events and writes to S3
+const (
+	// maxBufferBytes forces a flush once a writer has buffered this much compressed data
+	maxBufferBytes = 8 << 20
+)
+
+// BatchWriter buffers events for a single cluster/node and writes them to the
+// archive store as gzip-compressed JSONL objects
 type BatchWriter struct {
-	bucket        string
-	region        string
+	store         ArchiveStore
 	clusterID     string
 	nodeID        string
-	uploader      *s3manager.Uploader
 	buffer        *bytes.Buffer
 	gzipWriter    *gzip.Writer
+	count         int
 	mu            sync.Mutex
 	lastWrite     time.Time
 	flushInterval time.Duration
+	stopCh        chan struct{}
+	wg            sync.WaitGroup
 }
 
-func NewBatchWriter(bucket, region, clusterID string) *BatchWriter {
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	}))
-
-	uploader := s3manager.NewUploader(sess)
-
+func NewBatchWriter(store ArchiveStore, clusterID string, flushInterval time.Duration) *BatchWriter {
 	bw := &BatchWriter{
-		bucket:        bucket,
-		region:        region,
+		store:         store,
 		clusterID:     clusterID,
-		uploader:      uploader,
 		buffer:        new(bytes.Buffer),
 		lastWrite:     time.Now(),
-		flushInterval: 60 * time.Second,
+		flushInterval: flushInterval,
+		stopCh:        make(chan struct{}),
 	}
 	bw.gzipWriter = gzip.NewWriter(bw.buffer)
 
+	bw.wg.Add(1)
 	go bw.loop()
 	return bw
 }
 
-func NewNodeBatchWriter(bucket, region, clusterID, nodeID string) *BatchWriter {
-	bw := NewBatchWriter(bucket, region, clusterID)
+func NewNodeBatchWriter(store ArchiveStore, clusterID, nodeID string, flushInterval time.Duration) *BatchWriter {
+	bw := NewBatchWriter(store, clusterID, flushInterval)
 	bw.nodeID = nodeID
 	return bw
 }
@@ -65,60 +64,226 @@ func (bw *BatchWriter) Write(data []byte) error {
 	if _, err := bw.gzipWriter.Write([]byte("\n")); err != nil {
 		return err
 	}
+	bw.count++
 	return nil
 }
 
 func (bw *BatchWriter) loop() {
+	defer bw.wg.Done()
+
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		bw.flushIfNeeded()
+	for {
+		select {
+		case <-ticker.C:
+			bw.flushIfNeeded()
+		case <-bw.stopCh:
+			return
+		}
 	}
 }
 
 func (bw *BatchWriter) flushIfNeeded() {
 	bw.mu.Lock()
-	if bw.buffer.Len() == 0 || time.Since(bw.lastWrite) < bw.flushInterval {
+	if bw.count == 0 || (time.Since(bw.lastWrite) < bw.flushInterval && bw.buffer.Len() < maxBufferBytes) {
 		bw.mu.Unlock()
 		return
 	}
+	payload, err := bw.takeLocked()
+	bw.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to finish archive batch for %s/%s: %v", bw.clusterID, bw.nodePath(), err)
+		return
+	}
+
+	bw.wg.Add(1)
+	go func() {
+		defer bw.wg.Done()
+		bw.upload(payload)
+	}()
+}
+
+// Flush writes out any buffered events immediately
+func (bw *BatchWriter) Flush() error {
+	bw.mu.Lock()
+	if bw.count == 0 {
+		bw.mu.Unlock()
+		return nil
+	}
+	payload, err := bw.takeLocked()
+	bw.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return bw.upload(payload)
+}
+
+// Close stops the flush loop, writes out buffered events and waits for
+// in-flight uploads to finish
+func (bw *BatchWriter) Close() error {
+	close(bw.stopCh)
+	err := bw.Flush()
+	bw.wg.Wait()
+	return err
+}
 
-	bw.gzipWriter.Close()
-	payload := bw.buffer.Bytes()
+// takeLocked finishes the current gzip stream and returns it, resetting the
+// buffer for the next batch. Caller must hold bw.mu.
+func (bw *BatchWriter) takeLocked() ([]byte, error) {
+	err := bw.gzipWriter.Close()
+	payload := make([]byte, bw.buffer.Len())
+	copy(payload, bw.buffer.Bytes())
 
 	bw.buffer.Reset()
 	bw.gzipWriter.Reset(bw.buffer)
+	bw.count = 0
 	bw.lastWrite = time.Now()
-	bw.mu.Unlock()
+	return payload, err
+}
+
+func (bw *BatchWriter) nodePath() string {
+	if bw.nodeID == "" {
+		return "unknown-node"
+	}
+	return bw.nodeID
+}
+
+func (bw *BatchWriter) upload(data []byte) error {
+	key := archiveKey(bw.clusterID, bw.nodePath(), time.Now().UTC())
 
-	go bw.upload(payload)
+	if err := bw.store.Put(key, data); err != nil {
+		log.Printf("Failed to archive %s: %v", key, err)
+		return err
+	}
+	log.Printf("Archived %s", key)
+	return nil
 }
 
-func (bw *BatchWriter) upload(data []byte) {
-	now := time.Now().UTC()
-	// path: raw/cluster/date/node/hour/*.jsonl.gz
+// archiveKey builds the object key for a batch.
+// path: raw/cluster/date/node/hour/*.jsonl.gz
+func archiveKey(clusterID, nodeID string, t time.Time) string {
+	return fmt.Sprintf("raw/%s/%s/%s/%s/%d.jsonl.gz",
+		clusterID,
+		t.Format("2006-01-02"),
+		nodeID,
+		t.Format("15"),
+		t.UnixNano())
+}
+
+// Archiver fans events out to one BatchWriter per cluster/node. Writers
+// that haven't been written to for a flush interval are closed, so nodes
+// that went away don't keep a writer and its goroutine forever.
+type Archiver struct {
+	store         ArchiveStore
+	flushInterval time.Duration
+	writers       map[string]*BatchWriter
+	lastUsed      map[string]time.Time
+	mu            sync.Mutex
+	closed        bool
+	stopCh        chan struct{}
+	done          chan struct{}
+}
 
-	nodePath := bw.nodeID
-	if nodePath == "" {
-		nodePath = "unknown-node"
+func NewArchiver(store ArchiveStore, flushInterval time.Duration) *Archiver {
+	a := &Archiver{
+		store:         store,
+		flushInterval: flushInterval,
+		writers:       make(map[string]*BatchWriter),
+		lastUsed:      make(map[string]time.Time),
+		stopCh:        make(chan struct{}),
+		done:          make(chan struct{}),
 	}
+	go a.loop()
+	return a
+}
 
-	key := fmt.Sprintf("raw/%s/%s/%s/%s/%s.jsonl.gz",
-		bw.clusterID,
-		now.Format("2006-01-02"),
-		nodePath,
-		now.Format("15"),
-		fmt.Sprintf("%d", now.UnixNano()))
+// Write appends the raw event payload to the writer for its cluster/node
+func (a *Archiver) Write(event *models.RuntimeEvent, data []byte) error {
+	key := event.ClusterID + "/" + event.NodeID
 
-	_, err := bw.uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(bw.bucket),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(data),
-	})
-	if err != nil {
-		log.Printf("Failed to upload to S3: %v", err)
-	} else {
-		log.Printf("Uploaded %s to S3", key)
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return fmt.Errorf("archiver closed")
+	}
+	bw, ok := a.writers[key]
+	if !ok {
+		bw = NewNodeBatchWriter(a.store, event.ClusterID, event.NodeID, a.flushInterval)
+		a.writers[key] = bw
+	}
+	// Marking the writer used before unlocking keeps it from being evicted
+	// before the event is written
+	a.lastUsed[key] = time.Now()
+	a.mu.Unlock()
+
+	return bw.Write(data)
+}
+
+func (a *Archiver) loop() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.evictIdle(time.Now().Add(-a.flushInterval))
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+// evictIdle closes the writers last used before cutoff, writing out what
+// they still buffer
+func (a *Archiver) evictIdle(cutoff time.Time) {
+	idle := make(map[string]*BatchWriter)
+	a.mu.Lock()
+	for key, used := range a.lastUsed {
+		if used.Before(cutoff) {
+			idle[key] = a.writers[key]
+			delete(a.writers, key)
+			delete(a.lastUsed, key)
+		}
+	}
+	a.mu.Unlock()
+
+	for key, bw := range idle {
+		if err := bw.Close(); err != nil {
+			log.Printf("Failed to flush idle archive writer %s: %v", key, err)
+		}
+	}
+}
+
+// Len returns the number of open writers
+func (a *Archiver) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.writers)
+}
+
+// Close flushes every writer. It should be called on shutdown once no more
+// events are being accepted.
+func (a *Archiver) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		close(a.stopCh)
+	}
+	a.closed = true
+	writers := a.writers
+	a.writers = make(map[string]*BatchWriter)
+	a.lastUsed = make(map[string]time.Time)
+	a.mu.Unlock()
+	<-a.done
+
+	var firstErr error
+	for key, bw := range writers {
+		if err := bw.Close(); err != nil {
+			log.Printf("Failed to flush archive writer %s: %v", key, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
 	}
+	return firstErr
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+func TestArchiver_LocalStoreFlushOnClose(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocalStore(root)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	// Long interval so only Close triggers the flush
+	archiver := NewArchiver(store, time.Hour)
+
+	events := []struct {
+		event models.RuntimeEvent
+		data  string
+	}{
+		{models.RuntimeEvent{ClusterID: "kind-local", NodeID: "worker-1"}, `{"event_id":"evt-1"}`},
+		{models.RuntimeEvent{ClusterID: "kind-local", NodeID: "worker-1"}, `{"event_id":"evt-2"}`},
+		{models.RuntimeEvent{ClusterID: "kind-local", NodeID: "worker-2"}, `{"event_id":"evt-3"}`},
+	}
+	for _, e := range events {
+		if err := archiver.Write(&e.event, []byte(e.data)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	if err := archiver.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(root, "raw", "kind-local", "*", "*", "*", "*.jsonl.gz"))
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected one object per node, got %d: %v", len(files), files)
+	}
+
+	lines := map[string][]string{}
+	for _, f := range files {
+		rel, _ := filepath.Rel(root, f)
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		// raw/<cluster>/<date>/<node>/<hour>/<file>
+		if len(parts) != 6 {
+			t.Fatalf("Unexpected archive layout: %s", rel)
+		}
+		lines[parts[3]] = readGzipLines(t, f)
+	}
+
+	if len(lines["worker-1"]) != 2 || lines["worker-1"][1] != `{"event_id":"evt-2"}` {
+		t.Errorf("Unexpected worker-1 contents: %v", lines["worker-1"])
+	}
+	if len(lines["worker-2"]) != 1 {
+		t.Errorf("Unexpected worker-2 contents: %v", lines["worker-2"])
+	}
+
+	if err := archiver.Write(&events[0].event, []byte("{}")); err == nil {
+		t.Error("Expected write after close to fail")
+	}
+}
+
+func readGzipLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to read gzip %s: %v", path, err)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestArchiver_EvictsIdleWriters(t *testing.T) {
+	root := t.TempDir()
+	store, _ := NewLocalStore(root)
+	archiver := NewArchiver(store, time.Hour)
+	defer archiver.Close()
+
+	for _, node := range []string{"worker-1", "worker-2"} {
+		if err := archiver.Write(&models.RuntimeEvent{ClusterID: "kind-local", NodeID: node}, []byte(`{}`)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	archiver.evictIdle(time.Now().Add(-time.Minute))
+	if archiver.Len() != 2 {
+		t.Fatalf("Expected recently used writers to be kept, got %d", archiver.Len())
+	}
+
+	// Evicted writers write out what they buffered
+	archiver.evictIdle(time.Now().Add(time.Minute))
+	if archiver.Len() != 0 {
+		t.Errorf("Expected idle writers to be evicted, got %d", archiver.Len())
+	}
+	files, _ := filepath.Glob(filepath.Join(root, "raw", "kind-local", "*", "*", "*", "*.jsonl.gz"))
+	if len(files) != 2 {
+		t.Errorf("Expected evicted writers to flush, got %v", files)
+	}
+}
+
+func TestValidateEvent_RejectsUnsafeIdentity(t *testing.T) {
+	for _, id := range []string{"..", ".", "../../etc", `a\b`, "a*", "a>", "a b"} {
+		if err := validateEvent(&models.RuntimeEvent{ClusterID: "kind-local", NodeID: id}); err == nil {
+			t.Errorf("Expected node_id %q to be rejected", id)
+		}
+		if err := validateEvent(&models.RuntimeEvent{ClusterID: id, NodeID: "worker-1"}); err == nil {
+			t.Errorf("Expected cluster_id %q to be rejected", id)
+		}
+	}
+	if err := validateEvent(&models.RuntimeEvent{ClusterID: "prod-eu", NodeID: "ip-10-0-0-1.ec2.internal"}); err != nil {
+		t.Errorf("Expected a hostname node_id to be accepted, got %v", err)
+	}
+
+	store, _ := NewLocalStore(t.TempDir())
+	if err := store.Put("raw/../../escape.jsonl.gz", []byte("x")); err == nil {
+		t.Errorf("Expected a key outside the archive to be rejected")
+	}
+}
@@ -0,0 +1,69 @@
+// Package filewatch polls files for changes so services can hot-reload
+// certificates and config without a restart. Polling is used instead of
+// inotify so it also works with Kubernetes Secret and ConfigMap volumes,
+// which are updated by swapping symlinks.
+package filewatch
+
+import (
+	"os"
+	"time"
+)
+
+// Watch calls onChange whenever the modification time or size of any of the
+// given paths changes. Missing files are treated as unchanged until they
+// appear. The returned function stops the watcher.
+func Watch(interval time.Duration, paths []string, onChange func()) (stop func()) {
+	return WatchFunc(interval, func() []string { return paths }, onChange)
+}
+
+// WatchFunc is like Watch, but asks paths for the files to watch on every
+// poll, for configs that name other files. A file added to the list counts
+// as a change.
+func WatchFunc(interval time.Duration, paths func() []string, onChange func()) (stop func()) {
+	last := snapshot(paths())
+	stopCh := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				current := snapshot(paths())
+				if changed(last, current) {
+					last = current
+					onChange()
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return func() { close(stopCh) }
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func snapshot(paths []string) map[string]fileState {
+	states := make(map[string]fileState, len(paths))
+	for _, p := range paths {
+		// Stat follows symlinks, so a swapped ConfigMap volume shows up as a change
+		if info, err := os.Stat(p); err == nil {
+			states[p] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return states
+}
+
+func changed(a, b map[string]fileState) bool {
+	for p, sb := range b {
+		if sa, ok := a[p]; !ok || sa != sb {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+// Package fleet keeps the inventory of sensors reporting to PodWatch in a
+// JetStream key-value bucket, so every ingest replica contributes to, and
+// every service reads, the same view of the fleet.
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// Bucket is the key-value bucket holding one entry per cluster/node
+const Bucket = "SENSORS"
+
+const (
+	// DefaultSilentAfter is how long a sensor may go without reporting
+	// before it is considered silent
+	DefaultSilentAfter = 5 * time.Minute
+	// defaultRetention is how long entries for nodes that stopped
+	// reporting are kept, e.g. after a node is decommissioned
+	defaultRetention = 7 * 24 * time.Hour
+	// rateWindow is the period event rates are averaged over
+	rateWindow = time.Minute
+	// maxUpdateAttempts bounds retries when replicas update an entry
+	// concurrently
+	maxUpdateAttempts = 5
+	timeout           = 5 * time.Second
+)
+
+// Status values
+const (
+	StatusActive = "active"
+	StatusSilent = "silent"
+)
+
+// Observation is what one ingest replica saw from a sensor since its last
+// flush
+type Observation struct {
+	ClusterID     string
+	NodeID        string
+	Source        string
+	SensorVersion string
+	LastSeen      time.Time
+	LastEvent     time.Time
+	Events        uint64
+	// ClockSkew is the latest estimate of the node's clock offset, if any
+	ClockSkew *time.Duration
+}
+
+// record is the stored entry: the sensor plus the events counted in the
+// current rate window
+type record struct {
+	models.Sensor
+	WindowStart  time.Time `json:"window_start"`
+	WindowEvents uint64    `json:"window_events"`
+}
+
+// Inventory reads and updates the sensor bucket
+type Inventory struct {
+	kv jetstream.KeyValue
+}
+
+// Open creates or binds to the sensor bucket. Entries that haven't been
+// updated for SENSOR_RETENTION (default 7 days) expire.
+func Open(js jetstream.JetStream) (*Inventory, error) {
+	retention := defaultRetention
+	if v, err := time.ParseDuration(os.Getenv("SENSOR_RETENTION")); err == nil && v > 0 {
+		retention = v
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      Bucket,
+		Description: "PodWatch sensor inventory",
+		History:     1,
+		TTL:         retention,
+		Storage:     jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bucket %s: %w", Bucket, err)
+	}
+	return &Inventory{kv: kv}, nil
+}
+
+// SilentAfter returns the SENSOR_SILENT_AFTER setting, or DefaultSilentAfter
+func SilentAfter() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("SENSOR_SILENT_AFTER")); err == nil && v > 0 {
+		return v
+	}
+	return DefaultSilentAfter
+}
+
+// invalidKeyChars matches characters not allowed in key-value keys
+var invalidKeyChars = regexp.MustCompile(`[^-/_=.a-zA-Z0-9]`)
+
+// Key returns the bucket key for a cluster/node. Ingest rejects cluster IDs
+// with dots, so a key splits into cluster and node at its first dot.
+func Key(clusterID, nodeID string) string {
+	return invalidKeyChars.ReplaceAllString(clusterID+"."+nodeID, "_")
+}
+
+// Record merges an observation into the sensor's entry. Replicas update
+// entries with compare-and-set, retrying when another replica got there
+// first.
+func (inv *Inventory) Record(obs Observation) error {
+	key := Key(obs.ClusterID, obs.NodeID)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		var rec record
+		var revision uint64
+		entry, getErr := inv.kv.Get(ctx, key)
+		switch {
+		case getErr == nil:
+			if err := json.Unmarshal(entry.Value(), &rec); err != nil {
+				return fmt.Errorf("failed to decode sensor %s: %w", key, err)
+			}
+			revision = entry.Revision()
+		case !errors.Is(getErr, jetstream.ErrKeyNotFound):
+			return getErr
+		}
+
+		rec.merge(obs, time.Now().UTC())
+		data, _ := json.Marshal(rec)
+		if revision == 0 {
+			_, err = inv.kv.Create(ctx, key, data)
+		} else {
+			_, err = inv.kv.Update(ctx, key, data, revision)
+		}
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			return err
+		}
+	}
+	return err
+}
+
+// merge adds obs to the entry and rolls the rate window once it is complete
+func (r *record) merge(obs Observation, now time.Time) {
+	if r.FirstSeen.IsZero() {
+		r.ClusterID = obs.ClusterID
+		r.NodeID = obs.NodeID
+		r.FirstSeen = obs.LastSeen
+		r.WindowStart = now
+	}
+	if obs.Source != "" {
+		r.Source = obs.Source
+	}
+	if obs.SensorVersion != "" {
+		r.SensorVersion = obs.SensorVersion
+	}
+	if obs.LastSeen.After(r.LastSeen) {
+		r.LastSeen = obs.LastSeen
+	}
+	if obs.LastEvent.After(r.LastEvent) {
+		r.LastEvent = obs.LastEvent
+	}
+	if obs.ClockSkew != nil {
+		r.ClockSkewMs = obs.ClockSkew.Milliseconds()
+	}
+	r.EventsTotal += obs.Events
+	r.WindowEvents += obs.Events
+
+	if elapsed := now.Sub(r.WindowStart); elapsed >= rateWindow {
+		r.EventRate = float64(r.WindowEvents) / elapsed.Seconds()
+		r.WindowStart = now
+		r.WindowEvents = 0
+	}
+}
+
+// List returns every sensor in the inventory, with Status set relative to
+// silentAfter
+func (inv *Inventory) List(silentAfter time.Duration) ([]models.Sensor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	lister, err := inv.kv.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer lister.Stop()
+
+	now := time.Now()
+	sensors := []models.Sensor{}
+	for key := range lister.Keys() {
+		entry, err := inv.kv.Get(ctx, key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue // expired since listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rec record
+		if err := json.Unmarshal(entry.Value(), &rec); err != nil {
+			continue
+		}
+		sensor := rec.Sensor
+		sensor.Status = StatusActive
+		if now.Sub(sensor.LastSeen) > silentAfter {
+			sensor.Status = StatusSilent
+		}
+		if now.Sub(rec.WindowStart) > 2*rateWindow {
+			sensor.EventRate = 0 // nothing recorded since the last window
+		}
+		sensors = append(sensors, sensor)
+	}
+	return sensors, nil
+}
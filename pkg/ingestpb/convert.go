@@ -0,0 +1,149 @@
+package ingestpb
+
+import (
+	"github.com/podwatch/podwatch/pkg/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ToModel converts a streamed event to the RuntimeEvent the pipeline carries
+func (e *RuntimeEvent) ToModel() *models.RuntimeEvent {
+	event := &models.RuntimeEvent{
+		ClusterID: e.GetClusterId(),
+		NodeID:    e.GetNodeId(),
+		EventType: e.GetEventType(),
+		EventID:   e.GetEventId(),
+		RawRef:    e.GetRawRef(),
+		Metadata:  nonEmpty(e.GetMetadata()),
+	}
+	if e.GetTs() != nil {
+		event.Timestamp = e.GetTs().AsTime()
+	}
+	if p := e.GetProcess(); p != nil {
+		event.Process = &models.ProcessInfo{
+			PID:               int(p.Pid),
+			PPID:              int(p.Ppid),
+			UID:               int(p.Uid),
+			GID:               int(p.Gid),
+			Exe:               p.Exe,
+			Cmdline:           p.Cmdline,
+			Cwd:               p.Cwd,
+			HasTTY:            p.HasTty,
+			CapabilitiesAdded: p.CapabilitiesAdded,
+			ExeSHA256:         p.ExeSha256,
+		}
+	}
+	if c := e.GetContainer(); c != nil {
+		event.Container = &models.ContainerInfo{
+			ContainerID:    c.ContainerId,
+			Image:          c.Image,
+			ImageDigest:    c.ImageDigest,
+			Pod:            c.Pod,
+			Namespace:      c.Namespace,
+			ServiceAccount: c.ServiceAccount,
+			Labels:         nonEmpty(c.Labels),
+		}
+	}
+	if n := e.GetNetwork(); n != nil {
+		event.Network = &models.NetworkInfo{
+			DstIP:     n.DstIp,
+			DstPort:   int(n.DstPort),
+			Proto:     n.Proto,
+			DstDomain: n.DstDomain,
+		}
+	}
+	if a := e.GetK8SAudit(); a != nil {
+		event.K8sAudit = &models.K8sAuditInfo{
+			AuditID:          a.AuditId,
+			User:             a.User,
+			Groups:           a.Groups,
+			ImpersonatedUser: a.ImpersonatedUser,
+			Verb:             a.Verb,
+			APIGroup:         a.ApiGroup,
+			Resource:         a.Resource,
+			Subresource:      a.Subresource,
+			Namespace:        a.Namespace,
+			Name:             a.Name,
+			RequestURI:       a.RequestUri,
+			ResponseCode:     int(a.ResponseCode),
+			SourceIPs:        a.SourceIps,
+			UserAgent:        a.UserAgent,
+		}
+	}
+	return event
+}
+
+// FromModel converts a RuntimeEvent for streaming, e.g. by a sensor client
+func FromModel(event *models.RuntimeEvent) *RuntimeEvent {
+	e := &RuntimeEvent{
+		ClusterId: event.ClusterID,
+		NodeId:    event.NodeID,
+		EventType: event.EventType,
+		EventId:   event.EventID,
+		RawRef:    event.RawRef,
+		Metadata:  event.Metadata,
+	}
+	if !event.Timestamp.IsZero() {
+		e.Ts = timestamppb.New(event.Timestamp)
+	}
+	if p := event.Process; p != nil {
+		e.Process = &ProcessInfo{
+			Pid:               int32(p.PID),
+			Ppid:              int32(p.PPID),
+			Uid:               int32(p.UID),
+			Gid:               int32(p.GID),
+			Exe:               p.Exe,
+			Cmdline:           p.Cmdline,
+			Cwd:               p.Cwd,
+			HasTty:            p.HasTTY,
+			CapabilitiesAdded: p.CapabilitiesAdded,
+			ExeSha256:         p.ExeSHA256,
+		}
+	}
+	if c := event.Container; c != nil {
+		e.Container = &ContainerInfo{
+			ContainerId:    c.ContainerID,
+			Image:          c.Image,
+			ImageDigest:    c.ImageDigest,
+			Pod:            c.Pod,
+			Namespace:      c.Namespace,
+			ServiceAccount: c.ServiceAccount,
+			Labels:         c.Labels,
+		}
+	}
+	if n := event.Network; n != nil {
+		e.Network = &NetworkInfo{
+			DstIp:     n.DstIP,
+			DstPort:   int32(n.DstPort),
+			Proto:     n.Proto,
+			DstDomain: n.DstDomain,
+		}
+	}
+	if a := event.K8sAudit; a != nil {
+		e.K8SAudit = &K8SAuditInfo{
+			AuditId:          a.AuditID,
+			User:             a.User,
+			Groups:           a.Groups,
+			ImpersonatedUser: a.ImpersonatedUser,
+			Verb:             a.Verb,
+			ApiGroup:         a.APIGroup,
+			Resource:         a.Resource,
+			Subresource:      a.Subresource,
+			Namespace:        a.Namespace,
+			Name:             a.Name,
+			RequestUri:       a.RequestURI,
+			ResponseCode:     int32(a.ResponseCode),
+			SourceIps:        a.SourceIPs,
+			UserAgent:        a.UserAgent,
+		}
+	}
+	return e
+}
+
+// nonEmpty returns nil for empty maps, matching how events decoded from JSON
+// leave missing maps unset
+func nonEmpty(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,5 @@
+// Package ingestpb holds the generated protobuf and gRPC code for the
+// streaming ingest API defined in ingest.proto.
+package ingestpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ingest.proto
@@ -0,0 +1,908 @@
+// Streaming ingest API. Messages mirror pkg/models; see models.RuntimeEvent
+// for field semantics.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.29.3
+// source: ingest.proto
+
+package ingestpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StreamEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sequence numbers must increase within a stream
+	Sequence      uint64        `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Event         *RuntimeEvent `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEventsRequest) Reset() {
+	*x = StreamEventsRequest{}
+	mi := &file_ingest_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEventsRequest) ProtoMessage() {}
+
+func (x *StreamEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEventsRequest.ProtoReflect.Descriptor instead.
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StreamEventsRequest) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *StreamEventsRequest) GetEvent() *RuntimeEvent {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+type Ack struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Every event up to and including this sequence has been accepted or
+	// rejected
+	AckedSequence uint64 `protobuf:"varint,1,opt,name=acked_sequence,json=ackedSequence,proto3" json:"acked_sequence,omitempty"`
+	// Events since the previous ack that were rejected and must not be resent
+	Rejected []*Rejection `protobuf:"bytes,2,rep,name=rejected,proto3" json:"rejected,omitempty"`
+	// Set on the final ack of a stream that was ended for backpressure
+	RetryAfterMs  uint32 `protobuf:"varint,3,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ack) Reset() {
+	*x = Ack{}
+	mi := &file_ingest_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ack) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ack) ProtoMessage() {}
+
+func (x *Ack) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ack.ProtoReflect.Descriptor instead.
+func (*Ack) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Ack) GetAckedSequence() uint64 {
+	if x != nil {
+		return x.AckedSequence
+	}
+	return 0
+}
+
+func (x *Ack) GetRejected() []*Rejection {
+	if x != nil {
+		return x.Rejected
+	}
+	return nil
+}
+
+func (x *Ack) GetRetryAfterMs() uint32 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
+type Rejection struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Sequence uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// invalid, forbidden or shed
+	Code          string `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	Reason        string `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Rejection) Reset() {
+	*x = Rejection{}
+	mi := &file_ingest_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Rejection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rejection) ProtoMessage() {}
+
+func (x *Rejection) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rejection.ProtoReflect.Descriptor instead.
+func (*Rejection) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Rejection) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *Rejection) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *Rejection) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type RuntimeEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ts            *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=ts,proto3" json:"ts,omitempty"`
+	ClusterId     string                 `protobuf:"bytes,2,opt,name=cluster_id,json=clusterId,proto3" json:"cluster_id,omitempty"`
+	NodeId        string                 `protobuf:"bytes,3,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	EventType     string                 `protobuf:"bytes,4,opt,name=event_type,json=eventType,proto3" json:"event_type,omitempty"`
+	EventId       string                 `protobuf:"bytes,5,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	Process       *ProcessInfo           `protobuf:"bytes,6,opt,name=process,proto3" json:"process,omitempty"`
+	Container     *ContainerInfo         `protobuf:"bytes,7,opt,name=container,proto3" json:"container,omitempty"`
+	Network       *NetworkInfo           `protobuf:"bytes,8,opt,name=network,proto3" json:"network,omitempty"`
+	K8SAudit      *K8SAuditInfo          `protobuf:"bytes,9,opt,name=k8s_audit,json=k8sAudit,proto3" json:"k8s_audit,omitempty"`
+	RawRef        string                 `protobuf:"bytes,10,opt,name=raw_ref,json=rawRef,proto3" json:"raw_ref,omitempty"`
+	Metadata      map[string]string      `protobuf:"bytes,11,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RuntimeEvent) Reset() {
+	*x = RuntimeEvent{}
+	mi := &file_ingest_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RuntimeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuntimeEvent) ProtoMessage() {}
+
+func (x *RuntimeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuntimeEvent.ProtoReflect.Descriptor instead.
+func (*RuntimeEvent) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RuntimeEvent) GetTs() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Ts
+	}
+	return nil
+}
+
+func (x *RuntimeEvent) GetClusterId() string {
+	if x != nil {
+		return x.ClusterId
+	}
+	return ""
+}
+
+func (x *RuntimeEvent) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *RuntimeEvent) GetEventType() string {
+	if x != nil {
+		return x.EventType
+	}
+	return ""
+}
+
+func (x *RuntimeEvent) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
+func (x *RuntimeEvent) GetProcess() *ProcessInfo {
+	if x != nil {
+		return x.Process
+	}
+	return nil
+}
+
+func (x *RuntimeEvent) GetContainer() *ContainerInfo {
+	if x != nil {
+		return x.Container
+	}
+	return nil
+}
+
+func (x *RuntimeEvent) GetNetwork() *NetworkInfo {
+	if x != nil {
+		return x.Network
+	}
+	return nil
+}
+
+func (x *RuntimeEvent) GetK8SAudit() *K8SAuditInfo {
+	if x != nil {
+		return x.K8SAudit
+	}
+	return nil
+}
+
+func (x *RuntimeEvent) GetRawRef() string {
+	if x != nil {
+		return x.RawRef
+	}
+	return ""
+}
+
+func (x *RuntimeEvent) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type ProcessInfo struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Pid               int32                  `protobuf:"varint,1,opt,name=pid,proto3" json:"pid,omitempty"`
+	Ppid              int32                  `protobuf:"varint,2,opt,name=ppid,proto3" json:"ppid,omitempty"`
+	Uid               int32                  `protobuf:"varint,3,opt,name=uid,proto3" json:"uid,omitempty"`
+	Gid               int32                  `protobuf:"varint,4,opt,name=gid,proto3" json:"gid,omitempty"`
+	Exe               string                 `protobuf:"bytes,5,opt,name=exe,proto3" json:"exe,omitempty"`
+	Cmdline           string                 `protobuf:"bytes,6,opt,name=cmdline,proto3" json:"cmdline,omitempty"`
+	Cwd               string                 `protobuf:"bytes,7,opt,name=cwd,proto3" json:"cwd,omitempty"`
+	HasTty            bool                   `protobuf:"varint,8,opt,name=has_tty,json=hasTty,proto3" json:"has_tty,omitempty"`
+	CapabilitiesAdded []string               `protobuf:"bytes,9,rep,name=capabilities_added,json=capabilitiesAdded,proto3" json:"capabilities_added,omitempty"`
+	// Hex SHA-256 of the executable, if known
+	ExeSha256     string `protobuf:"bytes,10,opt,name=exe_sha256,json=exeSha256,proto3" json:"exe_sha256,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessInfo) Reset() {
+	*x = ProcessInfo{}
+	mi := &file_ingest_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessInfo) ProtoMessage() {}
+
+func (x *ProcessInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessInfo.ProtoReflect.Descriptor instead.
+func (*ProcessInfo) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ProcessInfo) GetPid() int32 {
+	if x != nil {
+		return x.Pid
+	}
+	return 0
+}
+
+func (x *ProcessInfo) GetPpid() int32 {
+	if x != nil {
+		return x.Ppid
+	}
+	return 0
+}
+
+func (x *ProcessInfo) GetUid() int32 {
+	if x != nil {
+		return x.Uid
+	}
+	return 0
+}
+
+func (x *ProcessInfo) GetGid() int32 {
+	if x != nil {
+		return x.Gid
+	}
+	return 0
+}
+
+func (x *ProcessInfo) GetExe() string {
+	if x != nil {
+		return x.Exe
+	}
+	return ""
+}
+
+func (x *ProcessInfo) GetCmdline() string {
+	if x != nil {
+		return x.Cmdline
+	}
+	return ""
+}
+
+func (x *ProcessInfo) GetCwd() string {
+	if x != nil {
+		return x.Cwd
+	}
+	return ""
+}
+
+func (x *ProcessInfo) GetHasTty() bool {
+	if x != nil {
+		return x.HasTty
+	}
+	return false
+}
+
+func (x *ProcessInfo) GetCapabilitiesAdded() []string {
+	if x != nil {
+		return x.CapabilitiesAdded
+	}
+	return nil
+}
+
+func (x *ProcessInfo) GetExeSha256() string {
+	if x != nil {
+		return x.ExeSha256
+	}
+	return ""
+}
+
+type ContainerInfo struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ContainerId    string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	Image          string                 `protobuf:"bytes,2,opt,name=image,proto3" json:"image,omitempty"`
+	ImageDigest    string                 `protobuf:"bytes,3,opt,name=image_digest,json=imageDigest,proto3" json:"image_digest,omitempty"`
+	Pod            string                 `protobuf:"bytes,4,opt,name=pod,proto3" json:"pod,omitempty"`
+	Namespace      string                 `protobuf:"bytes,5,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	ServiceAccount string                 `protobuf:"bytes,6,opt,name=service_account,json=serviceAccount,proto3" json:"service_account,omitempty"`
+	Labels         map[string]string      `protobuf:"bytes,7,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ContainerInfo) Reset() {
+	*x = ContainerInfo{}
+	mi := &file_ingest_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ContainerInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContainerInfo) ProtoMessage() {}
+
+func (x *ContainerInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContainerInfo.ProtoReflect.Descriptor instead.
+func (*ContainerInfo) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ContainerInfo) GetContainerId() string {
+	if x != nil {
+		return x.ContainerId
+	}
+	return ""
+}
+
+func (x *ContainerInfo) GetImage() string {
+	if x != nil {
+		return x.Image
+	}
+	return ""
+}
+
+func (x *ContainerInfo) GetImageDigest() string {
+	if x != nil {
+		return x.ImageDigest
+	}
+	return ""
+}
+
+func (x *ContainerInfo) GetPod() string {
+	if x != nil {
+		return x.Pod
+	}
+	return ""
+}
+
+func (x *ContainerInfo) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *ContainerInfo) GetServiceAccount() string {
+	if x != nil {
+		return x.ServiceAccount
+	}
+	return ""
+}
+
+func (x *ContainerInfo) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type NetworkInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DstIp         string                 `protobuf:"bytes,1,opt,name=dst_ip,json=dstIp,proto3" json:"dst_ip,omitempty"`
+	DstPort       int32                  `protobuf:"varint,2,opt,name=dst_port,json=dstPort,proto3" json:"dst_port,omitempty"`
+	Proto         string                 `protobuf:"bytes,3,opt,name=proto,proto3" json:"proto,omitempty"`
+	DstDomain     string                 `protobuf:"bytes,4,opt,name=dst_domain,json=dstDomain,proto3" json:"dst_domain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NetworkInfo) Reset() {
+	*x = NetworkInfo{}
+	mi := &file_ingest_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NetworkInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NetworkInfo) ProtoMessage() {}
+
+func (x *NetworkInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NetworkInfo.ProtoReflect.Descriptor instead.
+func (*NetworkInfo) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *NetworkInfo) GetDstIp() string {
+	if x != nil {
+		return x.DstIp
+	}
+	return ""
+}
+
+func (x *NetworkInfo) GetDstPort() int32 {
+	if x != nil {
+		return x.DstPort
+	}
+	return 0
+}
+
+func (x *NetworkInfo) GetProto() string {
+	if x != nil {
+		return x.Proto
+	}
+	return ""
+}
+
+func (x *NetworkInfo) GetDstDomain() string {
+	if x != nil {
+		return x.DstDomain
+	}
+	return ""
+}
+
+type K8SAuditInfo struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	AuditId          string                 `protobuf:"bytes,1,opt,name=audit_id,json=auditId,proto3" json:"audit_id,omitempty"`
+	User             string                 `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
+	Groups           []string               `protobuf:"bytes,3,rep,name=groups,proto3" json:"groups,omitempty"`
+	ImpersonatedUser string                 `protobuf:"bytes,4,opt,name=impersonated_user,json=impersonatedUser,proto3" json:"impersonated_user,omitempty"`
+	Verb             string                 `protobuf:"bytes,5,opt,name=verb,proto3" json:"verb,omitempty"`
+	ApiGroup         string                 `protobuf:"bytes,6,opt,name=api_group,json=apiGroup,proto3" json:"api_group,omitempty"`
+	Resource         string                 `protobuf:"bytes,7,opt,name=resource,proto3" json:"resource,omitempty"`
+	Subresource      string                 `protobuf:"bytes,8,opt,name=subresource,proto3" json:"subresource,omitempty"`
+	Namespace        string                 `protobuf:"bytes,9,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Name             string                 `protobuf:"bytes,10,opt,name=name,proto3" json:"name,omitempty"`
+	RequestUri       string                 `protobuf:"bytes,11,opt,name=request_uri,json=requestUri,proto3" json:"request_uri,omitempty"`
+	ResponseCode     int32                  `protobuf:"varint,12,opt,name=response_code,json=responseCode,proto3" json:"response_code,omitempty"`
+	SourceIps        []string               `protobuf:"bytes,13,rep,name=source_ips,json=sourceIps,proto3" json:"source_ips,omitempty"`
+	UserAgent        string                 `protobuf:"bytes,14,opt,name=user_agent,json=userAgent,proto3" json:"user_agent,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *K8SAuditInfo) Reset() {
+	*x = K8SAuditInfo{}
+	mi := &file_ingest_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *K8SAuditInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*K8SAuditInfo) ProtoMessage() {}
+
+func (x *K8SAuditInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use K8SAuditInfo.ProtoReflect.Descriptor instead.
+func (*K8SAuditInfo) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *K8SAuditInfo) GetAuditId() string {
+	if x != nil {
+		return x.AuditId
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetGroups() []string {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *K8SAuditInfo) GetImpersonatedUser() string {
+	if x != nil {
+		return x.ImpersonatedUser
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetVerb() string {
+	if x != nil {
+		return x.Verb
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetApiGroup() string {
+	if x != nil {
+		return x.ApiGroup
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetResource() string {
+	if x != nil {
+		return x.Resource
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetSubresource() string {
+	if x != nil {
+		return x.Subresource
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetRequestUri() string {
+	if x != nil {
+		return x.RequestUri
+	}
+	return ""
+}
+
+func (x *K8SAuditInfo) GetResponseCode() int32 {
+	if x != nil {
+		return x.ResponseCode
+	}
+	return 0
+}
+
+func (x *K8SAuditInfo) GetSourceIps() []string {
+	if x != nil {
+		return x.SourceIps
+	}
+	return nil
+}
+
+func (x *K8SAuditInfo) GetUserAgent() string {
+	if x != nil {
+		return x.UserAgent
+	}
+	return ""
+}
+
+var File_ingest_proto protoreflect.FileDescriptor
+
+const file_ingest_proto_rawDesc = "" +
+	"\n" +
+	"\fingest.proto\x12\x12podwatch.ingest.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"i\n" +
+	"\x13StreamEventsRequest\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x126\n" +
+	"\x05event\x18\x02 \x01(\v2 .podwatch.ingest.v1.RuntimeEventR\x05event\"\x8d\x01\n" +
+	"\x03Ack\x12%\n" +
+	"\x0eacked_sequence\x18\x01 \x01(\x04R\rackedSequence\x129\n" +
+	"\brejected\x18\x02 \x03(\v2\x1d.podwatch.ingest.v1.RejectionR\brejected\x12$\n" +
+	"\x0eretry_after_ms\x18\x03 \x01(\rR\fretryAfterMs\"S\n" +
+	"\tRejection\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"\xc4\x04\n" +
+	"\fRuntimeEvent\x12*\n" +
+	"\x02ts\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x02ts\x12\x1d\n" +
+	"\n" +
+	"cluster_id\x18\x02 \x01(\tR\tclusterId\x12\x17\n" +
+	"\anode_id\x18\x03 \x01(\tR\x06nodeId\x12\x1d\n" +
+	"\n" +
+	"event_type\x18\x04 \x01(\tR\teventType\x12\x19\n" +
+	"\bevent_id\x18\x05 \x01(\tR\aeventId\x129\n" +
+	"\aprocess\x18\x06 \x01(\v2\x1f.podwatch.ingest.v1.ProcessInfoR\aprocess\x12?\n" +
+	"\tcontainer\x18\a \x01(\v2!.podwatch.ingest.v1.ContainerInfoR\tcontainer\x129\n" +
+	"\anetwork\x18\b \x01(\v2\x1f.podwatch.ingest.v1.NetworkInfoR\anetwork\x12=\n" +
+	"\tk8s_audit\x18\t \x01(\v2 .podwatch.ingest.v1.K8sAuditInfoR\bk8sAudit\x12\x17\n" +
+	"\araw_ref\x18\n" +
+	" \x01(\tR\x06rawRef\x12J\n" +
+	"\bmetadata\x18\v \x03(\v2..podwatch.ingest.v1.RuntimeEvent.MetadataEntryR\bmetadata\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xfc\x01\n" +
+	"\vProcessInfo\x12\x10\n" +
+	"\x03pid\x18\x01 \x01(\x05R\x03pid\x12\x12\n" +
+	"\x04ppid\x18\x02 \x01(\x05R\x04ppid\x12\x10\n" +
+	"\x03uid\x18\x03 \x01(\x05R\x03uid\x12\x10\n" +
+	"\x03gid\x18\x04 \x01(\x05R\x03gid\x12\x10\n" +
+	"\x03exe\x18\x05 \x01(\tR\x03exe\x12\x18\n" +
+	"\acmdline\x18\x06 \x01(\tR\acmdline\x12\x10\n" +
+	"\x03cwd\x18\a \x01(\tR\x03cwd\x12\x17\n" +
+	"\ahas_tty\x18\b \x01(\bR\x06hasTty\x12-\n" +
+	"\x12capabilities_added\x18\t \x03(\tR\x11capabilitiesAdded\x12\x1d\n" +
+	"\n" +
+	"exe_sha256\x18\n" +
+	" \x01(\tR\texeSha256\"\xc6\x02\n" +
+	"\rContainerInfo\x12!\n" +
+	"\fcontainer_id\x18\x01 \x01(\tR\vcontainerId\x12\x14\n" +
+	"\x05image\x18\x02 \x01(\tR\x05image\x12!\n" +
+	"\fimage_digest\x18\x03 \x01(\tR\vimageDigest\x12\x10\n" +
+	"\x03pod\x18\x04 \x01(\tR\x03pod\x12\x1c\n" +
+	"\tnamespace\x18\x05 \x01(\tR\tnamespace\x12'\n" +
+	"\x0fservice_account\x18\x06 \x01(\tR\x0eserviceAccount\x12E\n" +
+	"\x06labels\x18\a \x03(\v2-.podwatch.ingest.v1.ContainerInfo.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"t\n" +
+	"\vNetworkInfo\x12\x15\n" +
+	"\x06dst_ip\x18\x01 \x01(\tR\x05dstIp\x12\x19\n" +
+	"\bdst_port\x18\x02 \x01(\x05R\adstPort\x12\x14\n" +
+	"\x05proto\x18\x03 \x01(\tR\x05proto\x12\x1d\n" +
+	"\n" +
+	"dst_domain\x18\x04 \x01(\tR\tdstDomain\"\xa7\x03\n" +
+	"\fK8sAuditInfo\x12\x19\n" +
+	"\baudit_id\x18\x01 \x01(\tR\aauditId\x12\x12\n" +
+	"\x04user\x18\x02 \x01(\tR\x04user\x12\x16\n" +
+	"\x06groups\x18\x03 \x03(\tR\x06groups\x12+\n" +
+	"\x11impersonated_user\x18\x04 \x01(\tR\x10impersonatedUser\x12\x12\n" +
+	"\x04verb\x18\x05 \x01(\tR\x04verb\x12\x1b\n" +
+	"\tapi_group\x18\x06 \x01(\tR\bapiGroup\x12\x1a\n" +
+	"\bresource\x18\a \x01(\tR\bresource\x12 \n" +
+	"\vsubresource\x18\b \x01(\tR\vsubresource\x12\x1c\n" +
+	"\tnamespace\x18\t \x01(\tR\tnamespace\x12\x12\n" +
+	"\x04name\x18\n" +
+	" \x01(\tR\x04name\x12\x1f\n" +
+	"\vrequest_uri\x18\v \x01(\tR\n" +
+	"requestUri\x12#\n" +
+	"\rresponse_code\x18\f \x01(\x05R\fresponseCode\x12\x1d\n" +
+	"\n" +
+	"source_ips\x18\r \x03(\tR\tsourceIps\x12\x1d\n" +
+	"\n" +
+	"user_agent\x18\x0e \x01(\tR\tuserAgent2^\n" +
+	"\x06Ingest\x12T\n" +
+	"\fStreamEvents\x12'.podwatch.ingest.v1.StreamEventsRequest\x1a\x17.podwatch.ingest.v1.Ack(\x010\x01B+Z)github.com/podwatch/podwatch/pkg/ingestpbb\x06proto3"
+
+var (
+	file_ingest_proto_rawDescOnce sync.Once
+	file_ingest_proto_rawDescData []byte
+)
+
+func file_ingest_proto_rawDescGZIP() []byte {
+	file_ingest_proto_rawDescOnce.Do(func() {
+		file_ingest_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_ingest_proto_rawDesc), len(file_ingest_proto_rawDesc)))
+	})
+	return file_ingest_proto_rawDescData
+}
+
+var file_ingest_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_ingest_proto_goTypes = []any{
+	(*StreamEventsRequest)(nil),   // 0: podwatch.ingest.v1.StreamEventsRequest
+	(*Ack)(nil),                   // 1: podwatch.ingest.v1.Ack
+	(*Rejection)(nil),             // 2: podwatch.ingest.v1.Rejection
+	(*RuntimeEvent)(nil),          // 3: podwatch.ingest.v1.RuntimeEvent
+	(*ProcessInfo)(nil),           // 4: podwatch.ingest.v1.ProcessInfo
+	(*ContainerInfo)(nil),         // 5: podwatch.ingest.v1.ContainerInfo
+	(*NetworkInfo)(nil),           // 6: podwatch.ingest.v1.NetworkInfo
+	(*K8SAuditInfo)(nil),          // 7: podwatch.ingest.v1.K8sAuditInfo
+	nil,                           // 8: podwatch.ingest.v1.RuntimeEvent.MetadataEntry
+	nil,                           // 9: podwatch.ingest.v1.ContainerInfo.LabelsEntry
+	(*timestamppb.Timestamp)(nil), // 10: google.protobuf.Timestamp
+}
+var file_ingest_proto_depIdxs = []int32{
+	3,  // 0: podwatch.ingest.v1.StreamEventsRequest.event:type_name -> podwatch.ingest.v1.RuntimeEvent
+	2,  // 1: podwatch.ingest.v1.Ack.rejected:type_name -> podwatch.ingest.v1.Rejection
+	10, // 2: podwatch.ingest.v1.RuntimeEvent.ts:type_name -> google.protobuf.Timestamp
+	4,  // 3: podwatch.ingest.v1.RuntimeEvent.process:type_name -> podwatch.ingest.v1.ProcessInfo
+	5,  // 4: podwatch.ingest.v1.RuntimeEvent.container:type_name -> podwatch.ingest.v1.ContainerInfo
+	6,  // 5: podwatch.ingest.v1.RuntimeEvent.network:type_name -> podwatch.ingest.v1.NetworkInfo
+	7,  // 6: podwatch.ingest.v1.RuntimeEvent.k8s_audit:type_name -> podwatch.ingest.v1.K8sAuditInfo
+	8,  // 7: podwatch.ingest.v1.RuntimeEvent.metadata:type_name -> podwatch.ingest.v1.RuntimeEvent.MetadataEntry
+	9,  // 8: podwatch.ingest.v1.ContainerInfo.labels:type_name -> podwatch.ingest.v1.ContainerInfo.LabelsEntry
+	0,  // 9: podwatch.ingest.v1.Ingest.StreamEvents:input_type -> podwatch.ingest.v1.StreamEventsRequest
+	1,  // 10: podwatch.ingest.v1.Ingest.StreamEvents:output_type -> podwatch.ingest.v1.Ack
+	10, // [10:11] is the sub-list for method output_type
+	9,  // [9:10] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_ingest_proto_init() }
+func file_ingest_proto_init() {
+	if File_ingest_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_ingest_proto_rawDesc), len(file_ingest_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_ingest_proto_goTypes,
+		DependencyIndexes: file_ingest_proto_depIdxs,
+		MessageInfos:      file_ingest_proto_msgTypes,
+	}.Build()
+	File_ingest_proto = out.File
+	file_ingest_proto_goTypes = nil
+	file_ingest_proto_depIdxs = nil
+}
@@ -73,6 +73,7 @@ type TargetInfo struct {
 	ContainerID    string `json:"container_id"`
 	Node           string `json:"node"`
 	ServiceAccount string `json:"service_account"`
+	Workload       string `json:"workload,omitempty"` // Kind/name of the owning workload
 
 	// Image information
 	Image       string `json:"image"`
@@ -5,15 +5,40 @@ import (
 )
 
 type RuntimeEvent struct {
-	Timestamp time.Time      `json:"ts"`
-	ClusterID string         `json:"cluster_id"`
-	NodeID    string         `json:"node_id"`
-	EventType string         `json:"event_type"`
-	EventID   string         `json:"event_id"`
-	Process   *ProcessInfo   `json:"process,omitempty"`
-	Container *ContainerInfo `json:"container,omitempty"`
-	Network   *NetworkInfo   `json:"network,omitempty"`
-	RawRef    string         `json:"raw_ref,omitempty"`
+	Timestamp time.Time         `json:"ts"`
+	ClusterID string            `json:"cluster_id"`
+	NodeID    string            `json:"node_id"`
+	EventType string            `json:"event_type"`
+	EventID   string            `json:"event_id"`
+	Process   *ProcessInfo      `json:"process,omitempty"`
+	Container *ContainerInfo    `json:"container,omitempty"`
+	Network   *NetworkInfo      `json:"network,omitempty"`
+	K8sAudit  *K8sAuditInfo     `json:"k8s_audit,omitempty"`
+	Node      *NodeInfo         `json:"node,omitempty"`  // Set by enrich from the Node object
+	Intel     *IntelInfo        `json:"intel,omitempty"` // Threat intel matches, set by enrich
+	RawRef    string            `json:"raw_ref,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"` // Source-specific details, e.g. falco.rule
+	// Redactions lists the fields ingest masked secrets in
+	Redactions []Redaction `json:"redactions,omitempty"`
+	// ReceivedAt is when ingest accepted the event, by ingest's clock
+	ReceivedAt *time.Time `json:"received_at,omitempty"`
+	// ClockSkewMs is set when the node's clock is off by more than the
+	// tolerance: its estimated offset from ingest, positive when ahead
+	ClockSkewMs int64 `json:"clock_skew_ms,omitempty"`
+	// EnrichmentStatus says whether enrich found the event's container in
+	// its pod cache: hit, late_hit (after a delayed retry) or miss. It's
+	// empty for events without a container ID or from unwatched clusters.
+	EnrichmentStatus string `json:"enrichment_status,omitempty"`
+	// SensorTimestamp keeps the timestamp the sensor sent when ingest
+	// corrected ts for clock skew
+	SensorTimestamp *time.Time `json:"sensor_ts,omitempty"`
+}
+
+// Redaction records that Detector found and masked a secret in Field, e.g.
+// process.cmdline or container.labels.<key>
+type Redaction struct {
+	Field    string `json:"field"`
+	Detector string `json:"detector"`
 }
 
 type ProcessInfo struct {
@@ -26,16 +51,77 @@ type ProcessInfo struct {
 	Cwd               string   `json:"cwd"`
 	HasTTY            bool     `json:"has_tty"`
 	CapabilitiesAdded []string `json:"capabilities_added,omitempty"`
+	ExeSHA256         string   `json:"exe_sha256,omitempty"` // Hex SHA-256 of the executable, if the sensor reports it
+	// Ancestors is the parent process chain, nearest first, reconstructed
+	// by enrich from earlier events in the same container
+	Ancestors []ProcessAncestor `json:"ancestors,omitempty"`
+}
+
+// ProcessAncestor is a parent, grandparent, etc. of an event's process
+type ProcessAncestor struct {
+	PID     int    `json:"pid"`
+	Exe     string `json:"exe"`
+	Cmdline string `json:"cmdline"`
 }
 
 type ContainerInfo struct {
 	ContainerID    string            `json:"container_id"`
+	Name           string            `json:"name,omitempty"` // Container name in the pod spec
 	Image          string            `json:"image"`
 	ImageDigest    string            `json:"image_digest"`
 	Pod            string            `json:"pod"`
 	Namespace      string            `json:"namespace"`
 	ServiceAccount string            `json:"service_account"`
 	Labels         map[string]string `json:"labels"`
+	// WorkloadKind and WorkloadName identify the pod's top-level owner, e.g.
+	// Deployment/vuln-nginx, resolved by enrich
+	WorkloadKind string `json:"workload_kind,omitempty"`
+	WorkloadName string `json:"workload_name,omitempty"`
+	// SecurityContext is the container's effective security settings from
+	// the pod spec, set by enrich
+	SecurityContext *SecurityContext `json:"security_context,omitempty"`
+}
+
+// SecurityContext summarizes what a container can reach on its node
+type SecurityContext struct {
+	Privileged               bool `json:"privileged"`
+	AllowPrivilegeEscalation bool `json:"allow_privilege_escalation"`
+	// RunAsRoot is true unless runAsNonRoot or a non-zero runAsUser is set,
+	// since the image's user is otherwise unknown
+	RunAsRoot              bool     `json:"run_as_root"`
+	ReadOnlyRootFilesystem bool     `json:"read_only_root_filesystem"`
+	HostPID                bool     `json:"host_pid"`
+	HostNetwork            bool     `json:"host_network"`
+	HostIPC                bool     `json:"host_ipc"`
+	HostPathMounts         []string `json:"host_path_mounts,omitempty"` // Host paths mounted into the container
+	CapabilitiesAdded      []string `json:"capabilities_added,omitempty"`
+}
+
+// IntelInfo lists the threat intel indicators an event matched
+type IntelInfo struct {
+	Matches []IntelMatch `json:"matches"`
+}
+
+// IntelMatch is one indicator from a feed found in Field of an event, e.g. a
+// mining pool domain in process.cmdline
+type IntelMatch struct {
+	Feed       string   `json:"feed"`
+	Type       string   `json:"type"` // ip, domain or hash
+	Indicator  string   `json:"indicator"`
+	Field      string   `json:"field"`
+	Confidence int      `json:"confidence"` // 0-100
+	Labels     []string `json:"labels,omitempty"`
+}
+
+// NodeInfo describes the node an event came from
+type NodeInfo struct {
+	Labels                  map[string]string `json:"labels,omitempty"`
+	Zone                    string            `json:"zone,omitempty"`
+	Region                  string            `json:"region,omitempty"`
+	InstanceType            string            `json:"instance_type,omitempty"`
+	KernelVersion           string            `json:"kernel_version,omitempty"`
+	ContainerRuntimeVersion string            `json:"container_runtime_version,omitempty"` // e.g. containerd://1.7.2
+	ControlPlane            bool              `json:"control_plane"`
 }
 
 type NetworkInfo struct {
@@ -43,11 +129,40 @@ type NetworkInfo struct {
 	DstPort   int    `json:"dst_port"`
 	Proto     string `json:"proto"`
 	DstDomain string `json:"dst_domain"`
+	// DstKind is what dst_ip belongs to, resolved by enrich: pod, service,
+	// node or external. DstName and DstNamespace name the object.
+	DstKind      string `json:"dst_kind,omitempty"`
+	DstName      string `json:"dst_name,omitempty"`
+	DstNamespace string `json:"dst_namespace,omitempty"`
+	// DstCountry (ISO 3166 code), DstASN and DstASOrg describe external
+	// destinations, from enrich's GeoIP databases
+	DstCountry string `json:"dst_country,omitempty"`
+	DstASN     uint   `json:"dst_asn,omitempty"`
+	DstASOrg   string `json:"dst_as_org,omitempty"`
+}
+
+// K8sAuditInfo describes an API server request, for k8s_audit events
+type K8sAuditInfo struct {
+	AuditID          string   `json:"audit_id"`
+	User             string   `json:"user"`
+	Groups           []string `json:"groups,omitempty"`
+	ImpersonatedUser string   `json:"impersonated_user,omitempty"`
+	Verb             string   `json:"verb"`
+	APIGroup         string   `json:"api_group"`
+	Resource         string   `json:"resource"`
+	Subresource      string   `json:"subresource"`
+	Namespace        string   `json:"namespace"`
+	Name             string   `json:"name"`
+	RequestURI       string   `json:"request_uri"`
+	ResponseCode     int      `json:"response_code"`
+	SourceIPs        []string `json:"source_ips,omitempty"`
+	UserAgent        string   `json:"user_agent"`
 }
 
 type Alert struct {
 	ID          string        `json:"id"`
 	Timestamp   time.Time     `json:"timestamp"`
+	RuleID      string        `json:"rule_id,omitempty"`
 	RuleName    string        `json:"rule_name"`
 	Severity    string        `json:"severity"`
 	Description string        `json:"description"`
@@ -67,6 +182,47 @@ type Incident struct {
 	TriggeringEvent string    `json:"triggering_event_id"`
 }
 
+// DeadLetter is a message a pipeline stage rejected or could not process.
+// Subject is where it was consumed from and Consumer the durable consumer
+// that failed it; both are empty for payloads that never entered the
+// pipeline. Redrive is where Payload can be re-published once the cause is
+// fixed, or empty if it can't be.
+type DeadLetter struct {
+	ID        string            `json:"id"`
+	Timestamp time.Time         `json:"timestamp"`
+	Stage     string            `json:"stage"` // ingest, enrich, detect, incident, respond
+	Subject   string            `json:"subject,omitempty"`
+	Consumer  string            `json:"consumer,omitempty"`
+	Redrive   string            `json:"redrive,omitempty"`
+	Error     string            `json:"error"`
+	Source    *DeadLetterSource `json:"source,omitempty"`
+	Payload   []byte            `json:"payload"`
+}
+
+// DeadLetterSource identifies who sent a dead-lettered message
+type DeadLetterSource struct {
+	ClusterID   string `json:"cluster_id,omitempty"`
+	NodeID      string `json:"node_id,omitempty"`
+	RemoteAddr  string `json:"remote_addr,omitempty"`
+	CertSubject string `json:"cert_subject,omitempty"`
+}
+
+// Sensor is a node's entry in the fleet inventory, updated from the events
+// and heartbeats ingest receives for it
+type Sensor struct {
+	ClusterID     string    `json:"cluster_id"`
+	NodeID        string    `json:"node_id"`
+	Source        string    `json:"source,omitempty"` // falco, tetragon, tracee, ...
+	SensorVersion string    `json:"sensor_version,omitempty"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	LastEvent     time.Time `json:"last_event"`
+	EventsTotal   uint64    `json:"events_total"`
+	EventRate     float64   `json:"event_rate"`       // events per second over the last minute
+	ClockSkewMs   int64     `json:"clock_skew_ms"`    // estimated offset from ingest's clock, positive when ahead
+	Status        string    `json:"status,omitempty"` // active, silent
+}
+
 type Rule struct {
 	ID          string `json:"id" yaml:"id"`
 	Name        string `json:"name" yaml:"name"`
@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/podwatch/podwatch/pkg/models"
+)
+
+// DeadLetterSubject returns the subject dead letters from stage are published to
+func DeadLetterSubject(stage string) string {
+	return "deadletter." + stage
+}
+
+// DeadLetter publishes a message stage consumed from msg but rejected or
+// could not process to deadletter.<stage>, keeping the original payload and
+// the consumer that failed it, so it can be inspected and re-driven to that
+// consumer alone. Publish failures are only logged, since the caller is
+// already handling an error.
+func DeadLetter(js jetstream.JetStream, stage string, msg jetstream.Msg, cause error, source *models.DeadLetterSource) {
+	dl := models.DeadLetter{
+		Stage:   stage,
+		Subject: msg.Subject(),
+		Source:  source,
+		Payload: msg.Data(),
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		dl.Consumer = meta.Consumer
+		dl.Redrive = RedriveSubject(meta.Consumer)
+	}
+	if source == nil {
+		dl.Source = SourceFromSubject(msg.Subject())
+	}
+	publishDeadLetter(js, dl, cause)
+}
+
+// DeadLetterPayload publishes a payload stage rejected before it entered the
+// pipeline to deadletter.<stage>. redrive is the subject it can be re-driven
+// to, or "" if it can't be.
+func DeadLetterPayload(js jetstream.JetStream, stage, redrive string, payload []byte, cause error, source *models.DeadLetterSource) {
+	publishDeadLetter(js, models.DeadLetter{
+		Stage:   stage,
+		Redrive: redrive,
+		Source:  source,
+		Payload: payload,
+	}, cause)
+}
+
+func publishDeadLetter(js jetstream.JetStream, dl models.DeadLetter, cause error) {
+	if js == nil {
+		return
+	}
+	dl.ID = uuid.New().String()
+	dl.Timestamp = time.Now().UTC()
+	dl.Error = cause.Error()
+
+	data, err := json.Marshal(dl)
+	if err != nil {
+		log.Printf("Error encoding dead letter: %v", err)
+		return
+	}
+	if err := Publish(js, DeadLetterSubject(dl.Stage), data, jetstream.WithMsgID(dl.ID)); err != nil {
+		log.Printf("Error publishing dead letter for %s: %v (cause: %v)", dl.Stage, err, cause)
+	}
+}
+
+// Retry has msg redelivered after a failure that may go away, such as a
+// failed publish, backing off by retryDelay per delivery. Since the server
+// drops a message once it has been delivered JETSTREAM_MAX_DELIVER times, the
+// last delivery is dead-lettered as stage's and terminated instead.
+func Retry(js jetstream.JetStream, stage string, msg jetstream.Msg, cause error, source *models.DeadLetterSource) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		msg.Nak()
+		return
+	}
+	if meta.NumDelivered >= uint64(MaxDeliver()) {
+		DeadLetter(js, stage, msg, cause, source)
+		msg.Term()
+		return
+	}
+	msg.NakWithDelay(time.Duration(meta.NumDelivered) * retryDelay)
+}
+
+// SourceFromSubject recovers the cluster and node from an
+// events.raw.<cluster>.<node> subject, or returns nil for other subjects
+func SourceFromSubject(subject string) *models.DeadLetterSource {
+	parts := strings.SplitN(subject, ".", 4)
+	if len(parts) != 4 || parts[0] != "events" || parts[1] != "raw" {
+		return nil
+	}
+	return &models.DeadLetterSource{ClusterID: parts[2], NodeID: parts[3]}
+}
@@ -0,0 +1,259 @@
+// Package pipeline defines the JetStream streams and durable consumers that
+// carry events and alerts between PodWatch services.
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Stream names
+const (
+	StreamEventsRaw       = "EVENTS_RAW"
+	StreamEventsEnriched  = "EVENTS_ENRICHED"
+	StreamAlerts          = "ALERTS"
+	StreamAlertsProcessed = "ALERTS_PROCESSED"
+	StreamDeadLetter      = "DEADLETTER"
+	StreamIngestRedrive   = "INGEST_REDRIVE"
+)
+
+// Subjects
+const (
+	SubjectEventsRaw       = "events.raw.>"
+	SubjectEventsEnriched  = "events.enriched"
+	SubjectAlerts          = "alerts"
+	SubjectAlertsProcessed = "alerts.processed"
+	SubjectDeadLetter      = "deadletter.>"
+)
+
+// Durable consumer names
+const (
+	ConsumerEnrich      = "enrich-workers"
+	ConsumerDetect      = "detect-workers"
+	ConsumerDetectRaw   = "detect-raw"
+	ConsumerIncident    = "incident-workers"
+	ConsumerDeadLetters = "incident-deadletters"
+	ConsumerRespond     = "respond-workers"
+	ConsumerIngest      = "ingest"
+)
+
+const (
+	defaultMaxAge     = 24 * time.Hour
+	defaultMaxBytes   = 1 << 30 // 1GiB per stream
+	defaultMaxDeliver = 5
+	defaultAckWait    = 30 * time.Second
+	retryDelay        = 2 * time.Second
+	setupTimeout      = 10 * time.Second
+)
+
+// Streams returns the stream definitions for the pipeline. Streams keep a
+// message until every consumer on it has acked it, so producers see
+// backpressure only when consumers fall behind: new messages are rejected
+// once a stream holds JETSTREAM_MAX_BYTES of unacked messages, instead of
+// silently losing older, unprocessed ones. Messages nobody acks within
+// JETSTREAM_MAX_AGE are dropped.
+func Streams() []jetstream.StreamConfig {
+	maxAge := defaultMaxAge
+	if v, err := time.ParseDuration(os.Getenv("JETSTREAM_MAX_AGE")); err == nil && v > 0 {
+		maxAge = v
+	}
+	maxBytes := int64(defaultMaxBytes)
+	if v, err := strconv.ParseInt(os.Getenv("JETSTREAM_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		maxBytes = v
+	}
+
+	stream := func(name, subject string) jetstream.StreamConfig {
+		subjects := []string{subject}
+		for _, c := range append(Consumers(), OptionalConsumers()...) {
+			if c.Stream == name && redrives(c) {
+				subjects = append(subjects, RedriveSubject(c.Durable))
+			}
+		}
+		return jetstream.StreamConfig{
+			Name:      name,
+			Subjects:  subjects,
+			Retention: jetstream.InterestPolicy,
+			Storage:   jetstream.FileStorage,
+			Discard:   jetstream.DiscardNew,
+			MaxAge:    maxAge,
+			MaxBytes:  maxBytes,
+		}
+	}
+
+	return []jetstream.StreamConfig{
+		stream(StreamEventsRaw, SubjectEventsRaw),
+		stream(StreamEventsEnriched, SubjectEventsEnriched),
+		stream(StreamAlerts, SubjectAlerts),
+		stream(StreamAlertsProcessed, SubjectAlertsProcessed),
+		stream(StreamDeadLetter, SubjectDeadLetter),
+		stream(StreamIngestRedrive, RedriveSubject(ConsumerIngest)),
+	}
+}
+
+// Consumer is a durable consumer of a pipeline stream
+type Consumer struct {
+	Stream  string
+	Durable string
+	Filter  string
+}
+
+// Consumers returns every durable consumer in the pipeline. They are created
+// along with the streams, since an interest-based stream only keeps messages
+// for consumers that already exist: events published before enrich or detect
+// first start would otherwise be lost.
+func Consumers() []Consumer {
+	return []Consumer{
+		{StreamEventsRaw, ConsumerEnrich, SubjectEventsRaw},
+		{StreamEventsEnriched, ConsumerDetect, SubjectEventsEnriched},
+		{StreamAlerts, ConsumerIncident, SubjectAlerts},
+		{StreamAlertsProcessed, ConsumerRespond, SubjectAlertsProcessed},
+		{StreamDeadLetter, ConsumerDeadLetters, SubjectDeadLetter},
+		{StreamIngestRedrive, ConsumerIngest, RedriveSubject(ConsumerIngest)},
+	}
+}
+
+// OptionalConsumers returns consumers that only the service reading them
+// creates, when it is configured to. Until then they hold no messages on
+// their stream. detect-raw runs detection on raw events for deployments
+// without enrich (DETECT_RAW_EVENTS).
+func OptionalConsumers() []Consumer {
+	return []Consumer{
+		{StreamEventsRaw, ConsumerDetectRaw, SubjectEventsRaw},
+	}
+}
+
+// RedriveSubject is the subject dead letters from consumer are re-driven to.
+// Each consumer reads its own redrive subject besides its filter, so a
+// re-driven message reaches only the consumer that failed it rather than
+// every consumer on the stream.
+func RedriveSubject(consumer string) string {
+	return "redrive." + consumer
+}
+
+// redrives reports whether c reads a redrive subject besides its filter.
+// Dead letters are never re-driven, and ingest's filter is its redrive
+// subject.
+func redrives(c Consumer) bool {
+	return c.Durable != ConsumerDeadLetters && c.Filter != RedriveSubject(c.Durable)
+}
+
+// MaxDeliver is how many times a message is delivered to a consumer before
+// it is given up on, set by JETSTREAM_MAX_DELIVER
+func MaxDeliver() int {
+	if v, err := strconv.Atoi(os.Getenv("JETSTREAM_MAX_DELIVER")); err == nil && v > 0 {
+		return v
+	}
+	return defaultMaxDeliver
+}
+
+func consumerConfig(durable, filter string) jetstream.ConsumerConfig {
+	cfg := jetstream.ConsumerConfig{
+		Durable:        durable,
+		FilterSubjects: []string{filter},
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		AckWait:        defaultAckWait,
+		MaxDeliver:     MaxDeliver(),
+		DeliverPolicy:  jetstream.DeliverAllPolicy,
+	}
+	if redrives(Consumer{Durable: durable, Filter: filter}) {
+		cfg.FilterSubjects = append(cfg.FilterSubjects, RedriveSubject(durable))
+	}
+	// Dead letters have nowhere further to go, so storing them is retried
+	// until it succeeds
+	if durable == ConsumerDeadLetters {
+		cfg.MaxDeliver = -1
+	}
+	return cfg
+}
+
+// New creates a JetStream context on nc and makes sure all pipeline streams
+// and their consumers exist
+func New(nc *nats.Conn) (jetstream.JetStream, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	for _, cfg := range Streams() {
+		if _, err := js.CreateOrUpdateStream(ctx, cfg); err != nil {
+			return nil, fmt.Errorf("failed to create stream %s: %w", cfg.Name, err)
+		}
+	}
+	for _, c := range Consumers() {
+		if _, err := js.CreateOrUpdateConsumer(ctx, c.Stream, consumerConfig(c.Durable, c.Filter)); err != nil {
+			return nil, fmt.Errorf("failed to create consumer %s on %s: %w", c.Durable, c.Stream, err)
+		}
+	}
+	return js, nil
+}
+
+// Consume starts a durable, explicit-ack consumer on stream and delivers
+// messages to handler. The handler is responsible for acking: Ack on success,
+// Retry for failures that may go away, Term (after DeadLetter) for messages
+// that can never be processed. Messages that are not acked are redelivered up
+// to JETSTREAM_MAX_DELIVER times.
+func Consume(js jetstream.JetStream, stream, durable, filter string, handler jetstream.MessageHandler) (jetstream.ConsumeContext, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	consumer, err := js.CreateOrUpdateConsumer(ctx, stream, consumerConfig(durable, filter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer %s on %s: %w", durable, stream, err)
+	}
+
+	return consumer.Consume(handler)
+}
+
+// DeleteConsumer removes a durable consumer from stream, if it exists, so an
+// interest-based stream stops keeping messages for it
+func DeleteConsumer(js jetstream.JetStream, stream, durable string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	err := js.DeleteConsumer(ctx, stream, durable)
+	if err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return fmt.Errorf("failed to delete consumer %s on %s: %w", durable, stream, err)
+	}
+	return nil
+}
+
+// KeyToken encodes s as one token of a key-value key. Tokens are base64url,
+// so distinct values never share a token, and a token never holds a dot or
+// a character keys don't allow. s must not be empty.
+func KeyToken(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// Publish publishes data to subject and waits for the stream to store it
+func Publish(js jetstream.JetStream, subject string, data []byte, opts ...jetstream.PublishOpt) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := js.Publish(ctx, subject, data, opts...)
+	return err
+}
+
+// IsUnavailable reports whether a publish error means the stream can't take
+// more messages right now (stream full, no JetStream response, or timeout),
+// as opposed to a permanent failure. Producers should surface this as
+// backpressure.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *jetstream.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == 503 {
+		return true
+	}
+	return errors.Is(err, jetstream.ErrNoStreamResponse) ||
+		errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
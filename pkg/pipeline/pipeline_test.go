@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestStreams_RedriveSubjects(t *testing.T) {
+	subjects := map[string][]string{}
+	for _, cfg := range Streams() {
+		subjects[cfg.Name] = cfg.Subjects
+	}
+
+	want := map[string][]string{
+		StreamEventsRaw:     {SubjectEventsRaw, "redrive.enrich-workers", "redrive.detect-raw"},
+		StreamDeadLetter:    {SubjectDeadLetter},
+		StreamIngestRedrive: {"redrive.ingest"},
+	}
+	for name, w := range want {
+		if !slices.Equal(subjects[name], w) {
+			t.Errorf("Expected %s subjects %v, got %v", name, w, subjects[name])
+		}
+	}
+}
+
+func TestConsumers_DetectRawOptional(t *testing.T) {
+	for _, c := range Consumers() {
+		if c.Durable == ConsumerDetectRaw {
+			t.Errorf("Expected %s to be created only by detect when enabled", ConsumerDetectRaw)
+		}
+	}
+}
+
+func TestConsumerConfig_FilterSubjects(t *testing.T) {
+	for _, c := range Consumers() {
+		cfg := consumerConfig(c.Durable, c.Filter)
+		want := []string{c.Filter, RedriveSubject(c.Durable)}
+		if c.Durable == ConsumerDeadLetters || c.Durable == ConsumerIngest {
+			want = []string{c.Filter}
+		}
+		if !slices.Equal(cfg.FilterSubjects, want) {
+			t.Errorf("Expected %s to filter on %v, got %v", c.Durable, want, cfg.FilterSubjects)
+		}
+	}
+}
+
+func TestKeyToken(t *testing.T) {
+	valid := regexp.MustCompile(`^[-_=a-zA-Z0-9]+$`)
+	tokens := map[string]string{}
+	for _, s := range []string{"c1/n1/containerd://x", "c1/n1/containerd__//x", ".", "..", "a.b"} {
+		token := KeyToken(s)
+		if !valid.MatchString(token) {
+			t.Errorf("Expected a valid key token for %q, got %q", s, token)
+		}
+		if prev, ok := tokens[token]; ok {
+			t.Errorf("Expected distinct tokens, got %q for %q and %q", token, prev, s)
+		}
+		tokens[token] = s
+	}
+}
+
+func TestSourceFromSubject(t *testing.T) {
+	source := SourceFromSubject("events.raw.prod.node-1")
+	if source == nil || source.ClusterID != "prod" || source.NodeID != "node-1" {
+		t.Errorf("Expected prod/node-1, got %+v", source)
+	}
+	for _, subject := range []string{RedriveSubject(ConsumerEnrich), SubjectAlerts, "events.raw.prod"} {
+		if source := SourceFromSubject(subject); source != nil {
+			t.Errorf("Expected no source for %s, got %+v", subject, source)
+		}
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"os"
 	"time"
 
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/podwatch/podwatch/pkg/logging"
 	"github.com/podwatch/podwatch/pkg/models"
+	"github.com/podwatch/podwatch/pkg/pipeline"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -21,6 +24,7 @@ import (
 var (
 	db        *sql.DB
 	natsConn  *nats.Conn
+	js        jetstream.JetStream
 	clientset *kubernetes.Clientset
 	ctx       = context.Background()
 	logger    *logging.Logger
@@ -93,12 +97,18 @@ func main() {
 	}
 	defer natsConn.Close()
 
+	js, err = pipeline.New(natsConn)
+	if err != nil {
+		logger.Error("Failed to set up JetStream", err, nil)
+		os.Exit(1)
+	}
+
 	logger.Info("Response orchestrator started", map[string]interface{}{
 		"protected_namespaces": []string{"kube-system", "security-system"},
 	})
 
 	// 4. Subscribe
-	_, err = natsConn.QueueSubscribe("alerts.processed", "respond-workers", func(msg *nats.Msg) {
+	_, err = pipeline.Consume(js, pipeline.StreamAlertsProcessed, pipeline.ConsumerRespond, pipeline.SubjectAlertsProcessed, func(msg jetstream.Msg) {
 		handleAlert(msg)
 	})
 	if err != nil {
@@ -109,12 +119,17 @@ func main() {
 	select {}
 }
 
-func handleAlert(msg *nats.Msg) {
+func handleAlert(msg jetstream.Msg) {
 	var alert models.Alert
-	if err := json.Unmarshal(msg.Data, &alert); err != nil {
+	if err := json.Unmarshal(msg.Data(), &alert); err != nil {
 		logger.Error("Failed to decode alert", err, nil)
+		pipeline.DeadLetter(js, "respond", msg, err, nil)
+		msg.Term()
 		return
 	}
+	// Response actions are not safe to repeat, so the alert is acked up front
+	// and never redelivered once an action has been attempted
+	msg.Ack()
 
 	if alert.Response == "" {
 		return
@@ -134,6 +149,9 @@ func handleAlert(msg *nats.Msg) {
 			target.Pod = podName
 			target.ContainerID = alert.Event.Container.ContainerID
 			target.Image = alert.Event.Container.Image
+			if alert.Event.Container.WorkloadKind != "" {
+				target.Workload = alert.Event.Container.WorkloadKind + "/" + alert.Event.Container.WorkloadName
+			}
 		}
 		nodeName = alert.Event.NodeID
 		target.Node = nodeName
@@ -153,13 +171,14 @@ func handleAlert(msg *nats.Msg) {
 	}
 
 	// Execute response
+	var err error
 	switch alert.Response {
 	case "kill_pod":
-		executeKillPod(alert, namespace, podName, target)
+		err = executeKillPod(alert, namespace, podName, target)
 	case "quarantine_namespace":
-		executeQuarantineNamespace(alert, namespace, target)
+		err = executeQuarantineNamespace(alert, namespace, target)
 	case "isolate_node":
-		executeIsolateNode(alert, nodeName, target)
+		err = executeIsolateNode(alert, nodeName, target)
 	case "evidence_bundle":
 		executeEvidenceBundle(alert, target)
 	default:
@@ -169,21 +188,29 @@ func handleAlert(msg *nats.Msg) {
 			"incident_id": alert.IncidentID,
 		})
 	}
+	if err != nil {
+		// The alert is never redelivered, so a failed action is dead-lettered
+		// to be re-driven once the cause is fixed
+		pipeline.DeadLetter(js, "respond", msg, err, &models.DeadLetterSource{
+			ClusterID: target.ClusterID,
+			NodeID:    target.Node,
+		})
+	}
 }
 
-func executeKillPod(alert models.Alert, namespace, podName string, target *logging.TargetInfo) {
+func executeKillPod(alert models.Alert, namespace, podName string, target *logging.TargetInfo) error {
 	startTime := time.Now()
 
 	if protectedNamespaces[namespace] {
 		logResponseAction(alert.IncidentID, logging.ResponseKillPod, podName, logging.StatusBlocked,
 			"Protected namespace", true, "Namespace is protected", target)
-		return
+		return nil
 	}
 
 	if namespace == "" || podName == "" {
 		logResponseAction(alert.IncidentID, logging.ResponseKillPod, "unknown", logging.StatusFailed,
 			"Missing namespace or pod name", false, "", target)
-		return
+		return errors.New("missing namespace or pod name")
 	}
 
 	err := clientset.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{})
@@ -198,7 +225,7 @@ func executeKillPod(alert models.Alert, namespace, podName string, target *loggi
 			Reason:   err.Error(),
 			Duration: duration,
 		}, target, alert.IncidentID)
-		return
+		return err
 	}
 
 	logResponseAction(alert.IncidentID, logging.ResponseKillPod, namespace+"/"+podName, logging.StatusSuccess,
@@ -210,21 +237,22 @@ func executeKillPod(alert models.Alert, namespace, podName string, target *loggi
 		Reason:   "Malicious activity detected",
 		Duration: duration,
 	}, target, alert.IncidentID)
+	return nil
 }
 
-func executeQuarantineNamespace(alert models.Alert, namespace string, target *logging.TargetInfo) {
+func executeQuarantineNamespace(alert models.Alert, namespace string, target *logging.TargetInfo) error {
 	startTime := time.Now()
 
 	if protectedNamespaces[namespace] {
 		logResponseAction(alert.IncidentID, logging.ResponseQuarantineNS, namespace, logging.StatusBlocked,
 			"Protected namespace", true, "Namespace is protected", target)
-		return
+		return nil
 	}
 
 	if namespace == "" {
 		logResponseAction(alert.IncidentID, logging.ResponseQuarantineNS, "unknown", logging.StatusFailed,
 			"Missing namespace", false, "", target)
-		return
+		return errors.New("missing namespace")
 	}
 
 	duration := time.Since(startTime).Milliseconds()
@@ -239,34 +267,35 @@ func executeQuarantineNamespace(alert models.Alert, namespace string, target *lo
 		Duration: duration,
 		Playbook: "quarantine_namespace",
 	}, target, alert.IncidentID)
+	return nil
 }
 
-func executeIsolateNode(alert models.Alert, nodeName string, target *logging.TargetInfo) {
+func executeIsolateNode(alert models.Alert, nodeName string, target *logging.TargetInfo) error {
 	startTime := time.Now()
 
 	if nodeName == "" {
 		logResponseAction(alert.IncidentID, logging.ResponseIsolateNode, "unknown", logging.StatusFailed,
 			"Missing node name", false, "", target)
-		return
+		return errors.New("missing node name")
 	}
 
 	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 	if err != nil {
 		logResponseAction(alert.IncidentID, logging.ResponseIsolateNode, nodeName, logging.StatusFailed,
 			err.Error(), false, "", target)
-		return
+		return err
 	}
 
 	// Never isolate control plane
 	if _, ok := node.Labels["node-role.kubernetes.io/control-plane"]; ok {
 		logResponseAction(alert.IncidentID, logging.ResponseIsolateNode, nodeName, logging.StatusBlocked,
 			"Control plane node", true, "Cannot isolate control plane", target)
-		return
+		return nil
 	}
 	if _, ok := node.Labels["node-role.kubernetes.io/master"]; ok {
 		logResponseAction(alert.IncidentID, logging.ResponseIsolateNode, nodeName, logging.StatusBlocked,
 			"Master node", true, "Cannot isolate master node", target)
-		return
+		return nil
 	}
 
 	node.Spec.Unschedulable = true
@@ -276,7 +305,7 @@ func executeIsolateNode(alert models.Alert, nodeName string, target *logging.Tar
 	if err != nil {
 		logResponseAction(alert.IncidentID, logging.ResponseIsolateNode, nodeName, logging.StatusFailed,
 			err.Error(), false, "", target)
-		return
+		return err
 	}
 
 	logResponseAction(alert.IncidentID, logging.ResponseIsolateNode, nodeName, logging.StatusSuccess,
@@ -289,6 +318,7 @@ func executeIsolateNode(alert models.Alert, nodeName string, target *logging.Tar
 		Duration: duration,
 		Playbook: "isolate_node",
 	}, target, alert.IncidentID)
+	return nil
 }
 
 func executeEvidenceBundle(alert models.Alert, target *logging.TargetInfo) {